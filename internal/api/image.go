@@ -1,26 +1,35 @@
 package api
 
 import (
+	"bufio"
+	"cmp"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	utils "mediaflow/internal"
+	"mediaflow/internal/auth"
 	"mediaflow/internal/config"
+	"mediaflow/internal/objectkey"
 	"mediaflow/internal/response"
 	"mediaflow/internal/service"
+	"mediaflow/internal/storage"
 )
 
 type ImageAPI struct {
-	imageService  *service.ImageService
-	storageConfig *config.StorageConfig
-	ctx           context.Context
+	imageService    *service.ImageService
+	storageConfig   *config.Store
+	ctx             context.Context
+	transformSecret []byte // HMAC key for /t/ URLs; empty disables them
 }
 
-func NewImageAPI(ctx context.Context, imageService *service.ImageService, storageConfig *config.StorageConfig) *ImageAPI {
+func NewImageAPI(ctx context.Context, imageService *service.ImageService, storageConfig *config.Store) *ImageAPI {
 	return &ImageAPI{
 		imageService:  imageService,
 		storageConfig: storageConfig,
@@ -37,6 +46,9 @@ func (h *ImageAPI) HandleThumbnailTypes(w http.ResponseWriter, r *http.Request)
 	var mimeType string
 
 	if r.Method == http.MethodPost {
+		if !authorize(w, r, auth.ScopeThumbWrite, thumbType) {
+			return
+		}
 		file, _, err := r.FormFile("file")
 		if err != nil {
 			response.JSON(err.Error()).WriteError(w, http.StatusBadRequest)
@@ -65,14 +77,18 @@ func (h *ImageAPI) HandleThumbnailTypes(w http.ResponseWriter, r *http.Request)
 }
 
 func (h *ImageAPI) HandleThumbnailType(w http.ResponseWriter, r *http.Request, imageData []byte, thumbType, imagePath string) {
-	profile := h.storageConfig.GetProfile(thumbType)
+	profile := h.storageConfig.Current().GetProfile(thumbType)
 	if profile == nil {
 		response.JSON(fmt.Sprintf("Profile '%s' not found", thumbType)).WriteError(w, http.StatusNotFound)
 		return
 	}
 	baseName := utils.BaseName(imagePath)
+	ctx, ok := h.assetContext(w, r)
+	if !ok {
+		return
+	}
 	if r.Method == http.MethodPost {
-		err := h.imageService.UploadImage(h.ctx, profile, imageData, thumbType, baseName)
+		err := h.imageService.UploadImage(ctx, profile, imageData, thumbType, baseName)
 		if err != nil {
 			response.JSON(err.Error()).WriteError(w, http.StatusInternalServerError)
 			return
@@ -80,14 +96,37 @@ func (h *ImageAPI) HandleThumbnailType(w http.ResponseWriter, r *http.Request, i
 	}
 
 	if r.Method == http.MethodGet {
-		size, _, err := parseQueryParams(r)
+		if !authorizeRead(w, r, thumbType, profile, baseName) {
+			return
+		}
+		size, variant, quality, err := parseQueryParams(r)
 		if err != nil {
 			response.JSON(err.Error()).WriteError(w, http.StatusBadRequest)
 			return
 		}
-		imageData, err := h.imageService.GetImage(h.ctx, profile, false, baseName, size)
-		if err != nil {
-			response.JSON(err.Error()).WriteError(w, http.StatusInternalServerError)
+		if variant != "" {
+			if !profile.IsVariant(variant) {
+				response.JSON(fmt.Sprintf("Variant '%s' not found for profile '%s'", variant, thumbType)).WriteError(w, http.StatusBadRequest)
+				return
+			}
+			size = variant
+		}
+		q, _ := strconv.Atoi(quality)
+		format := profile.OutputFormat()
+		// Variants with a format always use it; the rest follow the profile's convert_to
+		if v, ok := profile.Variants[cmp.Or(size, profile.DefaultSize)]; ok && v.Format != "" {
+			format = profile.VariantFormat(v)
+		} else if profile.ConvertTo == config.ConvertAuto {
+			format = negotiateFormat(r.Header.Get("Accept"))
+			w.Header().Set("Vary", "Accept")
+		}
+		if redirectsPrivate(profile) {
+			url, err := h.imageService.PresignThumbnail(ctx, profile, baseName, size, q, format, privateRedirectTTL)
+			if err != nil {
+				writeImageError(w, err)
+				return
+			}
+			writePresignedRedirect(w, r, url)
 			return
 		}
 		cd := profile.CacheDuration
@@ -95,10 +134,28 @@ func (h *ImageAPI) HandleThumbnailType(w http.ResponseWriter, r *http.Request, i
 			// 24 hours
 			cd = 86400
 		}
+		w.Header().Set("Cache-Control", cacheControl(profile, cd))
 
-		w.Header().Set("Content-Type", "image/"+profile.ConvertTo)
-		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", cd))
-		w.Header().Set("ETag", fmt.Sprintf(`"%s/%s_%s"`, thumbType, baseName, size))
+		// Revalidate against the stored (or cached) thumbnail before fetching it
+		if hasConditionals(r) {
+			info, err := h.imageService.StatThumbnail(ctx, profile, baseName, size, q, format)
+			if err != nil && !errors.Is(err, storage.ErrNotFound) {
+				writeImageError(w, err)
+				return
+			}
+			if info != nil && checkNotModified(w, r, info) {
+				return
+			}
+		}
+
+		imageData, info, err := h.imageService.GetThumbnail(ctx, profile, baseName, size, q, format)
+		if err != nil {
+			writeImageError(w, err)
+			return
+		}
+		setValidators(w, info)
+
+		w.Header().Set("Content-Type", "image/"+format)
 		w.Write(imageData) //nolint:errcheck
 	}
 }
@@ -109,51 +166,260 @@ func (h *ImageAPI) HandleOriginals(w http.ResponseWriter, r *http.Request) {
 	fileName := parts[1]
 	baseName := utils.BaseName(fileName)
 
-	profile := h.storageConfig.GetProfile(thumbType)
+	if !authorize(w, r, "", thumbType) {
+		return
+	}
+	profile := h.storageConfig.Current().GetProfile(thumbType)
 	if profile == nil {
 		response.JSON(fmt.Sprintf("Profile '%s' not found", thumbType)).WriteError(w, http.StatusNotFound)
 		return
 	}
+	ctx, ok := h.assetContext(w, r)
+	if !ok {
+		return
+	}
 	if r.Method == http.MethodGet {
-		imageData, err := h.imageService.GetImage(h.ctx, profile, true, baseName, "")
+		if !authorizeRead(w, r, thumbType, profile, baseName) {
+			return
+		}
+		if redirectsPrivate(profile) {
+			url, err := h.imageService.PresignOriginal(ctx, profile, baseName, privateRedirectTTL)
+			if err != nil {
+				writeImageError(w, err)
+				return
+			}
+			writePresignedRedirect(w, r, url)
+			return
+		}
+		w.Header().Set("Cache-Control", cacheControl(profile, profile.CacheDuration))
+		w.Header().Set("Accept-Ranges", "bytes")
+
+		// Only pay for a HEAD when the answer may be a 304 or a partial response
+		rangeHeader := r.Header.Get("Range")
+		if hasConditionals(r) || rangeHeader != "" {
+			info, err := h.imageService.StatOriginal(ctx, profile, baseName)
+			if err != nil {
+				writeImageError(w, err)
+				return
+			}
+			if checkNotModified(w, r, info) {
+				return
+			}
+			if rangeHeader != "" && rangeApplies(r, info) {
+				h.serveOriginalRanges(ctx, w, profile, baseName, info, rangeHeader)
+				return
+			}
+		}
+
+		body, info, err := h.imageService.OpenOriginal(ctx, profile, baseName)
 		if err != nil {
-			response.JSON(err.Error()).WriteError(w, http.StatusInternalServerError)
+			writeImageError(w, err)
 			return
 		}
-		w.Header().Set("Content-Type", "image/"+profile.ConvertTo)
-		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", profile.CacheDuration))
-		w.Header().Set("ETag", fmt.Sprintf(`"%s/%s"`, thumbType, baseName))
-		w.Write(imageData) //nolint:errcheck
+		defer body.Close()
+
+		// Peek so sniffing never buffers more than the first 512 bytes
+		reader := bufio.NewReader(body)
+		head, _ := reader.Peek(512)
+
+		setValidators(w, info)
+		w.Header().Set("Content-Type", originalContentType(info, head))
+		if info.Size > 0 {
+			w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+		}
+		if _, err := io.Copy(w, reader); err != nil {
+			fmt.Printf("Failed to stream original %s: %v\n", baseName, err)
+		}
 	}
 
 }
 
+// HandleCacheStats handles GET /v1/cache/stats, reporting thumbnail cache counters
+func (h *ImageAPI) HandleCacheStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		response.JSON("Method not allowed").WriteError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorize(w, r, auth.ScopeAdmin, "") {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(h.imageService.Cache.Stats())
+}
+
 // Helpers that belong here
 
-// Parse query params for width and quality
-func parseQueryParams(r *http.Request) (width, quality string, err error) {
+// authorize writes a 403 and returns false when the request's API key lacks scope or may not use profile
+func authorize(w http.ResponseWriter, r *http.Request, scope, profile string) bool {
+	if err := auth.Authorize(r.Context(), scope, profile); err != nil {
+		response.JSON(err.Error()).WriteError(w, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// assetContext returns the context to read or upload an asset with: h.ctx carrying the ?tenant=
+// its original was presigned for. It writes a 400 and returns false for tenants presign rejects.
+func (h *ImageAPI) assetContext(w http.ResponseWriter, r *http.Request) (context.Context, bool) {
+	tenant := r.URL.Query().Get("tenant")
+	if strings.Contains(tenant, "/") || strings.Contains(tenant, "..") {
+		response.JSON("tenant can't contain '/' or '..'").WriteError(w, http.StatusBadRequest)
+		return nil, false
+	}
+	return service.WithTenant(h.ctx, tenant), true
+}
+
+// writeImageError maps service errors to HTTP status codes
+func writeImageError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		response.JSON("Image not found").WriteError(w, http.StatusNotFound)
+	case errors.Is(err, objectkey.ErrAmbiguousKey):
+		response.JSON(err.Error()).WriteError(w, http.StatusConflict)
+	case errors.Is(err, service.ErrSizeNotAllowed), errors.Is(err, service.ErrQualityNotAllowed):
+		response.JSON(err.Error()).WriteError(w, http.StatusBadRequest)
+	default:
+		response.JSON(err.Error()).WriteError(w, http.StatusInternalServerError)
+	}
+}
+
+// setValidators sets the ETag and Last-Modified headers from stored object metadata
+func setValidators(w http.ResponseWriter, info *storage.ObjectInfo) {
+	if info.ETag != "" {
+		w.Header().Set("ETag", info.ETag)
+	}
+	if !info.LastModified.IsZero() {
+		w.Header().Set("Last-Modified", info.LastModified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// checkNotModified evaluates If-None-Match and If-Modified-Since against the stored object.
+// If the client's copy is current it writes a 304 (with validators) and returns true.
+// If-None-Match takes precedence over If-Modified-Since (RFC 9110, section 13.2.2).
+func checkNotModified(w http.ResponseWriter, r *http.Request, info *storage.ObjectInfo) bool {
+	notModified := false
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		notModified = etagMatches(inm, info.ETag)
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && !info.LastModified.IsZero() {
+		if t, err := http.ParseTime(ims); err == nil {
+			// HTTP dates have second precision
+			notModified = !info.LastModified.Truncate(time.Second).After(t)
+		}
+	}
+	if !notModified {
+		return false
+	}
+
+	setValidators(w, info)
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// hasConditionals reports whether the request carries cache validators
+func hasConditionals(r *http.Request) bool {
+	return r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != ""
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, using weak comparison
+func etagMatches(header, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiatedFormats lists the formats convert_to: auto upgrades to, best first. Formats the
+// libvips build can't encode are skipped.
+var negotiatedFormats = []string{"avif", "webp"}
+
+// negotiateFormat picks the best thumbnail format the client accepts.
+// Explicit image/avif or image/webp entries win over wildcards, q=0 excludes a
+// format, and JPEG is the fallback every client gets.
+func negotiateFormat(accept string) string {
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		if mediaType == "" {
+			continue
+		}
+		weight := 1.0
+		for _, param := range fields[1:] {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if !ok || strings.ToLower(strings.TrimSpace(key)) != "q" {
+				continue
+			}
+			if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				weight = q
+			}
+		}
+		weights[mediaType] = weight
+	}
+
+	best, bestWeight := config.AutoFallbackFormat, 0.0
+	for _, format := range negotiatedFormats {
+		if !service.CanEncode(format) {
+			continue
+		}
+		// Only explicit entries count: browsers send */* even when they can't decode AVIF
+		if weight, ok := weights["image/"+format]; ok && weight > bestWeight {
+			best, bestWeight = format, weight
+		}
+	}
+	return best
+}
+
+// Parse query params for size, variant and quality. The size is a width, or WIDTHxHEIGHT from the profile's sizes.
+// A variant sets its own size and quality, so it can't be combined with width, size or quality.
+func parseQueryParams(r *http.Request) (size, variant, quality string, err error) {
 	var w int
 	var q int
 
 	if width := r.URL.Query().Get("width"); width != "" {
 		w, err = strconv.Atoi(width)
 		if err != nil {
-			return "", "", fmt.Errorf("invalid width parameter")
+			return "", "", "", fmt.Errorf("invalid width parameter")
 		}
 		if w <= 0 || w > 2048 {
-			return "", "", fmt.Errorf("width must be between 1 and 2048")
+			return "", "", "", fmt.Errorf("width must be between 1 and 2048")
+		}
+		size = width
+	}
+
+	if box := r.URL.Query().Get("size"); box != "" {
+		if size != "" {
+			return "", "", "", fmt.Errorf("use either width or size")
 		}
+		w, h, err := config.ParseSize(box)
+		if err != nil {
+			return "", "", "", fmt.Errorf("invalid size parameter")
+		}
+		if w > 2048 || h > 2048 {
+			return "", "", "", fmt.Errorf("size must be at most 2048x2048")
+		}
+		size = box
 	}
 
-	if quality := r.URL.Query().Get("quality"); quality != "" {
+	if quality = r.URL.Query().Get("quality"); quality != "" {
 		q, err = strconv.Atoi(quality)
 		if err != nil {
-			return "", "", fmt.Errorf("invalid quality parameter")
+			return "", "", "", fmt.Errorf("invalid quality parameter")
 		}
 		if q < 1 || q > 100 {
-			return "", "", fmt.Errorf("quality must be between 1 and 100")
+			return "", "", "", fmt.Errorf("quality must be between 1 and 100")
 		}
 	}
 
-	return width, quality, nil
+	if variant = r.URL.Query().Get("variant"); variant != "" && (size != "" || quality != "") {
+		return "", "", "", fmt.Errorf("variant can't be combined with width, size or quality")
+	}
+
+	return size, variant, quality, nil
 }
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"mediaflow/internal/auth"
+	"mediaflow/internal/cache"
+	"mediaflow/internal/config"
+	"mediaflow/internal/service"
+	"mediaflow/internal/storage"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		accept   string
+		expected string
+	}{
+		{"empty header", "", "jpeg"},
+		{"wildcard only", "*/*", "jpeg"},
+		{"chrome", "image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8", "avif"},
+		{"webp only", "image/webp,*/*", "webp"},
+		{"avif disabled", "image/avif;q=0,image/webp", "webp"},
+		{"webp preferred by weight", "image/avif;q=0.5,image/webp;q=0.9", "webp"},
+		{"case and spacing", " Image/WebP ; Q=1 ", "webp"},
+		{"jpeg only", "image/jpeg", "jpeg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Formats the linked libvips can't encode are skipped
+			if !service.CanEncode(tt.expected) {
+				t.Skipf("libvips can't encode %s", tt.expected)
+			}
+			if got := negotiateFormat(tt.accept); got != tt.expected {
+				t.Errorf("negotiateFormat(%q) = %q, expected %q", tt.accept, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCheckNotModified(t *testing.T) {
+	modified := time.Date(2025, 3, 14, 9, 26, 53, 500_000_000, time.UTC)
+	info := &storage.ObjectInfo{ETag: `"abc123"`, LastModified: modified}
+
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		expected bool
+	}{
+		{"no conditionals", nil, false},
+		{"matching etag", map[string]string{"If-None-Match": `"abc123"`}, true},
+		{"weak etag in list", map[string]string{"If-None-Match": `"other", W/"abc123"`}, true},
+		{"wildcard", map[string]string{"If-None-Match": "*"}, true},
+		{"stale etag", map[string]string{"If-None-Match": `"old"`}, false},
+		{"modified since same second", map[string]string{"If-Modified-Since": modified.Format(http.TimeFormat)}, true},
+		{"modified since later", map[string]string{"If-Modified-Since": modified.Add(time.Hour).Format(http.TimeFormat)}, true},
+		{"modified since earlier", map[string]string{"If-Modified-Since": modified.Add(-time.Hour).Format(http.TimeFormat)}, false},
+		{"etag takes precedence", map[string]string{
+			"If-None-Match":     `"old"`,
+			"If-Modified-Since": modified.Add(time.Hour).Format(http.TimeFormat),
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/thumb/avatar/me.jpg", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+
+			if got := checkNotModified(w, r, info); got != tt.expected {
+				t.Fatalf("checkNotModified() = %v, expected %v", got, tt.expected)
+			}
+			if tt.expected {
+				if w.Code != http.StatusNotModified {
+					t.Errorf("Expected 304, got %d", w.Code)
+				}
+				if w.Header().Get("ETag") != info.ETag {
+					t.Errorf("Expected ETag %s on 304, got %q", info.ETag, w.Header().Get("ETag"))
+				}
+			}
+		})
+	}
+}
+
+func TestParseQueryParams(t *testing.T) {
+	tests := []struct {
+		name            string
+		query           string
+		expectedSize    string
+		expectedVariant string
+		expectedQuality string
+		wantErr         bool
+	}{
+		{"nothing", "", "", "", "", false},
+		{"width", "width=512&quality=80", "512", "", "80", false},
+		{"box size", "size=1200x630", "1200x630", "", "", false},
+		{"width only size", "size=256", "256", "", "", false},
+		{"variant", "variant=card", "", "card", "", false},
+		{"width and size", "width=512&size=256x256", "", "", "", true},
+		{"size without height", "size=256x", "", "", "", true},
+		{"size too large", "size=256x4096", "", "", "", true},
+		{"width too large", "width=4096", "", "", "", true},
+		{"quality out of range", "quality=0", "", "", "", true},
+		{"variant and width", "variant=card&width=512", "", "", "", true},
+		{"variant and quality", "variant=card&quality=80", "", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size, variant, quality, err := parseQueryParams(httptest.NewRequest(http.MethodGet, "/thumb/avatar/abc?"+tt.query, nil))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseQueryParams(%q) error = %v, wantErr %v", tt.query, err, tt.wantErr)
+			}
+			if size != tt.expectedSize || variant != tt.expectedVariant || quality != tt.expectedQuality {
+				t.Errorf("parseQueryParams(%q) = %q, %q, %q; expected %q, %q, %q", tt.query, size, variant, quality,
+					tt.expectedSize, tt.expectedVariant, tt.expectedQuality)
+			}
+		})
+	}
+}
+
+func TestHandleThumbnailType_SizesAndVariants(t *testing.T) {
+	backend, err := storage.NewLocalBackend(t.TempDir(), "http://localhost", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var original bytes.Buffer
+	if err := jpeg.Encode(&original, image.NewRGBA(image.Rect(0, 0, 64, 32)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.PutObject(context.Background(), "originals/banners/abc", &original, ""); err != nil {
+		t.Fatal(err)
+	}
+	storageConfig := &config.StorageConfig{Profiles: map[string]config.Profile{
+		"banner": {StoragePath: "originals/banners/{key_base}", ThumbFolder: "thumbnails/banners", Quality: 90, ConvertTo: "jpeg",
+			Sizes: []string{"1200x630", "256"}, Fit: config.FitContain, Background: "#000000",
+			Variants: map[string]config.Variant{"card": {Width: 600, Height: 400, Fit: config.FitCover, Gravity: config.GravityNorth, Format: "png", Quality: 70}}},
+	}}
+	h := NewImageAPI(context.Background(), &service.ImageService{Storage: backend, Cache: cache.NewMemory(0, nil)}, config.NewStore(storageConfig, nil))
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedKey    string // Written back to thumb_folder
+		expectedType   string
+	}{
+		{"box size", "size=1200x630", http.StatusOK, "thumbnails/banners/abc_1200x630_contain_000000.jpeg", "image/jpeg"},
+		{"width", "width=256", http.StatusOK, "thumbnails/banners/abc_256.jpeg", "image/jpeg"},
+		{"variant", "variant=card", http.StatusOK, "thumbnails/banners/abc_card_600x400_cover_north_q70.png", "image/png"},
+		{"box size not in sizes", "size=256x256", http.StatusBadRequest, "", ""},
+		{"unknown variant", "variant=hero", http.StatusBadRequest, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			h.HandleThumbnailType(rr, httptest.NewRequest(http.MethodGet, "/thumb/banner/abc?"+tt.query, nil), nil, "banner", "abc")
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if tt.expectedKey != "" {
+				if _, err := backend.HeadObject(context.Background(), tt.expectedKey); err != nil {
+					t.Errorf("Expected %s to be written back: %v", tt.expectedKey, err)
+				}
+			}
+			if tt.expectedType != "" && rr.Header().Get("Content-Type") != tt.expectedType {
+				t.Errorf("Expected Content-Type %s, got %q", tt.expectedType, rr.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestHandleOriginals_ContentType(t *testing.T) {
+	backend, err := storage.NewLocalBackend(t.TempDir(), "http://localhost", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var original bytes.Buffer
+	if err := jpeg.Encode(&original, image.NewRGBA(image.Rect(0, 0, 64, 32)), nil); err != nil {
+		t.Fatal(err)
+	}
+	// Stored without an extension, so storage has no content type for it
+	if err := backend.PutObject(context.Background(), "originals/banners/abc", &original, ""); err != nil {
+		t.Fatal(err)
+	}
+	storageConfig := &config.StorageConfig{Profiles: map[string]config.Profile{
+		"banner": {StoragePath: "originals/banners/{key_base}", CacheDuration: 60},
+	}}
+	h := NewImageAPI(context.Background(), &service.ImageService{Storage: backend, Cache: cache.NewMemory(0, nil)}, config.NewStore(storageConfig, nil))
+	mux := http.NewServeMux()
+	// No API keys configured, so authentication is disabled
+	mux.Handle("/originals/{type}/{image_id}", auth.APIKeyMiddleware(&auth.Config{})(http.HandlerFunc(h.HandleOriginals)))
+
+	for name, rangeHeader := range map[string]string{"Full body": "", "Range": "bytes=0-9"} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/originals/banner/abc.jpg", nil)
+			if rangeHeader != "" {
+				req.Header.Set("Range", rangeHeader)
+			}
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK && rr.Code != http.StatusPartialContent {
+				t.Fatalf("Expected the original, got %d: %s", rr.Code, rr.Body.String())
+			}
+			if rr.Header().Get("Content-Type") != "image/jpeg" {
+				t.Errorf("Expected the sniffed image/jpeg, got %q", rr.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"mediaflow/internal/response"
+)
+
+// HandleMetadata handles GET /v1/assets/{profile}/{key_base}/metadata, returning an original's
+// dimensions, format, size, EXIF subset and dominant color without downloading it
+func (h *ImageAPI) HandleMetadata(w http.ResponseWriter, r *http.Request) {
+	profileName, keyBase := r.PathValue("profile"), r.PathValue("key_base")
+	profile := h.storageConfig.Current().GetProfile(profileName)
+	if profile == nil {
+		response.JSON(fmt.Sprintf("Profile '%s' not found", profileName)).WriteError(w, http.StatusNotFound)
+		return
+	}
+	if profile.Kind != "image" {
+		response.JSON(fmt.Sprintf("Profile '%s' is not an image profile", profileName)).WriteError(w, http.StatusBadRequest)
+		return
+	}
+	if !authorizeRead(w, r, profileName, profile, keyBase) {
+		return
+	}
+	ctx, ok := h.assetContext(w, r)
+	if !ok {
+		return
+	}
+
+	meta, err := h.imageService.Metadata(ctx, profile, keyBase)
+	if err != nil {
+		writeImageError(w, err)
+		return
+	}
+
+	cd := profile.CacheDuration
+	if cd == 0 {
+		// 24 hours
+		cd = 86400
+	}
+	w.Header().Set("Cache-Control", cacheControl(profile, cd))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(meta)
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"mediaflow/internal/cache"
+	"mediaflow/internal/config"
+	"mediaflow/internal/objectkey"
+	"mediaflow/internal/service"
+	"mediaflow/internal/storage"
+)
+
+// testPNG returns a PNG filled with fill, with a stripe of another color
+func testPNG(t *testing.T, fill color.Color) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			img.Set(x, y, fill)
+			if x < 5 {
+				img.Set(x, y, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestHandleMetadata(t *testing.T) {
+	backend, err := storage.NewLocalBackend(t.TempDir(), "http://localhost", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	original := testPNG(t, color.RGBA{R: 0x33, G: 0x66, B: 0x99, A: 255})
+	if err := backend.PutObject(ctx, "originals/photos/abc", bytes.NewReader(original), ""); err != nil {
+		t.Fatal(err)
+	}
+	storageConfig := &config.StorageConfig{Profiles: map[string]config.Profile{
+		"photo": {Kind: "image", StoragePath: "originals/photos/{key_base}", CacheDuration: 60},
+		"kyc":   {Kind: "image", StoragePath: "originals/kyc/{key_base}", Visibility: config.VisibilityPrivate},
+		"video": {Kind: "video", StoragePath: "originals/videos/{key_base}"},
+	}}
+	h := NewImageAPI(ctx, &service.ImageService{Storage: backend, Cache: cache.NewMemory(0, nil)}, config.NewStore(storageConfig, nil))
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/assets/{profile}/{key_base}/metadata", h.HandleMetadata)
+
+	get := func(path string) (*httptest.ResponseRecorder, service.ImageMetadata) {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		var meta service.ImageMetadata
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &meta); err != nil {
+				t.Fatalf("Invalid metadata response %q: %v", rr.Body.String(), err)
+			}
+		}
+		return rr, meta
+	}
+
+	rr, meta := get("/v1/assets/photo/abc/metadata")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if meta.Bytes != int64(len(original)) || meta.DominantColor != "#336699" {
+		t.Errorf("Expected %d bytes and dominant color #336699, got %+v", len(original), meta)
+	}
+	if rr.Header().Get("Cache-Control") != "public, max-age=60" {
+		t.Errorf("Expected public caching, got %q", rr.Header().Get("Cache-Control"))
+	}
+	if strings.Contains(rr.Body.String(), "source_etag") {
+		t.Errorf("Expected the sidecar's ETag to stay internal, got %s", rr.Body.String())
+	}
+
+	// Later requests are served from the sidecar
+	sidecarKey := objectkey.MetadataKey("originals/photos/abc")
+	sidecar, err := backend.GetObject(ctx, sidecarKey)
+	if err != nil {
+		t.Fatalf("Expected a metadata sidecar: %v", err)
+	}
+	if err := backend.PutObject(ctx, sidecarKey, bytes.NewReader(bytes.Replace(sidecar, []byte("#336699"), []byte("#000000"), 1)), ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, meta := get("/v1/assets/photo/abc/metadata"); meta.DominantColor != "#000000" {
+		t.Errorf("Expected the sidecar to be served, got %+v", meta)
+	}
+
+	// A replaced original is recomputed
+	replacement := testPNG(t, color.RGBA{R: 0xcc, G: 0x00, B: 0x00, A: 255})
+	if err := backend.PutObject(ctx, "originals/photos/abc", bytes.NewReader(replacement), ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, meta := get("/v1/assets/photo/abc/metadata"); meta.DominantColor != "#cc0000" || meta.Bytes != int64(len(replacement)) {
+		t.Errorf("Expected the replaced original's metadata, got %+v", meta)
+	}
+
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+	}{
+		{"Missing original", "/v1/assets/photo/missing/metadata", http.StatusNotFound},
+		{"Unknown profile", "/v1/assets/banner/abc/metadata", http.StatusNotFound},
+		{"Video profile", "/v1/assets/video/abc/metadata", http.StatusBadRequest},
+		{"Private without credentials", "/v1/assets/kyc/abc/metadata", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr, _ := get(tt.path); rr.Code != tt.expectedStatus {
+				t.Errorf("Expected %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	utils "mediaflow/internal"
+	"mediaflow/internal/auth"
+	"mediaflow/internal/config"
+	"mediaflow/internal/response"
+)
+
+// privateRedirectTTL is the lifetime of presigned GET URLs handed out for private profiles
+// with private_delivery: redirect. Short, since anyone holding the URL can read the object.
+const privateRedirectTTL = 5 * time.Minute
+
+// authorizeRead checks read access to an asset of a private profile: a read token for the
+// same profile and key_base, or an API key granting assets:read for the profile.
+// Public profiles are always readable. It writes a 401 or 403 and returns false otherwise.
+func authorizeRead(w http.ResponseWriter, r *http.Request, profileName string, profile *config.Profile, keyBase string) bool {
+	if !profile.IsPrivate() {
+		return true
+	}
+	if claims, ok := auth.ReadClaimsFromContext(r.Context()); ok {
+		if claims.Profile != profileName || claims.KeyBase != keyBase {
+			response.JSON("Read token is not valid for this asset").WriteError(w, http.StatusForbidden)
+			return false
+		}
+		return true
+	}
+	if _, ok := auth.KeyFromContext(r.Context()); ok {
+		return authorize(w, r, auth.ScopeAssetsRead, profileName)
+	}
+	response.JSON("This asset is private, provide a read token").WriteError(w, http.StatusUnauthorized)
+	return false
+}
+
+// ReadAsset returns the profile and key_base of the asset a read route serves, so
+// auth.ReadTokenMiddleware only accepts read tokens minted for that asset
+func ReadAsset(r *http.Request) (string, string) {
+	if profile := r.PathValue("profile"); profile != "" {
+		return profile, r.PathValue("key_base")
+	}
+	if profile := r.PathValue("type"); profile != "" {
+		return profile, utils.BaseName(r.PathValue("image_id"))
+	}
+	// /t/{signature}/{profile}/{options}/{key_base}
+	if rest, ok := strings.CutPrefix(r.URL.Path, transformPrefix); ok {
+		if parts := strings.SplitN(rest, "/", 4); len(parts) == 4 {
+			return parts[1], utils.BaseName(parts[3])
+		}
+	}
+	return "", ""
+}
+
+// cacheControl returns the Cache-Control header for a profile's responses.
+// Private profiles must not end up in shared caches.
+func cacheControl(profile *config.Profile, maxAge int) string {
+	if profile.IsPrivate() {
+		return fmt.Sprintf("private, max-age=%d", maxAge)
+	}
+	return fmt.Sprintf("public, max-age=%d", maxAge)
+}
+
+// redirectsPrivate reports whether reads from profile are served as redirects to presigned GET URLs
+func redirectsPrivate(profile *config.Profile) bool {
+	return profile.IsPrivate() && profile.PrivateDelivery == config.DeliveryRedirect
+}
+
+// writePresignedRedirect sends the client to a short-lived presigned URL. The redirect itself
+// must not be cached past the URL's expiry.
+func writePresignedRedirect(w http.ResponseWriter, r *http.Request, url string) {
+	w.Header().Set("Cache-Control", "private, no-store")
+	http.Redirect(w, r, url, http.StatusFound)
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"mediaflow/internal/auth"
+	"mediaflow/internal/cache"
+	"mediaflow/internal/config"
+	"mediaflow/internal/service"
+	"mediaflow/internal/storage"
+)
+
+func TestHandleOriginals_PrivateProfiles(t *testing.T) {
+	backend, err := storage.NewLocalBackend(t.TempDir(), "http://mediaflow.test", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, key := range []string{"originals/kyc/doc-1", "originals/public/doc-1", "originals/docs/doc-1"} {
+		if err := backend.PutObject(ctx, key, strings.NewReader("document"), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	storageConfig := &config.StorageConfig{Profiles: map[string]config.Profile{
+		"kyc":    {StoragePath: "originals/kyc/{key_base}", CacheDuration: 60, Visibility: config.VisibilityPrivate},
+		"docs":   {StoragePath: "originals/docs/{key_base}", CacheDuration: 60, Visibility: config.VisibilityPrivate, PrivateDelivery: config.DeliveryRedirect},
+		"public": {StoragePath: "originals/public/{key_base}", CacheDuration: 60},
+	}}
+	h := NewImageAPI(ctx, &service.ImageService{Storage: backend, Cache: cache.NewMemory(0, nil)}, config.NewStore(storageConfig, nil))
+
+	reader := &auth.Key{ID: "reader", Scopes: []string{auth.ScopeAssetsRead}}
+	uploader := &auth.Key{ID: "uploader", Scopes: []string{auth.ScopeUploadPresign}}
+
+	tests := []struct {
+		name           string
+		path           string
+		claims         *auth.ReadClaims
+		key            *auth.Key
+		expectedStatus int
+		expectedCache  string
+	}{
+		{"Public without credentials", "/originals/public/doc-1.pdf", nil, nil, http.StatusOK, "public, max-age=60"},
+		{"Private without credentials", "/originals/kyc/doc-1.pdf", nil, nil, http.StatusUnauthorized, ""},
+		{"Private with read token", "/originals/kyc/doc-1.pdf", &auth.ReadClaims{Profile: "kyc", KeyBase: "doc-1"}, nil, http.StatusOK, "private, max-age=60"},
+		{"Read token for another asset", "/originals/kyc/doc-1.pdf", &auth.ReadClaims{Profile: "kyc", KeyBase: "doc-2"}, nil, http.StatusForbidden, ""},
+		{"Read token for another profile", "/originals/kyc/doc-1.pdf", &auth.ReadClaims{Profile: "docs", KeyBase: "doc-1"}, nil, http.StatusForbidden, ""},
+		{"Key with assets:read", "/originals/kyc/doc-1.pdf", nil, reader, http.StatusOK, "private, max-age=60"},
+		{"Key without assets:read", "/originals/kyc/doc-1.pdf", nil, uploader, http.StatusForbidden, ""},
+		{"Redirect delivery", "/originals/docs/doc-1.pdf", &auth.ReadClaims{Profile: "docs", KeyBase: "doc-1"}, nil, http.StatusFound, "private, no-store"},
+		{"Redirect for a missing asset", "/originals/docs/doc-2.pdf", &auth.ReadClaims{Profile: "docs", KeyBase: "doc-2"}, nil, http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.claims != nil {
+				req = req.WithContext(auth.WithReadClaims(req.Context(), tt.claims))
+			}
+			if tt.key != nil {
+				req = req.WithContext(auth.WithKey(req.Context(), tt.key))
+			}
+			if tt.claims == nil && tt.key == nil {
+				// As APIKeyMiddleware does with no keys configured
+				req = req.WithContext(auth.WithAuthDisabled(req.Context()))
+			}
+			rr := httptest.NewRecorder()
+			h.HandleOriginals(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if tt.expectedCache != "" && rr.Header().Get("Cache-Control") != tt.expectedCache {
+				t.Errorf("Expected Cache-Control %q, got %q", tt.expectedCache, rr.Header().Get("Cache-Control"))
+			}
+		})
+	}
+}
+
+func TestHandleOriginals_RedirectIsPresigned(t *testing.T) {
+	backend, err := storage.NewLocalBackend(t.TempDir(), "http://mediaflow.test", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := backend.PutObject(ctx, "originals/docs/doc-1", strings.NewReader("document"), ""); err != nil {
+		t.Fatal(err)
+	}
+	storageConfig := &config.StorageConfig{Profiles: map[string]config.Profile{
+		"docs": {StoragePath: "originals/docs/{key_base}", Visibility: config.VisibilityPrivate, PrivateDelivery: config.DeliveryRedirect},
+	}}
+	h := NewImageAPI(ctx, &service.ImageService{Storage: backend, Cache: cache.NewMemory(0, nil)}, config.NewStore(storageConfig, nil))
+
+	req := httptest.NewRequest(http.MethodGet, "/originals/docs/doc-1.pdf", nil)
+	req = req.WithContext(auth.WithReadClaims(req.Context(), &auth.ReadClaims{Profile: "docs", KeyBase: "doc-1"}))
+	rr := httptest.NewRecorder()
+	h.HandleOriginals(rr, req)
+	if rr.Code != http.StatusFound {
+		t.Fatalf("Expected 302, got %d", rr.Code)
+	}
+
+	// The redirect target must be readable without further credentials
+	location := strings.TrimPrefix(rr.Header().Get("Location"), "http://mediaflow.test")
+	follow := httptest.NewRecorder()
+	backend.ServeHTTP(follow, httptest.NewRequest(http.MethodGet, location, nil))
+	if follow.Code != http.StatusOK || follow.Body.String() != "document" {
+		t.Errorf("Following the redirect returned %d %q", follow.Code, follow.Body.String())
+	}
+}
+
+func TestReadAsset(t *testing.T) {
+	var profile, keyBase string
+	capture := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		profile, keyBase = ReadAsset(r)
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/thumb/{type}/{image_id}", capture)
+	mux.Handle("/originals/{type}/{image_id}", capture)
+	mux.Handle("/t/", capture)
+	mux.Handle("GET /v1/assets/{profile}/{key_base}/metadata", capture)
+
+	tests := map[string][2]string{
+		"/thumb/avatar/abc.webp":                 {"avatar", "abc"},
+		"/originals/kyc/doc-1.pdf":               {"kyc", "doc-1"},
+		"/t/c2lnbmF0dXJl/avatar/w:512/abc.jpg":   {"avatar", "abc"},
+		"/v1/assets/kyc/doc-1/metadata":          {"kyc", "doc-1"},
+		"/t/c2lnbmF0dXJl/avatar-without-options": {"", ""},
+	}
+	for path, expected := range tests {
+		profile, keyBase = "unset", "unset"
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+		if [2]string{profile, keyBase} != expected {
+			t.Errorf("ReadAsset(%s) = %s, %s; expected %v", path, profile, keyBase, expected)
+		}
+	}
+}
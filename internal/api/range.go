@@ -0,0 +1,202 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"mediaflow/internal/config"
+	"mediaflow/internal/response"
+	"mediaflow/internal/storage"
+)
+
+// maxRanges caps the ranges served in one multipart/byteranges response, so a
+// single request can't fan out into thousands of storage GETs
+const maxRanges = 16
+
+var errUnsatisfiableRange = errors.New("requested range not satisfiable")
+
+// byteRange is a resolved, in-bounds range of an object
+type byteRange struct {
+	start, length int64
+}
+
+func (br byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", br.start, br.start+br.length-1, size)
+}
+
+// parseRange parses a "bytes=" Range header against an object of the given size.
+// Ranges that start past the end are dropped; if none are left the range is unsatisfiable.
+func parseRange(header string, size int64) ([]byteRange, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return nil, errUnsatisfiableRange
+	}
+
+	var ranges []byteRange
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, errUnsatisfiableRange
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		var br byteRange
+		if first == "" {
+			// Suffix range: the last N bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n <= 0 {
+				return nil, errUnsatisfiableRange
+			}
+			if n > size {
+				n = size
+			}
+			br = byteRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, errUnsatisfiableRange
+			}
+			if start >= size {
+				continue
+			}
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, errUnsatisfiableRange
+				}
+				if end >= size {
+					end = size - 1
+				}
+			}
+			br = byteRange{start: start, length: end - start + 1}
+		}
+		if br.length > 0 {
+			ranges = append(ranges, br)
+		}
+	}
+
+	if len(ranges) == 0 || len(ranges) > maxRanges {
+		return nil, errUnsatisfiableRange
+	}
+	return ranges, nil
+}
+
+// rangeApplies evaluates If-Range: the Range header is only honored if the
+// client's validator still matches the stored object
+func rangeApplies(r *http.Request, info *storage.ObjectInfo) bool {
+	ifRange := r.Header.Get("If-Range")
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) {
+		// Strong comparison only
+		return info.ETag != "" && ifRange == info.ETag
+	}
+	t, err := http.ParseTime(ifRange)
+	return err == nil && !info.LastModified.IsZero() && info.LastModified.Truncate(time.Second).Equal(t)
+}
+
+// serveOriginalRanges answers a Range request with 206 Partial Content, using a
+// ranged storage GET per range. Multiple ranges are sent as multipart/byteranges.
+func (h *ImageAPI) serveOriginalRanges(ctx context.Context, w http.ResponseWriter, profile *config.Profile, baseName string, info *storage.ObjectInfo, header string) {
+	ranges, err := parseRange(header, info.Size)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+		response.JSON(err.Error()).WriteError(w, http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	contentType, err := h.rangedContentType(ctx, profile, baseName, info)
+	if err != nil {
+		writeImageError(w, err)
+		return
+	}
+	setValidators(w, info)
+
+	if len(ranges) == 1 {
+		br := ranges[0]
+		body, err := h.imageService.OpenOriginalRange(ctx, profile, baseName, br.start, br.length)
+		if err != nil {
+			writeImageError(w, err)
+			return
+		}
+		defer body.Close()
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Range", br.contentRange(info.Size))
+		w.Header().Set("Content-Length", strconv.FormatInt(br.length, 10))
+		w.WriteHeader(http.StatusPartialContent)
+		if _, err := io.Copy(w, body); err != nil {
+			fmt.Printf("Failed to stream range of original %s: %v\n", baseName, err)
+		}
+		return
+	}
+
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	w.WriteHeader(http.StatusPartialContent)
+	for _, br := range ranges {
+		body, err := h.imageService.OpenOriginalRange(ctx, profile, baseName, br.start, br.length)
+		if err != nil {
+			// Headers are already sent; cut the response short so the client sees a truncated body
+			fmt.Printf("Failed to get range of original %s: %v\n", baseName, err)
+			return
+		}
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":  {contentType},
+			"Content-Range": {br.contentRange(info.Size)},
+		})
+		if err == nil {
+			_, err = io.Copy(part, body)
+		}
+		body.Close()
+		if err != nil {
+			fmt.Printf("Failed to stream range of original %s: %v\n", baseName, err)
+			return
+		}
+	}
+	mw.Close() //nolint:errcheck
+}
+
+// rangedContentType returns an original's content type for a range response, fetching its first
+// bytes only when storage has no type to go by
+func (h *ImageAPI) rangedContentType(ctx context.Context, profile *config.Profile, baseName string, info *storage.ObjectInfo) (string, error) {
+	if storedContentType(info) {
+		return info.ContentType, nil
+	}
+	body, err := h.imageService.OpenOriginalRange(ctx, profile, baseName, 0, min(info.Size, 512))
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	head, _ := io.ReadAll(body)
+	return originalContentType(info, head), nil
+}
+
+// originalContentType returns the content type originals are served with: the stored one, or
+// head sniffed when storage has none. Objects written without a type (presigned uploads that
+// didn't send one, local files without an extension) are still served as what they are.
+func originalContentType(info *storage.ObjectInfo, head []byte) string {
+	if storedContentType(info) {
+		return info.ContentType
+	}
+	return http.DetectContentType(head)
+}
+
+// storedContentType reports whether storage knows the object's content type
+func storedContentType(info *storage.ObjectInfo) bool {
+	return info.ContentType != "" && info.ContentType != "application/octet-stream"
+}
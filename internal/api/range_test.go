@@ -0,0 +1,93 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"mediaflow/internal/storage"
+)
+
+func TestParseRange(t *testing.T) {
+	const size = 1000
+
+	tests := []struct {
+		name     string
+		header   string
+		expected []byteRange
+		wantErr  bool
+	}{
+		{"closed range", "bytes=0-499", []byteRange{{0, 500}}, false},
+		{"open-ended range", "bytes=900-", []byteRange{{900, 100}}, false},
+		{"suffix range", "bytes=-200", []byteRange{{800, 200}}, false},
+		{"suffix larger than object", "bytes=-5000", []byteRange{{0, 1000}}, false},
+		{"end clamped to size", "bytes=500-5000", []byteRange{{500, 500}}, false},
+		{"multiple ranges", "bytes=0-9, 100-109", []byteRange{{0, 10}, {100, 10}}, false},
+		{"unsatisfiable range dropped", "bytes=0-9,2000-2100", []byteRange{{0, 10}}, false},
+		{"start past end", "bytes=1000-", nil, true},
+		{"end before start", "bytes=500-100", nil, true},
+		{"wrong unit", "items=0-1", nil, true},
+		{"malformed", "bytes=abc", nil, true},
+		{"zero suffix", "bytes=-0", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRange(tt.header, size)
+			if tt.wantErr {
+				if !errors.Is(err, errUnsatisfiableRange) {
+					t.Errorf("Expected errUnsatisfiableRange, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("parseRange(%q) = %v, expected %v", tt.header, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseRange_TooManyRanges(t *testing.T) {
+	header := "bytes=0-0"
+	for i := 1; i <= maxRanges; i++ {
+		header += ",0-0"
+	}
+	if _, err := parseRange(header, 10); !errors.Is(err, errUnsatisfiableRange) {
+		t.Errorf("Expected errUnsatisfiableRange for %d ranges, got %v", maxRanges+1, err)
+	}
+}
+
+func TestRangeApplies(t *testing.T) {
+	modified := time.Date(2025, 3, 14, 9, 26, 53, 0, time.UTC)
+	info := &storage.ObjectInfo{ETag: `"abc123"`, LastModified: modified}
+
+	tests := []struct {
+		name     string
+		ifRange  string
+		expected bool
+	}{
+		{"no If-Range", "", true},
+		{"matching etag", `"abc123"`, true},
+		{"stale etag", `"old"`, false},
+		{"matching date", modified.Format(http.TimeFormat), true},
+		{"stale date", modified.Add(-time.Hour).Format(http.TimeFormat), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/originals/video/clip.mp4", nil)
+			if tt.ifRange != "" {
+				r.Header.Set("If-Range", tt.ifRange)
+			}
+			if got := rangeApplies(r, info); got != tt.expected {
+				t.Errorf("rangeApplies() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
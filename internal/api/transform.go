@@ -0,0 +1,152 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	utils "mediaflow/internal"
+	"mediaflow/internal/config"
+	"mediaflow/internal/response"
+	"mediaflow/internal/service"
+)
+
+// transformPrefix is the route of signed transformation URLs: /t/{signature}/{profile}/{options}/{key_base}
+const transformPrefix = "/t/"
+
+// maxTransformWidth matches the limit on /thumb's width parameter
+const maxTransformWidth = 2048
+
+// SetTransformSecret enables signed transformation URLs. Without a secret, /t/ returns 404.
+func (h *ImageAPI) SetTransformSecret(secret string) {
+	h.transformSecret = []byte(secret)
+}
+
+// SignTransformPath returns the signature for a transformation path of the form
+// /{profile}/{options}/{key_base}: unpadded base64url HMAC-SHA256 of the path.
+func SignTransformPath(secret []byte, path string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(path))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// HandleTransform handles GET /t/{signature}/{profile}/{options}/{key_base}, e.g.
+// /t/{signature}/avatar/w:512,q:80,f:webp/abc123. The signature is checked before
+// anything else, so unsigned requests never reach storage or bimg.
+func (h *ImageAPI) HandleTransform(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		response.JSON("Method not allowed").WriteError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	if len(h.transformSecret) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	signature, path, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, transformPrefix), "/")
+	if !ok || !verifyTransformSignature(h.transformSecret, "/"+path, signature) {
+		response.JSON("Invalid signature").WriteError(w, http.StatusForbidden)
+		return
+	}
+
+	parts := strings.SplitN(path, "/", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+		response.JSON("Invalid URL format, expected /t/{signature}/{profile}/{options}/{key_base}").WriteError(w, http.StatusBadRequest)
+		return
+	}
+	profileName, options, keyBase := parts[0], parts[1], utils.BaseName(parts[2])
+
+	spec, err := parseTransformOptions(options)
+	if err != nil {
+		response.JSON(err.Error()).WriteError(w, http.StatusBadRequest)
+		return
+	}
+	profile := h.storageConfig.Current().GetProfile(profileName)
+	if profile == nil {
+		response.JSON(fmt.Sprintf("Profile '%s' not found", profileName)).WriteError(w, http.StatusNotFound)
+		return
+	}
+	// Signed URLs don't expire, so private profiles also need a read token
+	if !authorizeRead(w, r, profileName, profile, keyBase) {
+		return
+	}
+
+	imageData, info, err := h.imageService.Transform(h.ctx, profile, keyBase, spec)
+	if err != nil {
+		writeImageError(w, err)
+		return
+	}
+
+	cd := profile.CacheDuration
+	if cd == 0 {
+		// 24 hours
+		cd = 86400
+	}
+	// A signed URL always maps to the same bytes
+	w.Header().Set("Cache-Control", cacheControl(profile, cd)+", immutable")
+	if checkNotModified(w, r, info) {
+		return
+	}
+	setValidators(w, info)
+	w.Header().Set("Content-Type", info.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(imageData)))
+	if r.Method == http.MethodGet {
+		w.Write(imageData) //nolint:errcheck
+	}
+}
+
+// verifyTransformSignature compares signature with the expected one in constant time
+func verifyTransformSignature(secret []byte, path, signature string) bool {
+	got, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(path))
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// parseTransformOptions parses comma-separated key:value options:
+// w (width), q (quality 1-100) and f (webp, jpeg, png or avif). All are optional.
+func parseTransformOptions(options string) (service.TransformSpec, error) {
+	var spec service.TransformSpec
+	if options == "" || options == "-" {
+		return spec, nil
+	}
+	for _, option := range strings.Split(options, ",") {
+		key, value, ok := strings.Cut(option, ":")
+		if !ok {
+			return spec, fmt.Errorf("invalid option %q, expected key:value", option)
+		}
+		switch key {
+		case "w":
+			width, err := strconv.Atoi(value)
+			if err != nil || width <= 0 || width > maxTransformWidth {
+				return spec, fmt.Errorf("width must be between 1 and %d", maxTransformWidth)
+			}
+			spec.Width = width
+		case "q":
+			quality, err := strconv.Atoi(value)
+			if err != nil || quality < 1 || quality > 100 {
+				return spec, errors.New("quality must be between 1 and 100")
+			}
+			spec.Quality = quality
+		case "f":
+			if value != "" {
+				value = config.NormalizeFormat(value)
+			}
+			if !service.CanEncode(value) {
+				return spec, fmt.Errorf("unsupported format: %s", value)
+			}
+			spec.Format = value
+		default:
+			return spec, fmt.Errorf("unknown option: %s", key)
+		}
+	}
+	return spec, nil
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"mediaflow/internal/cache"
+	"mediaflow/internal/config"
+	"mediaflow/internal/service"
+	"mediaflow/internal/storage"
+)
+
+func TestParseTransformOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  string
+		expected service.TransformSpec
+		wantErr  bool
+	}{
+		{"all options", "w:512,q:80,f:webp", service.TransformSpec{Width: 512, Quality: 80, Format: "webp"}, false},
+		{"jpg alias", "f:jpg", service.TransformSpec{Format: "jpeg"}, false},
+		{"no options", "-", service.TransformSpec{}, false},
+		{"width too large", "w:4096", service.TransformSpec{}, true},
+		{"zero quality", "q:0", service.TransformSpec{}, true},
+		{"unknown format", "f:gif", service.TransformSpec{}, true},
+		{"unknown option", "w:512,blur:3", service.TransformSpec{}, true},
+		{"missing value", "w512", service.TransformSpec{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTransformOptions(tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTransformOptions(%q) error = %v, wantErr %v", tt.options, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.expected {
+				t.Errorf("parseTransformOptions(%q) = %+v, expected %+v", tt.options, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestHandleTransform_Signature(t *testing.T) {
+	backend, err := storage.NewLocalBackend(t.TempDir(), "http://localhost", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storageConfig := &config.StorageConfig{Profiles: map[string]config.Profile{
+		"avatar": {StoragePath: "originals/avatars/{key_base}", ThumbFolder: "thumbnails/avatars", Quality: 90, ConvertTo: "webp"},
+	}}
+	h := NewImageAPI(context.Background(), &service.ImageService{Storage: backend, Cache: cache.NewMemory(0, nil)}, config.NewStore(storageConfig, nil))
+	secret := []byte("transform-secret")
+	h.SetTransformSecret(string(secret))
+
+	path := "/avatar/w:512,q:80,f:webp/abc123"
+	valid := SignTransformPath(secret, path)
+
+	tests := []struct {
+		name           string
+		url            string
+		expectedStatus int
+	}{
+		// The original doesn't exist, so a 404 shows the request got past the signature check
+		{"valid signature", "/t/" + valid + path, http.StatusNotFound},
+		{"missing signature", "/t" + path, http.StatusForbidden},
+		{"wrong secret", "/t/" + SignTransformPath([]byte("other"), path) + path, http.StatusForbidden},
+		{"tampered options", "/t/" + valid + "/avatar/w:2048,q:80,f:webp/abc123", http.StatusForbidden},
+		{"tampered key_base", "/t/" + valid + "/avatar/w:512,q:80,f:webp/other", http.StatusForbidden},
+		{"malformed signature", "/t/not*base64" + path, http.StatusForbidden},
+		{"signed but invalid options", "/t/" + SignTransformPath(secret, "/avatar/w:0/abc123") + "/avatar/w:0/abc123", http.StatusBadRequest},
+		{"signed but unknown profile", "/t/" + SignTransformPath(secret, "/nope/w:512/abc123") + "/nope/w:512/abc123", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			h.HandleTransform(rr, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	// Without a secret the route is disabled
+	disabled := NewImageAPI(context.Background(), h.imageService, config.NewStore(storageConfig, nil))
+	rr := httptest.NewRecorder()
+	disabled.HandleTransform(rr, httptest.NewRequest(http.MethodGet, "/t/"+valid+path, nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without a transform secret, got %d", rr.Code)
+	}
+}
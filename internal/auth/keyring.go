@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// API key scopes
+const (
+	ScopeUploadPresign = "upload:presign" // Presign, complete, finalize and abort uploads; mint upload tokens
+	ScopeAssetsDelete  = "assets:delete"  // Delete originals and their thumbnails
+	ScopeAssetsRead    = "assets:read"    // Read assets of private profiles; mint read tokens
+	ScopeThumbWrite    = "thumb:write"    // Upload images through /thumb
+	ScopeAdmin         = "admin"          // Everything, including jobs and cache stats
+)
+
+var knownScopes = map[string]bool{
+	ScopeUploadPresign: true,
+	ScopeAssetsDelete:  true,
+	ScopeAssetsRead:    true,
+	ScopeThumbWrite:    true,
+	ScopeAdmin:         true,
+}
+
+// legacyKeyID identifies the key built from the single API_KEY setting
+const legacyKeyID = "default"
+
+// ErrForbidden is returned by Authorize when the request's API key lacks a scope or profile
+var ErrForbidden = errors.New("forbidden")
+
+// Key is a named API key. Only the SHA-256 hash of the secret is stored.
+type Key struct {
+	ID         string   `yaml:"id" json:"id"`
+	SecretHash string   `yaml:"secret_hash" json:"-"`               // Hex SHA-256 of the secret, optionally prefixed with "sha256:"
+	Scopes     []string `yaml:"scopes" json:"scopes"`               // See Scope* constants
+	Profiles   []string `yaml:"profiles,omitempty" json:"profiles"` // Empty allows every profile
+}
+
+// HasScope reports whether the key grants scope. Admin keys grant every scope.
+func (k *Key) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsProfile reports whether the key may be used for profile
+func (k *Key) AllowsProfile(profile string) bool {
+	if len(k.Profiles) == 0 {
+		return true
+	}
+	for _, p := range k.Profiles {
+		if p == profile {
+			return true
+		}
+	}
+	return false
+}
+
+// Keyring holds the API keys accepted by APIKeyMiddleware, indexed by secret hash
+type Keyring struct {
+	byHash map[string]*Key
+}
+
+// NewKeyring validates keys and builds a keyring from them
+func NewKeyring(keys []Key) (*Keyring, error) {
+	kr := &Keyring{byHash: make(map[string]*Key)}
+	ids := make(map[string]bool)
+	for i := range keys {
+		key := keys[i]
+		if key.ID == "" {
+			return nil, fmt.Errorf("api key #%d is missing an id", i+1)
+		}
+		if ids[key.ID] {
+			return nil, fmt.Errorf("duplicate api key id: %s", key.ID)
+		}
+		ids[key.ID] = true
+
+		hash := strings.ToLower(strings.TrimPrefix(key.SecretHash, "sha256:"))
+		if raw, err := hex.DecodeString(hash); err != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("api key %s: secret_hash must be a hex SHA-256 digest", key.ID)
+		}
+		if other, ok := kr.byHash[hash]; ok {
+			return nil, fmt.Errorf("api keys %s and %s have the same secret_hash", other.ID, key.ID)
+		}
+		if len(key.Scopes) == 0 {
+			return nil, fmt.Errorf("api key %s has no scopes", key.ID)
+		}
+		for _, scope := range key.Scopes {
+			if !knownScopes[scope] {
+				return nil, fmt.Errorf("api key %s: unknown scope %s", key.ID, scope)
+			}
+		}
+		key.SecretHash = hash
+		kr.byHash[hash] = &key
+	}
+	return kr, nil
+}
+
+// LoadKeyringFile reads keys from a YAML file with a top-level "keys" list
+func LoadKeyringFile(path string) ([]Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read api keys file: %w", err)
+	}
+	var file struct {
+		Keys []Key `yaml:"keys"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse api keys file: %w", err)
+	}
+	return file.Keys, nil
+}
+
+// LegacyKey turns a single shared secret (API_KEY) into an admin key
+func LegacyKey(secret string) Key {
+	return Key{ID: legacyKeyID, SecretHash: HashSecret(secret), Scopes: []string{ScopeAdmin}}
+}
+
+// HashSecret returns the value to store in a key's secret_hash
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Len returns the number of keys
+func (kr *Keyring) Len() int {
+	if kr == nil {
+		return 0
+	}
+	return len(kr.byHash)
+}
+
+// Lookup returns the key whose secret is presented
+func (kr *Keyring) Lookup(secret string) (*Key, bool) {
+	if kr == nil || secret == "" {
+		return nil, false
+	}
+	key, ok := kr.byHash[HashSecret(secret)]
+	return key, ok
+}
+
+// WithKey returns a context carrying the API key that authenticated the request
+func WithKey(ctx context.Context, key *Key) context.Context {
+	return context.WithValue(ctx, apiKeyKey, key)
+}
+
+// KeyFromContext returns the API key that authenticated the request, if any
+func KeyFromContext(ctx context.Context) (*Key, bool) {
+	key, ok := ctx.Value(apiKeyKey).(*Key)
+	return key, ok
+}
+
+// WithAuthDisabled returns a context marking a request that went through authentication while no
+// API keys are configured (development mode). Authorize lets such requests through.
+func WithAuthDisabled(ctx context.Context) context.Context {
+	return context.WithValue(ctx, authDisabledKey, true)
+}
+
+// authDisabled reports whether the request was authenticated with no API keys configured
+func authDisabled(ctx context.Context) bool {
+	disabled, _ := ctx.Value(authDisabledKey).(bool)
+	return disabled
+}
+
+// tokenGrants reports whether the request's verified token stands in for an API key with scope:
+// upload tokens for upload:presign and read tokens for assets:read. Their claims are enforced by the handlers.
+func tokenGrants(ctx context.Context, scope string) bool {
+	if _, ok := UploadClaimsFromContext(ctx); ok && (scope == "" || scope == ScopeUploadPresign) {
+		return true
+	}
+	if _, ok := ReadClaimsFromContext(ctx); ok && (scope == "" || scope == ScopeAssetsRead) {
+		return true
+	}
+	return false
+}
+
+// Authorize checks that the request's API key grants scope and may use profile.
+// An empty scope or profile skips that check. Requests without a key identity are only allowed
+// with a verified token for the scope, or when authentication is disabled; anything else is forbidden.
+func Authorize(ctx context.Context, scope, profile string) error {
+	key, ok := KeyFromContext(ctx)
+	if !ok {
+		if tokenGrants(ctx, scope) || authDisabled(ctx) {
+			return nil
+		}
+		return fmt.Errorf("%w: no api key or token", ErrForbidden)
+	}
+	if scope != "" && !key.HasScope(scope) {
+		return fmt.Errorf("%w: api key %s lacks scope %s", ErrForbidden, key.ID, scope)
+	}
+	if profile != "" && !key.AllowsProfile(profile) {
+		return fmt.Errorf("%w: api key %s may not use profile %s", ErrForbidden, key.ID, profile)
+	}
+	return nil
+}
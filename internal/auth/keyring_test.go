@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testKeyring(t *testing.T) *Keyring {
+	t.Helper()
+	kr, err := NewKeyring([]Key{
+		{ID: "uploader", SecretHash: HashSecret("upload-secret"), Scopes: []string{ScopeUploadPresign}, Profiles: []string{"avatar"}},
+		{ID: "janitor", SecretHash: "sha256:" + HashSecret("delete-secret"), Scopes: []string{ScopeAssetsDelete}},
+		LegacyKey("admin-secret"),
+	})
+	if err != nil {
+		t.Fatalf("NewKeyring failed: %v", err)
+	}
+	return kr
+}
+
+func TestKeyring_Lookup(t *testing.T) {
+	kr := testKeyring(t)
+
+	tests := []struct {
+		secret     string
+		expectedID string
+	}{
+		{"upload-secret", "uploader"},
+		{"delete-secret", "janitor"},
+		{"admin-secret", "default"},
+		{"unknown", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		key, ok := kr.Lookup(tt.secret)
+		if tt.expectedID == "" {
+			if ok {
+				t.Errorf("Lookup(%q): expected no key, got %s", tt.secret, key.ID)
+			}
+			continue
+		}
+		if !ok || key.ID != tt.expectedID {
+			t.Errorf("Lookup(%q) = %v, %v; expected %s", tt.secret, key, ok, tt.expectedID)
+		}
+	}
+}
+
+func TestNewKeyring_InvalidKeys(t *testing.T) {
+	hash := HashSecret("secret")
+	tests := []struct {
+		name string
+		keys []Key
+	}{
+		{"Missing id", []Key{{SecretHash: hash, Scopes: []string{ScopeAdmin}}}},
+		{"Duplicate id", []Key{{ID: "a", SecretHash: hash, Scopes: []string{ScopeAdmin}}, {ID: "a", SecretHash: HashSecret("other"), Scopes: []string{ScopeAdmin}}}},
+		{"Duplicate secret", []Key{{ID: "a", SecretHash: hash, Scopes: []string{ScopeAdmin}}, {ID: "b", SecretHash: "sha256:" + strings.ToUpper(hash), Scopes: []string{ScopeAssetsRead}}}},
+		{"Plaintext secret", []Key{{ID: "a", SecretHash: "secret", Scopes: []string{ScopeAdmin}}}},
+		{"No scopes", []Key{{ID: "a", SecretHash: hash}}},
+		{"Unknown scope", []Key{{ID: "a", SecretHash: hash, Scopes: []string{"assets:write"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewKeyring(tt.keys); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	kr := testKeyring(t)
+	uploader, _ := kr.Lookup("upload-secret")
+	janitor, _ := kr.Lookup("delete-secret")
+	admin, _ := kr.Lookup("admin-secret")
+
+	tests := []struct {
+		name    string
+		key     *Key
+		scope   string
+		profile string
+		allowed bool
+	}{
+		{"Scope and profile granted", uploader, ScopeUploadPresign, "avatar", true},
+		{"Profile not allowed", uploader, ScopeUploadPresign, "banner", false},
+		{"Scope not granted", uploader, ScopeAssetsDelete, "avatar", false},
+		{"Any profile", janitor, ScopeAssetsDelete, "banner", true},
+		{"Admin implies every scope", admin, ScopeThumbWrite, "avatar", true},
+		{"No scope required", uploader, "", "avatar", true},
+		{"No key identity", nil, ScopeAdmin, "avatar", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.key != nil {
+				ctx = WithKey(ctx, tt.key)
+			}
+			err := Authorize(ctx, tt.scope, tt.profile)
+			if (err == nil) != tt.allowed {
+				t.Errorf("Authorize = %v, expected allowed=%v", err, tt.allowed)
+			}
+			if err != nil && !errors.Is(err, ErrForbidden) {
+				t.Errorf("Expected ErrForbidden, got %v", err)
+			}
+		})
+	}
+}
+
+func TestAuthorize_WithoutKey(t *testing.T) {
+	upload := WithUploadClaims(context.Background(), &UploadClaims{Profile: "avatar"})
+	read := WithReadClaims(context.Background(), &ReadClaims{Profile: "kyc", KeyBase: "doc-1"})
+	disabled := WithAuthDisabled(context.Background())
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		scope   string
+		allowed bool
+	}{
+		{"Nothing", context.Background(), "", false},
+		{"Upload token", upload, ScopeUploadPresign, true},
+		{"Upload token for another scope", upload, ScopeAssetsDelete, false},
+		{"Read token", read, "", true},
+		{"Read token for another scope", read, ScopeUploadPresign, false},
+		{"Authentication disabled", disabled, ScopeAdmin, true},
+	}
+	for _, tt := range tests {
+		if err := Authorize(tt.ctx, tt.scope, "avatar"); (err == nil) != tt.allowed {
+			t.Errorf("%s: Authorize = %v, expected allowed=%v", tt.name, err, tt.allowed)
+		}
+	}
+
+	// Authentication is only disabled by middleware with no keys configured
+	handler := APIKeyMiddleware(&Config{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := Authorize(r.Context(), ScopeAdmin, ""); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/jobs/1", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected requests to pass with no keys configured, got %d", rr.Code)
+	}
+}
+
+func TestAPIKeyMiddleware_PutsKeyInContext(t *testing.T) {
+	handler := APIKeyMiddleware(&Config{Keyring: testKeyring(t)})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := KeyFromContext(r.Context())
+		if !ok {
+			t.Fatal("Expected a key identity in the request context")
+		}
+		w.Write([]byte(key.ID))
+	}))
+
+	for header, expectedID := range map[string]string{
+		"Authorization": "uploader",
+		"X-API-Key":     "janitor",
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/uploads/presign", nil)
+		if header == "Authorization" {
+			req.Header.Set(header, "Bearer upload-secret")
+		} else {
+			req.Header.Set(header, "delete-secret")
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK || rr.Body.String() != expectedID {
+			t.Errorf("%s: expected key %s, got %d %q", header, expectedID, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestLoadKeyringFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	content := `keys:
+  - id: web-uploader
+    secret_hash: "` + HashSecret("s3cret") + `"
+    scopes: ["upload:presign"]
+    profiles: ["avatar", "photo"]
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := LoadKeyringFile(path)
+	if err != nil {
+		t.Fatalf("LoadKeyringFile failed: %v", err)
+	}
+	kr, err := NewKeyring(keys)
+	if err != nil {
+		t.Fatalf("NewKeyring failed: %v", err)
+	}
+	key, ok := kr.Lookup("s3cret")
+	if !ok || key.ID != "web-uploader" || !key.AllowsProfile("photo") || key.AllowsProfile("banner") {
+		t.Errorf("Unexpected key: %+v, %v", key, ok)
+	}
+}
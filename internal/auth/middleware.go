@@ -7,7 +7,18 @@ import (
 )
 
 type Config struct {
-	APIKey string
+	APIKey  string   // Single shared key, accepted as an admin key with ID "default" when Keyring is nil
+	Keyring *Keyring // Named keys with scopes and profile restrictions
+}
+
+// keyring returns the configured keyring, or one holding the legacy APIKey
+func (c *Config) keyring() *Keyring {
+	if c.Keyring == nil && c.APIKey != "" {
+		// A single legacy key is always valid
+		keyring, _ := NewKeyring([]Key{LegacyKey(c.APIKey)})
+		return keyring
+	}
+	return c.Keyring
 }
 
 type ErrorResponse struct {
@@ -16,32 +27,21 @@ type ErrorResponse struct {
 	Hint    string `json:"hint,omitempty"`
 }
 
-// APIKeyMiddleware validates API key authentication
+// APIKeyMiddleware validates API key authentication against the keyring.
+// The matching key is put in the request context for handlers to check scopes (see Authorize).
 func APIKeyMiddleware(config *Config) func(http.Handler) http.Handler {
+	keyring := config.keyring()
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Skip auth if no API key configured (for development)
-			if config.APIKey == "" {
-				next.ServeHTTP(w, r)
+			if keyring.Len() == 0 {
+				next.ServeHTTP(w, r.WithContext(WithAuthDisabled(r.Context())))
 				return
 			}
 
-			// Check Authorization header (Bearer token)
-			authHeader := r.Header.Get("Authorization")
-			if authHeader != "" {
-				if strings.HasPrefix(authHeader, "Bearer ") {
-					token := strings.TrimPrefix(authHeader, "Bearer ")
-					if token == config.APIKey {
-						next.ServeHTTP(w, r)
-						return
-					}
-				}
-			}
-
-			// Check X-API-Key header
-			apiKeyHeader := r.Header.Get("X-API-Key")
-			if apiKeyHeader == config.APIKey {
-				next.ServeHTTP(w, r)
+			if key, ok := requestKey(keyring, r); ok {
+				next.ServeHTTP(w, r.WithContext(WithKey(r.Context(), key)))
 				return
 			}
 
@@ -51,14 +51,45 @@ func APIKeyMiddleware(config *Config) func(http.Handler) http.Handler {
 	}
 }
 
+// OptionalAPIKeyMiddleware puts a valid API key in the request context like APIKeyMiddleware,
+// but lets requests without one through. For public read routes where a key only matters
+// for private profiles.
+func OptionalAPIKeyMiddleware(config *Config) func(http.Handler) http.Handler {
+	keyring := config.keyring()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if keyring.Len() == 0 {
+				r = r.WithContext(WithAuthDisabled(r.Context()))
+			} else if key, ok := requestKey(keyring, r); ok {
+				r = r.WithContext(WithKey(r.Context(), key))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requestKey looks up the API key presented as Authorization: Bearer <key> or X-API-Key: <key>
+func requestKey(keyring *Keyring, r *http.Request) (*Key, bool) {
+	authHeader := r.Header.Get("Authorization")
+	if strings.HasPrefix(authHeader, "Bearer ") {
+		if key, ok := keyring.Lookup(strings.TrimPrefix(authHeader, "Bearer ")); ok {
+			return key, true
+		}
+	}
+	return keyring.Lookup(r.Header.Get("X-API-Key"))
+}
+
 func writeUnauthorized(w http.ResponseWriter) {
-	errorResp := ErrorResponse{
+	writeError(w, http.StatusUnauthorized, ErrorResponse{
 		Code:    "unauthorized",
 		Message: "Invalid or missing API key",
 		Hint:    "Provide API key via Authorization: Bearer <key> or X-API-Key: <key>",
-	}
+	})
+}
 
+func writeError(w http.ResponseWriter, statusCode int, errorResp ErrorResponse) {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusUnauthorized)
+	w.WriteHeader(statusCode)
 	_ = json.NewEncoder(w).Encode(errorResp)
-}
\ No newline at end of file
+}
@@ -0,0 +1,306 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Upload token signing algorithms
+const (
+	AlgHS256 = "HS256"
+	AlgEdDSA = "EdDSA"
+)
+
+const (
+	tokenIssuer    = "mediaflow"
+	uploadAudience = "upload"
+	readAudience   = "read"
+)
+
+// UploadClaims scope an upload token to a profile, an optional key_base prefix and a maximum size
+type UploadClaims struct {
+	Profile       string `json:"profile"`
+	KeyBasePrefix string `json:"key_base_prefix,omitempty"`
+	MaxSizeBytes  int64  `json:"max_size_bytes,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Authorize checks an upload request against the token's scope.
+// Empty keyBase and zero size skip their checks, for requests that don't carry them.
+func (c *UploadClaims) Authorize(profile, keyBase string, sizeBytes int64) error {
+	if profile != c.Profile {
+		return fmt.Errorf("upload token is not valid for profile '%s'", profile)
+	}
+	if keyBase != "" && !strings.HasPrefix(keyBase, c.KeyBasePrefix) {
+		return fmt.Errorf("upload token only allows key_base starting with '%s'", c.KeyBasePrefix)
+	}
+	if sizeBytes > 0 && c.MaxSizeBytes > 0 && sizeBytes > c.MaxSizeBytes {
+		return fmt.Errorf("upload token allows at most %d bytes", c.MaxSizeBytes)
+	}
+	return nil
+}
+
+// ReadClaims grant read access to one asset of a private profile
+type ReadClaims struct {
+	Profile string `json:"profile"`
+	KeyBase string `json:"key_base"`
+	jwt.RegisteredClaims
+}
+
+// TokenIssuer mints and verifies upload and read tokens. Tokens are signed with the active key;
+// any configured key is accepted for verification, so keys can be rotated.
+type TokenIssuer struct {
+	method     jwt.SigningMethod
+	activeKID  string
+	signKeys   map[string]any
+	verifyKeys map[string]any
+}
+
+// NewTokenIssuer creates an issuer for alg (HS256 or EdDSA) from "kid:secret" pairs separated by commas.
+// HS256 secrets are used as-is; EdDSA secrets are base64-encoded 32-byte Ed25519 seeds.
+// activeKID may be empty when there is exactly one key.
+func NewTokenIssuer(alg, activeKID, keys string) (*TokenIssuer, error) {
+	if alg == "" {
+		alg = AlgHS256
+	}
+	issuer := &TokenIssuer{
+		activeKID:  activeKID,
+		signKeys:   make(map[string]any),
+		verifyKeys: make(map[string]any),
+	}
+	switch alg {
+	case AlgHS256:
+		issuer.method = jwt.SigningMethodHS256
+	case AlgEdDSA:
+		issuer.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported signing_alg: %s", alg)
+	}
+
+	for _, pair := range strings.Split(keys, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kid, secret, ok := strings.Cut(pair, ":")
+		if !ok || kid == "" || secret == "" {
+			return nil, fmt.Errorf("invalid signing key entry %q: expected kid:secret", pair)
+		}
+		switch alg {
+		case AlgHS256:
+			issuer.signKeys[kid] = []byte(secret)
+			issuer.verifyKeys[kid] = []byte(secret)
+		case AlgEdDSA:
+			seed, err := base64.StdEncoding.DecodeString(secret)
+			if err != nil || len(seed) != ed25519.SeedSize {
+				return nil, fmt.Errorf("signing key %s: expected a base64-encoded %d-byte Ed25519 seed", kid, ed25519.SeedSize)
+			}
+			private := ed25519.NewKeyFromSeed(seed)
+			issuer.signKeys[kid] = private
+			issuer.verifyKeys[kid] = private.Public()
+		}
+	}
+
+	if len(issuer.signKeys) == 0 {
+		return nil, errors.New("no signing keys configured")
+	}
+	if issuer.activeKID == "" {
+		if len(issuer.signKeys) > 1 {
+			return nil, errors.New("active_kid is required when more than one signing key is configured")
+		}
+		for kid := range issuer.signKeys {
+			issuer.activeKID = kid
+		}
+	}
+	if _, ok := issuer.signKeys[issuer.activeKID]; !ok {
+		return nil, fmt.Errorf("active_kid %s has no signing key", issuer.activeKID)
+	}
+	return issuer, nil
+}
+
+// Mint signs an upload token for claims that expires after ttl
+func (i *TokenIssuer) Mint(claims UploadClaims, ttl time.Duration) (string, time.Time, error) {
+	claims.RegisteredClaims = newRegisteredClaims(uploadAudience, ttl)
+	token, err := i.sign(&claims)
+	return token, claims.ExpiresAt.Time, err
+}
+
+// Verify checks an upload token's signature, algorithm, issuer, audience and expiry and returns its claims
+func (i *TokenIssuer) Verify(tokenString string) (*UploadClaims, error) {
+	claims := &UploadClaims{}
+	if err := i.parse(tokenString, claims, uploadAudience); err != nil {
+		return nil, err
+	}
+	if claims.Profile == "" {
+		return nil, errors.New("upload token has no profile")
+	}
+	return claims, nil
+}
+
+// MintRead signs a read token for claims that expires after ttl
+func (i *TokenIssuer) MintRead(claims ReadClaims, ttl time.Duration) (string, time.Time, error) {
+	claims.RegisteredClaims = newRegisteredClaims(readAudience, ttl)
+	token, err := i.sign(&claims)
+	return token, claims.ExpiresAt.Time, err
+}
+
+// VerifyRead checks a read token and returns its claims
+func (i *TokenIssuer) VerifyRead(tokenString string) (*ReadClaims, error) {
+	claims := &ReadClaims{}
+	if err := i.parse(tokenString, claims, readAudience); err != nil {
+		return nil, err
+	}
+	if claims.Profile == "" || claims.KeyBase == "" {
+		return nil, errors.New("read token has no profile or key_base")
+	}
+	return claims, nil
+}
+
+func newRegisteredClaims(audience string, ttl time.Duration) jwt.RegisteredClaims {
+	now := time.Now()
+	return jwt.RegisteredClaims{
+		Issuer:    tokenIssuer,
+		Audience:  jwt.ClaimStrings{audience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	}
+}
+
+func (i *TokenIssuer) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(i.method, claims)
+	token.Header["kid"] = i.activeKID
+	signed, err := token.SignedString(i.signKeys[i.activeKID])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+	return signed, nil
+}
+
+// parse verifies a token for audience into claims. The audience keeps upload tokens from
+// being accepted as read tokens and vice versa.
+func (i *TokenIssuer) parse(tokenString string, claims jwt.Claims, audience string) error {
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := i.verifyKeys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		return key, nil
+	},
+		jwt.WithValidMethods([]string{i.method.Alg()}),
+		jwt.WithIssuer(tokenIssuer),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+	)
+	return err
+}
+
+type contextKey int
+
+const (
+	uploadClaimsKey contextKey = iota
+	apiKeyKey
+	readClaimsKey
+	authDisabledKey
+)
+
+// WithUploadClaims returns a context carrying the claims of the request's upload token
+func WithUploadClaims(ctx context.Context, claims *UploadClaims) context.Context {
+	return context.WithValue(ctx, uploadClaimsKey, claims)
+}
+
+// UploadClaimsFromContext returns the upload token claims, if the request was authenticated with one
+func UploadClaimsFromContext(ctx context.Context) (*UploadClaims, bool) {
+	claims, ok := ctx.Value(uploadClaimsKey).(*UploadClaims)
+	return claims, ok
+}
+
+// WithReadClaims returns a context carrying the claims of the request's read token
+func WithReadClaims(ctx context.Context, claims *ReadClaims) context.Context {
+	return context.WithValue(ctx, readClaimsKey, claims)
+}
+
+// ReadClaimsFromContext returns the read token claims, if the request carried one
+func ReadClaimsFromContext(ctx context.Context) (*ReadClaims, bool) {
+	claims, ok := ctx.Value(readClaimsKey).(*ReadClaims)
+	return claims, ok
+}
+
+// ReadTokenMiddleware verifies a read token passed as ?token= (for <img> tags) or as a Bearer JWT,
+// and puts its claims in the request context. asset returns the profile and key_base the request
+// reads; a token minted for another asset is ignored. Requests without a token for their asset go
+// through fallback, or straight to the handler when fallback is nil. Handlers of private profiles check the claims.
+func ReadTokenMiddleware(issuer *TokenIssuer, fallback func(http.Handler) http.Handler, asset func(r *http.Request) (profile, keyBase string)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		guarded := next
+		if fallback != nil {
+			guarded = fallback(next)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := r.URL.Query().Get("token")
+			if token == "" {
+				token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			}
+			if issuer == nil || !looksLikeJWT(token) {
+				guarded.ServeHTTP(w, r)
+				return
+			}
+
+			claims, err := issuer.VerifyRead(token)
+			if err != nil {
+				writeError(w, http.StatusUnauthorized, ErrorResponse{
+					Code:    "unauthorized",
+					Message: fmt.Sprintf("Invalid read token: %v", err),
+					Hint:    "Request a new read token",
+				})
+				return
+			}
+			// A read token only stands in for the API key on its own asset
+			if profile, keyBase := asset(r); claims.Profile != profile || claims.KeyBase != keyBase {
+				guarded.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithReadClaims(r.Context(), claims)))
+		})
+	}
+}
+
+// UploadTokenMiddleware accepts an upload token in place of the API key.
+// Bearer JWTs must verify and their claims are put in the request context for handlers to enforce;
+// all other requests go through fallback. A nil issuer disables upload tokens.
+func UploadTokenMiddleware(issuer *TokenIssuer, fallback func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		guarded := fallback(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if issuer == nil || !looksLikeJWT(token) {
+				guarded.ServeHTTP(w, r)
+				return
+			}
+
+			claims, err := issuer.Verify(token)
+			if err != nil {
+				writeError(w, http.StatusUnauthorized, ErrorResponse{
+					Code:    "unauthorized",
+					Message: fmt.Sprintf("Invalid upload token: %v", err),
+					Hint:    "Request a new upload token",
+				})
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithUploadClaims(r.Context(), claims)))
+		})
+	}
+}
+
+// looksLikeJWT distinguishes compact JWTs (base64url JSON header) from opaque API keys
+func looksLikeJWT(token string) bool {
+	return strings.HasPrefix(token, "eyJ") && strings.Count(token, ".") == 2
+}
@@ -0,0 +1,261 @@
+package auth
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSeed = base64.StdEncoding.EncodeToString(make([]byte, 32))
+
+func TestTokenIssuer_MintAndVerify(t *testing.T) {
+	tests := []struct {
+		name string
+		alg  string
+		keys string
+	}{
+		{"HS256", AlgHS256, "k1:test-secret"},
+		{"EdDSA", AlgEdDSA, "k1:" + testSeed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer, err := NewTokenIssuer(tt.alg, "", tt.keys)
+			if err != nil {
+				t.Fatalf("NewTokenIssuer failed: %v", err)
+			}
+			token, expiresAt, err := issuer.Mint(UploadClaims{Profile: "avatar", KeyBasePrefix: "user-42/", MaxSizeBytes: 1024}, time.Minute)
+			if err != nil {
+				t.Fatalf("Mint failed: %v", err)
+			}
+			if time.Until(expiresAt) > time.Minute {
+				t.Errorf("Unexpected expiry %v", expiresAt)
+			}
+
+			claims, err := issuer.Verify(token)
+			if err != nil {
+				t.Fatalf("Verify failed: %v", err)
+			}
+			if claims.Profile != "avatar" || claims.KeyBasePrefix != "user-42/" || claims.MaxSizeBytes != 1024 {
+				t.Errorf("Unexpected claims: %+v", claims)
+			}
+		})
+	}
+}
+
+func TestTokenIssuer_RejectsInvalidTokens(t *testing.T) {
+	issuer, _ := NewTokenIssuer(AlgHS256, "", "k1:test-secret")
+	other, _ := NewTokenIssuer(AlgHS256, "", "k1:other-secret")
+	unknownKID, _ := NewTokenIssuer(AlgHS256, "", "k2:test-secret")
+	eddsa, _ := NewTokenIssuer(AlgEdDSA, "", "k1:"+testSeed)
+
+	claims := UploadClaims{Profile: "avatar"}
+	expired, _, _ := issuer.Mint(claims, -time.Minute)
+	forged, _, _ := other.Mint(claims, time.Minute)
+	rotatedOut, _, _ := unknownKID.Mint(claims, time.Minute)
+	wrongAlg, _, _ := eddsa.Mint(claims, time.Minute)
+	noProfile, _, _ := issuer.Mint(UploadClaims{}, time.Minute)
+
+	for name, token := range map[string]string{
+		"expired":         expired,
+		"wrong secret":    forged,
+		"unknown kid":     rotatedOut,
+		"wrong algorithm": wrongAlg,
+		"no profile":      noProfile,
+		"garbage":         "eyJ.not.atoken",
+	} {
+		if _, err := issuer.Verify(token); err == nil {
+			t.Errorf("%s: expected verification to fail", name)
+		}
+	}
+}
+
+func TestTokenIssuer_KeyRotation(t *testing.T) {
+	old, _ := NewTokenIssuer(AlgHS256, "2024", "2024:old-secret")
+	rotated, err := NewTokenIssuer(AlgHS256, "2025", "2024:old-secret,2025:new-secret")
+	if err != nil {
+		t.Fatalf("NewTokenIssuer failed: %v", err)
+	}
+
+	token, _, _ := old.Mint(UploadClaims{Profile: "avatar"}, time.Minute)
+	if _, err := rotated.Verify(token); err != nil {
+		t.Errorf("Expected tokens signed with a retired key to verify, got %v", err)
+	}
+	token, _, _ = rotated.Mint(UploadClaims{Profile: "avatar"}, time.Minute)
+	if _, err := old.Verify(token); err == nil {
+		t.Errorf("Expected new tokens to be signed with the active key")
+	}
+}
+
+func TestNewTokenIssuer_InvalidConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		alg       string
+		activeKID string
+		keys      string
+	}{
+		{"No keys", AlgHS256, "", ""},
+		{"Malformed entry", AlgHS256, "", "just-a-secret"},
+		{"Unsupported algorithm", "RS256", "", "k1:secret"},
+		{"Ambiguous active key", AlgHS256, "", "k1:a,k2:b"},
+		{"Missing active key", AlgHS256, "k3", "k1:a,k2:b"},
+		{"Bad EdDSA seed", AlgEdDSA, "", "k1:c2hvcnQ="},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewTokenIssuer(tt.alg, tt.activeKID, tt.keys); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
+
+func TestUploadClaims_Authorize(t *testing.T) {
+	claims := &UploadClaims{Profile: "avatar", KeyBasePrefix: "user-42/", MaxSizeBytes: 1000}
+
+	tests := []struct {
+		name    string
+		profile string
+		keyBase string
+		size    int64
+		allowed bool
+	}{
+		{"In scope", "avatar", "user-42/photo", 1000, true},
+		{"Other profile", "banner", "user-42/photo", 10, false},
+		{"Other key_base", "avatar", "user-43/photo", 10, false},
+		{"Too large", "avatar", "user-42/photo", 1001, false},
+		{"No key_base or size to check", "avatar", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := claims.Authorize(tt.profile, tt.keyBase, tt.size)
+			if (err == nil) != tt.allowed {
+				t.Errorf("Authorize = %v, expected allowed=%v", err, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestUploadTokenMiddleware(t *testing.T) {
+	issuer, _ := NewTokenIssuer(AlgHS256, "", "k1:test-secret")
+	valid, _, _ := issuer.Mint(UploadClaims{Profile: "avatar"}, time.Minute)
+	expired, _, _ := issuer.Mint(UploadClaims{Profile: "avatar"}, -time.Minute)
+
+	handler := UploadTokenMiddleware(issuer, APIKeyMiddleware(&Config{APIKey: "test-api-key"}))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if claims, ok := UploadClaimsFromContext(r.Context()); ok {
+				w.Write([]byte("token:" + claims.Profile))
+				return
+			}
+			w.Write([]byte("api-key"))
+		}))
+
+	tests := []struct {
+		name           string
+		authHeader     string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"Upload token", "Bearer " + valid, http.StatusOK, "token:avatar"},
+		{"API key", "Bearer test-api-key", http.StatusOK, "api-key"},
+		{"Expired token", "Bearer " + expired, http.StatusUnauthorized, ""},
+		{"Wrong API key", "Bearer nope", http.StatusUnauthorized, ""},
+		{"No credentials", "", http.StatusUnauthorized, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/uploads/presign", nil)
+			if tt.authHeader != "" {
+				req.Header.Set("Authorization", tt.authHeader)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+			if tt.expectedBody != "" && !strings.Contains(rr.Body.String(), tt.expectedBody) {
+				t.Errorf("Expected body %q, got %q", tt.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestReadTokenMiddleware(t *testing.T) {
+	issuer, _ := NewTokenIssuer(AlgHS256, "", "k1:test-secret")
+	valid, _, _ := issuer.MintRead(ReadClaims{Profile: "kyc", KeyBase: "doc-1"}, time.Minute)
+	expired, _, _ := issuer.MintRead(ReadClaims{Profile: "kyc", KeyBase: "doc-1"}, -time.Minute)
+	// Upload tokens have a different audience and must not grant reads
+	upload, _, _ := issuer.Mint(UploadClaims{Profile: "kyc"}, time.Minute)
+
+	other, _, _ := issuer.MintRead(ReadClaims{Profile: "kyc", KeyBase: "doc-2"}, time.Minute)
+
+	// Requests read /originals/{profile}/{key_base}.jpg
+	asset := func(r *http.Request) (string, string) {
+		profile, file, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/originals/"), "/")
+		return profile, strings.TrimSuffix(file, ".jpg")
+	}
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if claims, ok := ReadClaimsFromContext(r.Context()); ok {
+			w.Write([]byte("token:" + claims.Profile + "/" + claims.KeyBase))
+			return
+		}
+		w.Write([]byte("anonymous"))
+	})
+	handler := ReadTokenMiddleware(issuer, nil, asset)(echo)
+
+	tests := []struct {
+		name           string
+		query          string
+		authHeader     string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"Query token", "?token=" + valid, "", http.StatusOK, "token:kyc/doc-1"},
+		{"Bearer token", "", "Bearer " + valid, http.StatusOK, "token:kyc/doc-1"},
+		{"No token", "", "", http.StatusOK, "anonymous"},
+		{"API key passes through", "", "Bearer some-api-key", http.StatusOK, "anonymous"},
+		{"Expired token", "?token=" + expired, "", http.StatusUnauthorized, ""},
+		{"Upload token", "?token=" + upload, "", http.StatusUnauthorized, ""},
+		{"Token for another asset", "?token=" + other, "", http.StatusOK, "anonymous"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/originals/kyc/doc-1.jpg"+tt.query, nil)
+			if tt.authHeader != "" {
+				req.Header.Set("Authorization", tt.authHeader)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+			if tt.expectedBody != "" && rr.Body.String() != tt.expectedBody {
+				t.Errorf("Expected body %q, got %q", tt.expectedBody, rr.Body.String())
+			}
+		})
+	}
+
+	// A token for another asset doesn't get past a fallback that requires an API key
+	guarded := ReadTokenMiddleware(issuer, APIKeyMiddleware(&Config{APIKey: "test-api-key"}), asset)(echo)
+	for query, expectedStatus := range map[string]int{"?token=" + valid: http.StatusOK, "?token=" + other: http.StatusUnauthorized} {
+		rr := httptest.NewRecorder()
+		guarded.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/originals/kyc/doc-1.jpg"+query, nil))
+		if rr.Code != expectedStatus {
+			t.Errorf("%s: expected status %d behind an API key, got %d", query, expectedStatus, rr.Code)
+		}
+	}
+
+	// Read tokens are not upload tokens either
+	if _, err := issuer.Verify(valid); err == nil {
+		t.Error("Expected a read token to be rejected as an upload token")
+	}
+}
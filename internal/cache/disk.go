@@ -0,0 +1,300 @@
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	utils "mediaflow/internal"
+	"mediaflow/internal/storage"
+)
+
+const (
+	diskDataExt    = ".bin"
+	diskMetaExt    = ".json"
+	diskTempPrefix = utils.TempFilePrefix
+)
+
+// Disk is an on-disk LRU cache of objects, bounded by total bytes.
+// Entries are written atomically (temp file + rename) and the index is rebuilt from
+// the directory at startup, so the cache survives restarts. Recency is tracked by
+// file modification time, which is bumped on every hit.
+type Disk struct {
+	dir      string
+	maxBytes int64
+
+	mu    sync.Mutex
+	ll    *list.List // Front is most recently used
+	items map[string]*list.Element
+	bytes int64
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+type diskItem struct {
+	key  string
+	name string // Hash of the key; file name stem
+	size int64
+}
+
+// diskMeta is stored next to each cached object
+type diskMeta struct {
+	Key  string              `json:"key"`
+	Info *storage.ObjectInfo `json:"info,omitempty"`
+}
+
+// NewDisk opens (or creates) a disk cache in dir holding at most maxBytes,
+// indexing any entries left by a previous run
+func NewDisk(dir string, maxBytes int64) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create disk cache directory: %w", err)
+	}
+	d := &Disk{
+		dir:      dir,
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+	if err := d.load(); err != nil {
+		return nil, fmt.Errorf("failed to index disk cache: %w", err)
+	}
+	return d, nil
+}
+
+// Get returns the cached entry for key and marks it as recently used
+func (d *Disk) Get(key string) (*Entry, bool) {
+	d.mu.Lock()
+	el, ok := d.items[key]
+	if ok {
+		d.ll.MoveToFront(el)
+	}
+	d.mu.Unlock()
+	if !ok {
+		d.misses.Add(1)
+		return nil, false
+	}
+
+	item := el.Value.(*diskItem)
+	entry, err := d.read(item)
+	if err != nil {
+		// Evicted or removed underneath us
+		d.Delete(key)
+		d.misses.Add(1)
+		return nil, false
+	}
+	now := time.Now()
+	_ = os.Chtimes(d.dataPath(item.name), now, now)
+	d.hits.Add(1)
+	return entry, true
+}
+
+// Set writes an entry to disk, evicting least recently used entries to stay within the byte budget.
+// Failures are logged and leave the cache without the entry.
+func (d *Disk) Set(key string, entry *Entry) {
+	size := int64(len(entry.Data))
+	if size > d.maxBytes {
+		return
+	}
+
+	name := diskName(key)
+	if err := d.write(name, key, entry); err != nil {
+		fmt.Printf("⚠️ Failed to write disk cache entry %s: %v\n", key, err)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if el, ok := d.items[key]; ok {
+		d.ll.Remove(el)
+		d.bytes -= el.Value.(*diskItem).size
+	}
+	d.items[key] = d.ll.PushFront(&diskItem{key: key, name: name, size: size})
+	d.bytes += size
+
+	for d.bytes > d.maxBytes {
+		d.removeElement(d.ll.Back())
+		d.evictions.Add(1)
+	}
+}
+
+// Delete removes key from the cache
+func (d *Disk) Delete(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if el, ok := d.items[key]; ok {
+		d.removeElement(el)
+	}
+}
+
+// DeletePrefix removes every key starting with prefix
+func (d *Disk) DeletePrefix(prefix string) {
+	d.DeleteFunc(func(key string) bool { return strings.HasPrefix(key, prefix) })
+}
+
+// DeleteFunc removes every key that match reports true for
+func (d *Disk) DeleteFunc(match func(key string) bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for key, el := range d.items {
+		if match(key) {
+			d.removeElement(el)
+		}
+	}
+}
+
+// Stats returns a snapshot of the cache counters
+func (d *Disk) Stats() Stats {
+	d.mu.Lock()
+	entries, bytes := len(d.items), d.bytes
+	d.mu.Unlock()
+
+	return Stats{
+		Hits:      d.hits.Load(),
+		Misses:    d.misses.Load(),
+		Evictions: d.evictions.Load(),
+		Entries:   entries,
+		Bytes:     bytes,
+		MaxBytes:  d.maxBytes,
+	}
+}
+
+// load rebuilds the index from the cache directory, oldest access first.
+// Leftover temp files and entries missing their data or metadata are removed.
+func (d *Disk) load() error {
+	type found struct {
+		item    *diskItem
+		touched time.Time
+	}
+	var entries []found
+
+	err := filepath.WalkDir(d.dir, func(p string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if e.IsDir() {
+			return nil
+		}
+		if strings.HasPrefix(e.Name(), diskTempPrefix) {
+			os.Remove(p)
+			return nil
+		}
+		if filepath.Ext(p) != diskMetaExt {
+			return nil
+		}
+
+		name := strings.TrimSuffix(e.Name(), diskMetaExt)
+		meta, err := d.readMeta(name)
+		fi, statErr := os.Stat(d.dataPath(name))
+		if err != nil || statErr != nil || diskName(meta.Key) != name {
+			d.removeFiles(name)
+			return nil
+		}
+		entries = append(entries, found{
+			item:    &diskItem{key: meta.Key, name: name, size: fi.Size()},
+			touched: fi.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].touched.Before(entries[j].touched) })
+	for _, f := range entries {
+		d.items[f.item.key] = d.ll.PushFront(f.item)
+		d.bytes += f.item.size
+	}
+	// The budget may have shrunk since the last run
+	for d.bytes > d.maxBytes {
+		d.removeElement(d.ll.Back())
+	}
+	return nil
+}
+
+func (d *Disk) read(item *diskItem) (*Entry, error) {
+	data, err := os.ReadFile(d.dataPath(item.name))
+	if err != nil {
+		return nil, err
+	}
+	meta, err := d.readMeta(item.name)
+	if err != nil {
+		return nil, err
+	}
+	return &Entry{Data: data, Info: meta.Info}, nil
+}
+
+func (d *Disk) readMeta(name string) (*diskMeta, error) {
+	raw, err := os.ReadFile(d.metaPath(name))
+	if err != nil {
+		return nil, err
+	}
+	var meta diskMeta
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return nil, err
+	}
+	if meta.Key == "" {
+		return nil, errors.New("cache metadata without key")
+	}
+	return &meta, nil
+}
+
+// write stores metadata before data; load only indexes entries that have both
+func (d *Disk) write(name, key string, entry *Entry) error {
+	if err := os.MkdirAll(filepath.Dir(d.dataPath(name)), 0o755); err != nil {
+		return err
+	}
+	meta, err := json.Marshal(diskMeta{Key: key, Info: entry.Info})
+	if err != nil {
+		return err
+	}
+	if err := utils.WriteFileAtomic(d.metaPath(name), bytes.NewReader(meta)); err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(d.dataPath(name), bytes.NewReader(entry.Data))
+}
+
+// removeElement unlinks an element and deletes its files. Callers must hold d.mu.
+func (d *Disk) removeElement(el *list.Element) {
+	item := el.Value.(*diskItem)
+	d.ll.Remove(el)
+	delete(d.items, item.key)
+	d.bytes -= item.size
+	d.removeFiles(item.name)
+}
+
+func (d *Disk) removeFiles(name string) {
+	os.Remove(d.dataPath(name))
+	os.Remove(d.metaPath(name))
+}
+
+// dataPath fans entries out over 256 subdirectories to keep directories small
+func (d *Disk) dataPath(name string) string {
+	return filepath.Join(d.dir, name[:2], name+diskDataExt)
+}
+
+func (d *Disk) metaPath(name string) string {
+	return filepath.Join(d.dir, name[:2], name+diskMetaExt)
+}
+
+// diskName maps a cache key to a file-system safe name
+func diskName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"mediaflow/internal/storage"
+)
+
+func newTestDisk(t *testing.T, dir string, maxBytes int64) *Disk {
+	t.Helper()
+	d, err := NewDisk(dir, maxBytes)
+	if err != nil {
+		t.Fatalf("NewDisk failed: %v", err)
+	}
+	return d
+}
+
+func TestDisk_RoundTripAndRestart(t *testing.T) {
+	dir := t.TempDir()
+	d := newTestDisk(t, dir, 1<<20)
+
+	info := &storage.ObjectInfo{Size: 5, ETag: `"abc"`, LastModified: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)}
+	d.Set("thumbnails/avatars/me_256.webp", &Entry{Data: []byte("thumb"), Info: info})
+
+	entry, ok := d.Get("thumbnails/avatars/me_256.webp")
+	if !ok || string(entry.Data) != "thumb" || entry.Info.ETag != `"abc"` {
+		t.Fatalf("Get = %+v, %v", entry, ok)
+	}
+
+	// A new instance over the same directory sees the entry
+	reopened := newTestDisk(t, dir, 1<<20)
+	entry, ok = reopened.Get("thumbnails/avatars/me_256.webp")
+	if !ok || string(entry.Data) != "thumb" || !entry.Info.LastModified.Equal(info.LastModified) {
+		t.Errorf("Expected entry to survive restart, got %+v, %v", entry, ok)
+	}
+	if stats := reopened.Stats(); stats.Entries != 1 || stats.Bytes != 5 {
+		t.Errorf("Unexpected stats after restart: %+v", stats)
+	}
+}
+
+func TestDisk_EvictsLeastRecentlyUsed(t *testing.T) {
+	dir := t.TempDir()
+	d := newTestDisk(t, dir, 30)
+
+	d.Set("a", entryOf(10))
+	d.Set("b", entryOf(10))
+	d.Set("c", entryOf(10))
+	d.Get("a") // a is now more recent than b
+	d.Set("d", entryOf(10))
+
+	if _, ok := d.Get("b"); ok {
+		t.Errorf("Expected b to be evicted")
+	}
+	if _, err := os.Stat(d.dataPath(diskName("b"))); !os.IsNotExist(err) {
+		t.Errorf("Expected evicted entry's file to be removed, got %v", err)
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok := d.Get(key); !ok {
+			t.Errorf("Expected %s to be cached", key)
+		}
+	}
+}
+
+func TestDisk_RestartKeepsAccessOrderAndBudget(t *testing.T) {
+	dir := t.TempDir()
+	d := newTestDisk(t, dir, 1<<20)
+	d.Set("old", entryOf(10))
+	d.Set("new", entryOf(10))
+
+	// Make "old" clearly least recently used on disk
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(d.dataPath(diskName("old")), past, past)
+
+	// Reopen with a budget that only fits one entry
+	reopened := newTestDisk(t, dir, 15)
+	if _, ok := reopened.Get("old"); ok {
+		t.Errorf("Expected least recently used entry to be evicted on startup")
+	}
+	if _, ok := reopened.Get("new"); !ok {
+		t.Errorf("Expected most recently used entry to survive")
+	}
+}
+
+func TestDisk_CleansUpPartialWrites(t *testing.T) {
+	dir := t.TempDir()
+	d := newTestDisk(t, dir, 1<<20)
+	d.Set("kept", entryOf(1))
+
+	// A crash mid-write leaves temp files and metadata without data
+	orphan := diskName("orphan")
+	os.MkdirAll(filepath.Join(dir, orphan[:2]), 0o755)
+	os.WriteFile(d.metaPath(orphan), []byte(`{"key":"orphan"}`), 0o644)
+	os.WriteFile(filepath.Join(dir, diskTempPrefix+"123"), []byte("partial"), 0o644)
+
+	reopened := newTestDisk(t, dir, 1<<20)
+	if stats := reopened.Stats(); stats.Entries != 1 {
+		t.Errorf("Expected only the complete entry to be indexed, got %d", stats.Entries)
+	}
+	filepath.WalkDir(dir, func(p string, e os.DirEntry, err error) error {
+		if err == nil && (strings.HasPrefix(e.Name(), diskTempPrefix) || strings.HasPrefix(e.Name(), orphan)) {
+			t.Errorf("Expected %s to be cleaned up", p)
+		}
+		return nil
+	})
+}
+
+func TestMemory_WithDiskTier(t *testing.T) {
+	dir := t.TempDir()
+	m := NewMemory(1<<20, newTestDisk(t, dir, 1<<20))
+
+	loads := 0
+	load := func() (*Entry, error) {
+		loads++
+		return &Entry{Data: []byte("thumb")}, nil
+	}
+	if _, err := m.Do("thumbnails/avatars/me_256.webp", load); err != nil {
+		t.Fatalf("Do failed: %v", err)
+	}
+
+	// A fresh process: empty memory tier, same disk
+	restarted := NewMemory(1<<20, newTestDisk(t, dir, 1<<20))
+	entry, err := restarted.Do("thumbnails/avatars/me_256.webp", load)
+	if err != nil || string(entry.Data) != "thumb" {
+		t.Fatalf("Do = %v, %v", entry, err)
+	}
+	if loads != 1 {
+		t.Errorf("Expected disk hit instead of a second load, got %d loads", loads)
+	}
+	if stats := restarted.Stats(); stats.Disk == nil || stats.Disk.Hits != 1 {
+		t.Errorf("Expected 1 disk hit, got %+v", stats.Disk)
+	}
+
+	// Invalidation reaches the disk tier
+	restarted.DeletePrefix("thumbnails/avatars/me_")
+	if _, ok := newTestDisk(t, dir, 1<<20).Get("thumbnails/avatars/me_256.webp"); ok {
+		t.Errorf("Expected invalidation to remove the disk entry")
+	}
+}
@@ -0,0 +1,230 @@
+package cache
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"mediaflow/internal/storage"
+)
+
+// Entry is a cached object: its bytes plus the storage metadata used for HTTP validators
+type Entry struct {
+	Data []byte
+	Info *storage.ObjectInfo
+}
+
+// size is the number of bytes an entry is charged against the cache budget
+func (e *Entry) size(key string) int64 {
+	return int64(len(e.Data) + len(key))
+}
+
+// Stats are cumulative cache counters
+type Stats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+	Bytes     int64  `json:"bytes"`
+	MaxBytes  int64  `json:"max_bytes"`
+	Disk      *Stats `json:"disk,omitempty"` // Lower tier, if any
+}
+
+// Tier is a slower cache level consulted on memory misses (e.g. Disk)
+type Tier interface {
+	Get(key string) (*Entry, bool)
+	Set(key string, entry *Entry)
+	Delete(key string)
+	DeletePrefix(prefix string)
+	DeleteFunc(match func(key string) bool)
+	Stats() Stats
+}
+
+// Memory is an in-process LRU cache of objects keyed by storage path, bounded by total bytes.
+// Concurrent loads of the same key are collapsed into one (see Do). An optional lower
+// tier is checked before loading and receives everything that gets loaded.
+type Memory struct {
+	maxBytes int64
+	lower    Tier
+
+	mu    sync.Mutex
+	ll    *list.List // Front is most recently used
+	items map[string]*list.Element
+	bytes int64
+
+	group group
+	// epoch is bumped by every invalidation so loads that started before it don't re-insert stale data
+	epoch atomic.Uint64
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+type memoryItem struct {
+	key   string
+	entry *Entry
+}
+
+// NewMemory creates a memory cache holding at most maxBytes, backed by lower (may be nil).
+// A limit of 0 or less disables the memory tier, but Do still collapses concurrent loads.
+func NewMemory(maxBytes int64, lower Tier) *Memory {
+	return &Memory{
+		maxBytes: maxBytes,
+		lower:    lower,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Get returns the cached entry for key and marks it as recently used
+func (c *Memory) Get(key string) (*Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	c.hits.Add(1)
+	return el.Value.(*memoryItem).entry, true
+}
+
+// Peek returns the cached entry for key without touching recency or the hit/miss counters.
+// Used for metadata lookups (e.g. conditional requests) ahead of a real read.
+func (c *Memory) Peek(key string) (*Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	return el.Value.(*memoryItem).entry, true
+}
+
+// Set stores an entry, evicting least recently used entries to stay within the byte budget.
+// Entries larger than the whole budget are not cached.
+func (c *Memory) Set(key string, entry *Entry) {
+	size := entry.size(key)
+	if size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+	c.items[key] = c.ll.PushFront(&memoryItem{key: key, entry: entry})
+	c.bytes += size
+
+	for c.bytes > c.maxBytes {
+		c.removeElement(c.ll.Back())
+		c.evictions.Add(1)
+	}
+}
+
+// Delete removes key from the cache and the lower tier
+func (c *Memory) Delete(key string) {
+	c.epoch.Add(1)
+	c.mu.Lock()
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+	c.mu.Unlock()
+
+	if c.lower != nil {
+		c.lower.Delete(key)
+	}
+}
+
+// DeletePrefix removes every key starting with prefix from the cache and the lower tier
+func (c *Memory) DeletePrefix(prefix string) {
+	c.DeleteFunc(func(key string) bool { return strings.HasPrefix(key, prefix) })
+}
+
+// DeleteFunc removes every key that match reports true for from the cache and the lower tier
+func (c *Memory) DeleteFunc(match func(key string) bool) {
+	c.epoch.Add(1)
+	c.mu.Lock()
+	for key, el := range c.items {
+		if match(key) {
+			c.removeElement(el)
+		}
+	}
+	c.mu.Unlock()
+
+	if c.lower != nil {
+		c.lower.DeleteFunc(match)
+	}
+}
+
+// Do returns the cached entry for key, or calls load once for all concurrent callers
+// asking for the same key and caches the result. Errors are not cached.
+func (c *Memory) Do(key string, load func() (*Entry, error)) (*Entry, error) {
+	if entry, ok := c.Get(key); ok {
+		return entry, nil
+	}
+	return c.group.do(key, func() (*Entry, error) {
+		// Another flight may have filled the key between our miss and acquiring the flight
+		if entry, ok := c.Peek(key); ok {
+			return entry, nil
+		}
+
+		epoch := c.epoch.Load()
+		if c.lower != nil {
+			if entry, ok := c.lower.Get(key); ok {
+				if c.epoch.Load() == epoch {
+					c.Set(key, entry)
+				}
+				return entry, nil
+			}
+		}
+
+		entry, err := load()
+		if err != nil {
+			return nil, err
+		}
+		if c.epoch.Load() == epoch {
+			c.Set(key, entry)
+			if c.lower != nil {
+				c.lower.Set(key, entry)
+			}
+		}
+		return entry, nil
+	})
+}
+
+// Stats returns a snapshot of the cache counters
+func (c *Memory) Stats() Stats {
+	c.mu.Lock()
+	entries, bytes := len(c.items), c.bytes
+	c.mu.Unlock()
+
+	stats := Stats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Entries:   entries,
+		Bytes:     bytes,
+		MaxBytes:  c.maxBytes,
+	}
+	if c.lower != nil {
+		lower := c.lower.Stats()
+		stats.Disk = &lower
+	}
+	return stats
+}
+
+// removeElement unlinks an element. Callers must hold c.mu.
+func (c *Memory) removeElement(el *list.Element) {
+	item := el.Value.(*memoryItem)
+	c.ll.Remove(el)
+	delete(c.items, item.key)
+	c.bytes -= item.entry.size(item.key)
+}
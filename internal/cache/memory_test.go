@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func entryOf(size int) *Entry {
+	return &Entry{Data: make([]byte, size)}
+}
+
+func TestMemory_EvictsLeastRecentlyUsed(t *testing.T) {
+	// Each entry costs 100 bytes of data plus 1 byte of key
+	c := NewMemory(303, nil)
+
+	c.Set("a", entryOf(100))
+	c.Set("b", entryOf(100))
+	c.Set("c", entryOf(100))
+	c.Get("a") // a is now more recent than b
+	c.Set("d", entryOf(100))
+
+	if _, ok := c.Peek("b"); ok {
+		t.Errorf("Expected b to be evicted")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok := c.Peek(key); !ok {
+			t.Errorf("Expected %s to be cached", key)
+		}
+	}
+
+	stats := c.Stats()
+	if stats.Entries != 3 || stats.Bytes != 303 || stats.Evictions != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestMemory_SkipsOversizedAndDisabled(t *testing.T) {
+	c := NewMemory(50, nil)
+	c.Set("big", entryOf(100))
+	if _, ok := c.Peek("big"); ok {
+		t.Errorf("Expected entry larger than the budget not to be cached")
+	}
+
+	disabled := NewMemory(0, nil)
+	disabled.Set("a", entryOf(1))
+	if _, ok := disabled.Peek("a"); ok {
+		t.Errorf("Expected a zero-size cache to store nothing")
+	}
+}
+
+func TestMemory_DeletePrefix(t *testing.T) {
+	c := NewMemory(1<<20, nil)
+	c.Set("thumbnails/avatars/abc_256.webp", entryOf(1))
+	c.Set("thumbnails/avatars/abc_512.webp", entryOf(1))
+	c.Set("thumbnails/avatars/abcd_256.webp", entryOf(1))
+
+	c.DeletePrefix("thumbnails/avatars/abc_")
+
+	if stats := c.Stats(); stats.Entries != 1 {
+		t.Errorf("Expected 1 entry left, got %d", stats.Entries)
+	}
+	if _, ok := c.Peek("thumbnails/avatars/abcd_256.webp"); !ok {
+		t.Errorf("Expected other asset sharing the prefix to stay cached")
+	}
+}
+
+func TestMemory_DoCollapsesConcurrentLoads(t *testing.T) {
+	c := NewMemory(1<<20, nil)
+	var loads atomic.Int32
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			entry, err := c.Do("thumbnails/avatars/me_256.webp", func() (*Entry, error) {
+				loads.Add(1)
+				<-release
+				return entryOf(10), nil
+			})
+			if err != nil || len(entry.Data) != 10 {
+				t.Errorf("Do = %v, %v", entry, err)
+			}
+		}()
+	}
+	// Let the callers pile up behind the first load
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := loads.Load(); n != 1 {
+		t.Errorf("Expected 1 load, got %d", n)
+	}
+
+	if _, err := c.Do("thumbnails/avatars/me_256.webp", func() (*Entry, error) {
+		t.Error("Expected cached entry, load was called")
+		return nil, nil
+	}); err != nil {
+		t.Fatalf("Do failed: %v", err)
+	}
+	if stats := c.Stats(); stats.Hits != 1 || stats.Misses != 20 {
+		t.Errorf("Expected 1 hit / 20 misses, got %+v", stats)
+	}
+}
+
+func TestMemory_DoDoesNotCacheErrorsOrInvalidatedLoads(t *testing.T) {
+	c := NewMemory(1<<20, nil)
+
+	loadErr := errors.New("storage unavailable")
+	if _, err := c.Do("k", func() (*Entry, error) { return nil, loadErr }); !errors.Is(err, loadErr) {
+		t.Errorf("Expected load error, got %v", err)
+	}
+	if _, ok := c.Peek("k"); ok {
+		t.Errorf("Expected failed load not to be cached")
+	}
+
+	// An asset deleted while its load is in flight must not be re-inserted
+	_, _ = c.Do("k", func() (*Entry, error) {
+		c.Delete("k")
+		return entryOf(1), nil
+	})
+	if _, ok := c.Peek("k"); ok {
+		t.Errorf("Expected load racing an invalidation not to be cached")
+	}
+}
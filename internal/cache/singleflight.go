@@ -0,0 +1,43 @@
+package cache
+
+import "sync"
+
+// call is an in-flight or completed load
+type call struct {
+	wg    sync.WaitGroup
+	entry *Entry
+	err   error
+}
+
+// group collapses concurrent loads of the same key into a single call
+type group struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// do runs fn once per key at a time; callers arriving while it runs wait for and share its result
+func (g *group) do(key string, fn func() (*Entry, error)) (*Entry, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.entry, c.err
+	}
+	c := &call{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		c.wg.Done()
+	}()
+
+	c.entry, c.err = fn()
+	return c.entry, c.err
+}
@@ -3,8 +3,12 @@ package config
 import (
 	"context"
 	"fmt"
-	"mediaflow/internal/s3"
+	"mediaflow/internal/auth"
+	"mediaflow/internal/objectkey"
+	"mediaflow/internal/storage"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 
 	"gopkg.in/yaml.v3"
@@ -19,8 +23,25 @@ type Config struct {
 	AWSAccessKey     string
 	AWSSecretKey     string
 	CacheMaxAge      string
+	// Storage backend ("s3" or "local")
+	StorageBackend     string
+	LocalStoragePath   string // Root directory for the local backend
+	LocalStorageURL    string // Public base URL for local presigned URLs
+	LocalStorageSecret string // HMAC secret for local presigned URLs
+	// Thumbnail cache tiers
+	MemoryCacheMB int64  // 0 disables the memory tier
+	DiskCacheDir  string // Empty disables the disk tier
+	DiskCacheMB   int64
+	// Post-upload processing
+	JobWorkers int
+	// HMAC key for signed /t/ transformation URLs (empty disables them)
+	TransformSecret string
 	// API authentication
-	APIKey string
+	APIKey            string // Single admin key; see APIKeysFile and the storage config's api_keys for scoped keys
+	APIKeysFile       string // YAML file of named, scoped API keys
+	UploadSigningKeys string // Upload token keys as kid:secret pairs, comma separated
+	// Storage config reloading (SIGHUP always reloads)
+	StorageConfigPollSeconds int64 // 0 disables polling
 }
 
 func Load() *Config {
@@ -32,8 +53,10 @@ func Load() *Config {
 		publicEndpoint = s3Endpoint
 	}
 
+	port := getEnv("PORT", "8080")
+
 	return &Config{
-		Port:             getEnv("PORT", "8080"),
+		Port:             port,
 		S3Endpoint:       s3Endpoint,
 		PublicS3Endpoint: publicEndpoint,
 		S3Bucket:         getEnv("S3_BUCKET", ""),
@@ -41,13 +64,36 @@ func Load() *Config {
 		AWSAccessKey:     getEnv("AWS_ACCESS_KEY_ID", ""),
 		AWSSecretKey:     getEnv("AWS_SECRET_ACCESS_KEY", ""),
 		CacheMaxAge:      getEnv("CACHE_MAX_AGE", "86400"),
+		// Storage backend
+		StorageBackend:     getEnv("STORAGE_BACKEND", storage.DriverS3),
+		LocalStoragePath:   getEnv("LOCAL_STORAGE_PATH", "data"),
+		LocalStorageURL:    getEnv("LOCAL_STORAGE_PUBLIC_URL", "http://localhost:"+port),
+		LocalStorageSecret: getEnv("LOCAL_STORAGE_SECRET", ""),
+		// Thumbnail cache tiers
+		MemoryCacheMB: getEnvInt64("MEMORY_CACHE_MB", 64),
+		DiskCacheDir:  getEnv("DISK_CACHE_DIR", ""),
+		DiskCacheMB:   getEnvInt64("DISK_CACHE_MB", 1024),
+		// Post-upload processing
+		JobWorkers: int(getEnvInt64("JOB_WORKERS", 2)),
+		// Signed transformation URLs
+		TransformSecret: getEnv("TRANSFORM_SECRET", ""),
 		// API authentication
-		APIKey: getEnv("API_KEY", ""),
+		APIKey:            getEnv("API_KEY", ""),
+		APIKeysFile:       getEnv("API_KEYS_FILE", ""),
+		UploadSigningKeys: getEnv("UPLOAD_SIGNING_KEYS", ""),
+		// Storage config reloading
+		StorageConfigPollSeconds: getEnvInt64("STORAGE_CONFIG_POLL_SECONDS", 0),
 	}
 }
 
 // Profile combines upload and processing configuration
 type Profile struct {
+	Name string `yaml:"-"` // Set by GetProfile
+
+	// Inheritance
+	Extends  string `yaml:"extends,omitempty"`  // Parent profile whose fields this one inherits and overrides
+	Abstract bool   `yaml:"abstract,omitempty"` // Only usable as a parent, not in requests
+
 	// Upload configuration
 	Kind                 string   `yaml:"kind"`
 	AllowedMimes         []string `yaml:"allowed_mimes"`
@@ -57,6 +103,14 @@ type Profile struct {
 	TokenTTLSeconds      int64    `yaml:"token_ttl_seconds"`
 	StoragePath          string   `yaml:"storage_path"`
 	EnableSharding       bool     `yaml:"enable_sharding"`
+	ShardHash            string   `yaml:"shard_hash,omitempty"`  // sha1 (default), sha256 or xxhash
+	ShardDepth           int      `yaml:"shard_depth,omitempty"` // Shard segments, as in ab/cd (default 1)
+	ShardWidth           int      `yaml:"shard_width,omitempty"` // Hex characters per segment (default 2)
+
+	// Multipart part batching
+	InitialBatchSize  int   `yaml:"initial_batch_size,omitempty"`   // Parts presigned in the initial response
+	MaxBatchSize      int   `yaml:"max_batch_size,omitempty"`       // Max parts per /presign/parts request
+	PartURLTTLSeconds int64 `yaml:"part_url_ttl_seconds,omitempty"` // Expiry for individual part URLs
 
 	// Processing configuration (shared)
 	ThumbFolder   string `yaml:"thumb_folder,omitempty"`
@@ -66,18 +120,127 @@ type Profile struct {
 	// Processing configuration (images)
 	Sizes       []string `yaml:"sizes,omitempty"`
 	DefaultSize string   `yaml:"default_size,omitempty"`
-	ConvertTo   string   `yaml:"convert_to,omitempty"`
+	ConvertTo   string   `yaml:"convert_to,omitempty"` // Output format, or "auto" to negotiate via Accept
+
+	// Named thumbnails with their own size, format and quality, requested with ?variant=
+	Variants map[string]Variant `yaml:"variants,omitempty"`
+
+	// Sizes with a height ("256x256"): how the image is fitted to the box
+	Fit        string `yaml:"fit,omitempty"`        // cover (default), contain, fill or inside
+	Gravity    string `yaml:"gravity,omitempty"`    // For cover: center (default), north, east, south, west or smart
+	Background string `yaml:"background,omitempty"` // For contain: hex color of the padding (default #ffffff)
+
+	// Metadata and privacy
+	StripMetadata    *bool `yaml:"strip_metadata,omitempty"`    // Drop EXIF, XMP and ICC from thumbnails (default true)
+	AutoOrient       *bool `yaml:"auto_orient,omitempty"`       // Rotate thumbnails upright by their EXIF orientation (default true)
+	SanitizeOriginal bool  `yaml:"sanitize_original,omitempty"` // Rewrite originals without GPS, serial number and XMP metadata
+
+	// On-the-fly resizing: widths outside `sizes` rendered from the original on first request
+	AllowedWidths []int `yaml:"allowed_widths,omitempty"` // Explicit allowlist
+	MinWidth      int   `yaml:"min_width,omitempty"`      // Range (used when max_width is set)
+	MaxWidth      int   `yaml:"max_width,omitempty"`
+
+	// Qualities besides `quality` that may be requested with ?quality=, each stored as its own thumbnail
+	AllowedQualities []int `yaml:"allowed_qualities,omitempty"`
+
+	// Access control
+	Visibility      string `yaml:"visibility,omitempty"`       // "public" (default) or "private": reads need a read token or an assets:read key
+	PrivateDelivery string `yaml:"private_delivery,omitempty"` // For private profiles: "proxy" (default) or "redirect" to a presigned GET
 
 	// Processing configuration (videos)
 	ProxyFolder string   `yaml:"proxy_folder,omitempty"`
 	Formats     []string `yaml:"formats,omitempty"`
 }
 
+// ConvertAuto selects the thumbnail format per request from the Accept header
+const ConvertAuto = "auto"
+
+// AutoFallbackFormat is served (and pre-generated) for convert_to: auto when the client supports nothing better
+const AutoFallbackFormat = "jpeg"
+
+// Profile visibility and private delivery modes
+const (
+	VisibilityPublic  = "public"
+	VisibilityPrivate = "private"
+
+	DeliveryProxy    = "proxy"
+	DeliveryRedirect = "redirect"
+)
+
+// IsPrivate reports whether reads from this profile need a read token or an assets:read key
+func (p *Profile) IsPrivate() bool {
+	return p.Visibility == VisibilityPrivate
+}
+
+// Sharding returns how shards are derived from key_base for this profile
+func (p *Profile) Sharding() objectkey.Sharding {
+	return objectkey.Sharding{Hash: p.ShardHash, Depth: p.ShardDepth, Width: p.ShardWidth}
+}
+
+// Shard returns the shard of keyBase, or "" when sharding is disabled
+func (p *Profile) Shard(keyBase string) string {
+	if !p.EnableSharding {
+		return ""
+	}
+	return p.Sharding().Shard(keyBase)
+}
+
+// OutputFormat returns the format thumbnails are pre-generated in, normalized with NormalizeFormat
+func (p *Profile) OutputFormat() string {
+	if p.ConvertTo == ConvertAuto {
+		return AutoFallbackFormat
+	}
+	return NormalizeFormat(p.ConvertTo)
+}
+
+// NormalizeFormat returns the one name of an output format that thumbnail keys and Content-Types
+// are built from: jpg is jpeg, and no format is the JPEG that thumbnails are generated in by default
+func NormalizeFormat(format string) string {
+	switch format {
+	case "", "jpg":
+		return "jpeg"
+	}
+	return format
+}
+
+// StripsMetadata reports whether thumbnails are written without metadata
+func (p *Profile) StripsMetadata() bool {
+	return p.StripMetadata == nil || *p.StripMetadata
+}
+
+// AutoOrients reports whether thumbnails are rotated upright by their EXIF orientation
+func (p *Profile) AutoOrients() bool {
+	return p.AutoOrient == nil || *p.AutoOrient
+}
+
 type StorageConfig struct {
+	Upload   UploadConfig       `yaml:"upload,omitempty"`
+	APIKeys  []auth.Key         `yaml:"api_keys,omitempty"` // Merged with API_KEYS_FILE and API_KEY
 	Profiles map[string]Profile `yaml:"profiles"`
 }
 
-func LoadStorageConfig(s3 *s3.Client, config *Config) (*StorageConfig, error) {
+// UploadPolicy defines upload constraints for different kinds and profiles
+type UploadPolicy struct {
+	Kind            string   `yaml:"kind"`
+	Profile         string   `yaml:"profile"`
+	AllowedMimes    []string `yaml:"allowed_mimes"`
+	SizeMaxBytes    int64    `yaml:"size_max_bytes"`
+	MultipartThresh int64    `yaml:"multipart_threshold_bytes"`
+}
+
+// UploadConfig contains upload-related configuration
+type UploadConfig struct {
+	MultipartThresholdMB int64          `yaml:"multipart_threshold_mb"`
+	PartSizeMB           int64          `yaml:"part_size_mb"`
+	TokenTTLSeconds      int64          `yaml:"token_ttl_seconds"` // Default and maximum lifetime of upload tokens
+	SigningAlgorithm     string         `yaml:"signing_alg"`       // Upload token algorithm: HS256 (default) or EdDSA
+	ActiveKeyID          string         `yaml:"active_kid"`        // Key in UPLOAD_SIGNING_KEYS used to sign new tokens
+	StoragePathRaw       string         `yaml:"storage_path_raw"`
+	EnableSharding       bool           `yaml:"enable_sharding"`
+	Policies             []UploadPolicy `yaml:"policies"`
+}
+
+func LoadStorageConfig(backend storage.Backend, config *Config) (*StorageConfig, error) {
 	configPath := getEnv("STORAGE_CONFIG_PATH", "examples/storage-config.yaml")
 
 	var data []byte
@@ -94,7 +257,7 @@ func LoadStorageConfig(s3 *s3.Client, config *Config) (*StorageConfig, error) {
 
 		key := strings.Join(strings.Split(s3Path, "/")[1:], "/")
 
-		data, err = s3.GetObject(context.Background(), key)
+		data, err = backend.GetObject(context.Background(), key)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get storage config from S3: %w", err)
 		}
@@ -106,12 +269,30 @@ func LoadStorageConfig(s3 *s3.Client, config *Config) (*StorageConfig, error) {
 		}
 	}
 
+	return ParseStorageConfig(data)
+}
+
+// ParseStorageConfig parses a storage config, resolves profile inheritance (extends) and validates the result
+func ParseStorageConfig(data []byte) (*StorageConfig, error) {
 	var storageConfig StorageConfig
 	if err := yaml.Unmarshal(data, &storageConfig); err != nil {
 		return nil, fmt.Errorf("failed to parse storage config: %w", err)
 	}
 
-	// Validate that all profiles have required storage_path field
+	// Merge inherited fields at the YAML level, so a child can override a parent's value with false or 0
+	var raw struct {
+		Profiles map[string]map[string]any `yaml:"profiles"`
+	}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse storage config: %w", err)
+	}
+	profiles, err := resolveProfiles(raw.Profiles)
+	if err != nil {
+		return nil, err
+	}
+	storageConfig.Profiles = profiles
+
+	// Reject the whole config if any profile is invalid
 	if err := validateStorageConfig(&storageConfig); err != nil {
 		return nil, err
 	}
@@ -119,28 +300,19 @@ func LoadStorageConfig(s3 *s3.Client, config *Config) (*StorageConfig, error) {
 	return &storageConfig, nil
 }
 
-// validateStorageConfig ensures all profiles have required fields
-func validateStorageConfig(config *StorageConfig) error {
-	for profileName, profile := range config.Profiles {
-		if profile.StoragePath == "" {
-			return fmt.Errorf("profile '%s' is missing required 'storage_path' field", profileName)
-		}
-	}
-	return nil
-}
-
-// GetProfile returns a profile by name
+// GetProfile returns a fully resolved profile by name. Abstract profiles only exist to be
+// extended and are not returned.
 func (sc *StorageConfig) GetProfile(profileName string) *Profile {
 	if profile, exists := sc.Profiles[profileName]; exists {
+		if profile.Abstract {
+			return nil
+		}
+		profile.Name = profileName
 		return &profile
 	}
 
-	// Return default profile if explicitly requested and exists
+	// Fallback to hardcoded default
 	if profileName == "default" {
-		if defaultProfile, exists := sc.Profiles["default"]; exists {
-			return &defaultProfile
-		}
-		// Fallback to hardcoded default
 		return DefaultProfile()
 	}
 
@@ -148,8 +320,35 @@ func (sc *StorageConfig) GetProfile(profileName string) *Profile {
 	return nil
 }
 
+// AllowsWidth reports whether a thumbnail of the given width may be served for this profile.
+// Pre-generated sizes are always allowed; other widths need allowed_widths or a min/max_width range.
+func (p *Profile) AllowsWidth(width int) bool {
+	w := strconv.Itoa(width)
+	for _, size := range p.Sizes {
+		if size == w {
+			return true
+		}
+	}
+	for _, allowed := range p.AllowedWidths {
+		if allowed == width {
+			return true
+		}
+	}
+	if p.MaxWidth > 0 {
+		return width >= p.MinWidth && width <= p.MaxWidth
+	}
+	return false
+}
+
+// AllowsQuality reports whether thumbnails of the given quality may be served for this profile.
+// The profile's own quality is always allowed; others need allowed_qualities.
+func (p *Profile) AllowsQuality(quality int) bool {
+	return quality == p.Quality || slices.Contains(p.AllowedQualities, quality)
+}
+
 func DefaultProfile() *Profile {
 	return &Profile{
+		Name:                 "default",
 		Kind:                 "image",
 		AllowedMimes:         []string{"image/jpeg", "image/png"},
 		SizeMaxBytes:         10485760, // 10MB
@@ -158,6 +357,9 @@ func DefaultProfile() *Profile {
 		TokenTTLSeconds:      900,
 		StoragePath:          "originals/{shard?}/{key_base}",
 		EnableSharding:       true,
+		InitialBatchSize:     10,
+		MaxBatchSize:         20,
+		PartURLTTLSeconds:    1800,
 		ThumbFolder:          "thumbnails",
 		Sizes:                []string{"256", "512", "1024"},
 		Quality:              90,
@@ -170,3 +372,17 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvInt64 reads an integer env var, falling back to defaultValue if it is unset or invalid
+func getEnvInt64(key string, defaultValue int64) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		fmt.Printf("⚠️ Invalid %s=%q, using %d\n", key, value, defaultValue)
+		return defaultValue
+	}
+	return n
+}
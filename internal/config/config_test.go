@@ -0,0 +1,37 @@
+package config
+
+import "testing"
+
+func TestProfile_AllowsQuality(t *testing.T) {
+	profile := Profile{Quality: 90, AllowedQualities: []int{60, 75}}
+	for quality, expected := range map[int]bool{90: true, 60: true, 75: true, 80: false, 100: false} {
+		if result := profile.AllowsQuality(quality); result != expected {
+			t.Errorf("AllowsQuality(%d) = %t, expected %t", quality, result, expected)
+		}
+	}
+}
+
+func TestProfile_AllowsWidth(t *testing.T) {
+	tests := []struct {
+		name     string
+		profile  Profile
+		width    int
+		expected bool
+	}{
+		{"Pre-generated size", Profile{Sizes: []string{"256", "512"}}, 256, true},
+		{"Unknown size without dynamic config", Profile{Sizes: []string{"256", "512"}}, 300, false},
+		{"Allowlisted width", Profile{Sizes: []string{"256"}, AllowedWidths: []int{300, 400}}, 300, true},
+		{"Width not in allowlist", Profile{AllowedWidths: []int{300, 400}}, 350, false},
+		{"Width inside range", Profile{MinWidth: 64, MaxWidth: 1024}, 700, true},
+		{"Width below range", Profile{MinWidth: 64, MaxWidth: 1024}, 32, false},
+		{"Width above range", Profile{MinWidth: 64, MaxWidth: 1024}, 2048, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tt.profile.AllowsWidth(tt.width); result != tt.expected {
+				t.Errorf("AllowsWidth(%d) = %t, expected %t", tt.width, result, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// resolveProfiles applies extends inheritance to the raw profile mappings and decodes the results.
+// A child's fields are deep-merged over its parent's: nested mappings merge key by key, anything
+// else (including lists) replaces the parent's value. abstract is never inherited.
+func resolveProfiles(raw map[string]map[string]any) (map[string]Profile, error) {
+	resolved := make(map[string]map[string]any, len(raw))
+
+	var resolve func(name string, chain []string) (map[string]any, error)
+	resolve = func(name string, chain []string) (map[string]any, error) {
+		if fields, ok := resolved[name]; ok {
+			return fields, nil
+		}
+		for i, seen := range chain {
+			if seen == name {
+				return nil, fmt.Errorf("profile inheritance cycle: %s", strings.Join(append(chain[i:], name), " -> "))
+			}
+		}
+		fields, ok := raw[name]
+		if !ok {
+			return nil, fmt.Errorf("profile '%s' extends unknown profile '%s'", chain[len(chain)-1], name)
+		}
+
+		merged := fields
+		if parentValue, ok := fields["extends"]; ok {
+			parentName, isString := parentValue.(string)
+			if !isString || parentName == "" {
+				return nil, fmt.Errorf("profile '%s': extends must be a profile name", name)
+			}
+			parent, err := resolve(parentName, append(chain, name))
+			if err != nil {
+				return nil, err
+			}
+			inherited := make(map[string]any, len(parent))
+			for key, value := range parent {
+				if key != "abstract" {
+					inherited[key] = value
+				}
+			}
+			merged = deepMerge(inherited, fields)
+		}
+		resolved[name] = merged
+		return merged, nil
+	}
+
+	names := make([]string, 0, len(raw))
+	for name := range raw {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	profiles := make(map[string]Profile, len(raw))
+	for _, name := range names {
+		fields, err := resolve(name, nil)
+		if err != nil {
+			return nil, err
+		}
+		data, err := yaml.Marshal(fields)
+		if err != nil {
+			return nil, fmt.Errorf("profile '%s': %w", name, err)
+		}
+		var profile Profile
+		if err := yaml.Unmarshal(data, &profile); err != nil {
+			return nil, fmt.Errorf("profile '%s': %w", name, err)
+		}
+		profiles[name] = profile
+	}
+	return profiles, nil
+}
+
+// deepMerge returns base with override applied. Neither map is modified.
+func deepMerge(base, override map[string]any) map[string]any {
+	merged := make(map[string]any, len(base)+len(override))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range override {
+		baseMap, baseIsMap := merged[key].(map[string]any)
+		overrideMap, overrideIsMap := value.(map[string]any)
+		if baseIsMap && overrideIsMap {
+			merged[key] = deepMerge(baseMap, overrideMap)
+			continue
+		}
+		merged[key] = value
+	}
+	return merged
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const inheritanceConfig = `
+profiles:
+  base_image:
+    abstract: true
+    kind: image
+    allowed_mimes: ["image/jpeg", "image/png"]
+    size_max_bytes: 5242880
+    multipart_threshold_mb: 15
+    part_size_mb: 8
+    token_ttl_seconds: 900
+    enable_sharding: true
+    quality: 90
+    convert_to: webp
+
+  avatar:
+    extends: base_image
+    storage_path: "originals/avatars/{shard?}/{key_base}"
+    thumb_folder: thumbnails/avatars
+    sizes: ["128", "256"]
+    default_size: "256"
+
+  photo:
+    extends: avatar
+    storage_path: "originals/photos/{shard?}/{key_base}"
+    allowed_mimes: ["image/jpeg"]
+    enable_sharding: false
+    sizes: ["512"]
+    default_size: "512"
+`
+
+func TestParseStorageConfig_Extends(t *testing.T) {
+	sc, err := ParseStorageConfig([]byte(inheritanceConfig))
+	if err != nil {
+		t.Fatalf("ParseStorageConfig failed: %v", err)
+	}
+
+	avatar := sc.GetProfile("avatar")
+	if avatar == nil {
+		t.Fatal("Expected the avatar profile")
+	}
+	if avatar.Kind != "image" || avatar.PartSizeMB != 8 || avatar.Quality != 90 || avatar.ConvertTo != "webp" || !avatar.EnableSharding {
+		t.Errorf("Expected avatar to inherit base_image's fields, got %+v", avatar)
+	}
+	if avatar.Abstract {
+		t.Error("abstract must not be inherited")
+	}
+
+	// Two levels, with overrides to false and to a shorter list
+	photo := sc.GetProfile("photo")
+	if photo == nil {
+		t.Fatal("Expected the photo profile")
+	}
+	if photo.EnableSharding {
+		t.Error("Expected enable_sharding: false to override the inherited true")
+	}
+	if !reflect.DeepEqual(photo.AllowedMimes, []string{"image/jpeg"}) || !reflect.DeepEqual(photo.Sizes, []string{"512"}) {
+		t.Errorf("Expected lists to be replaced, got %v and %v", photo.AllowedMimes, photo.Sizes)
+	}
+	if photo.ThumbFolder != "thumbnails/avatars" || photo.SizeMaxBytes != 5242880 {
+		t.Errorf("Expected photo to inherit through avatar, got %+v", photo)
+	}
+
+	// Abstract profiles can't be used in requests
+	if sc.GetProfile("base_image") != nil {
+		t.Error("Expected GetProfile to hide the abstract profile")
+	}
+}
+
+func TestParseStorageConfig_ExtendsErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		expected string
+	}{
+		{"Cycle", "profiles:\n  a:\n    extends: b\n  b:\n    extends: c\n  c:\n    extends: a\n", "profile inheritance cycle: a -> b -> c -> a"},
+		{"Self reference", "profiles:\n  a:\n    extends: a\n", "profile inheritance cycle: a -> a"},
+		{"Unknown parent", "profiles:\n  a:\n    extends: missing\n", "profile 'a' extends unknown profile 'missing'"},
+		{"Non-string extends", "profiles:\n  a:\n    extends: 5\n", "extends must be a profile name"},
+		{"Abstract parent doesn't make the child valid", "profiles:\n  base:\n    abstract: true\n    kind: image\n  a:\n    extends: base\n    storage_path: x/{key_base}\n", "profile 'a': part_size_mb 0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseStorageConfig([]byte(tt.config))
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected an error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestDeepMerge(t *testing.T) {
+	base := map[string]any{
+		"quality": 90,
+		"nested":  map[string]any{"w": 100, "h": 100},
+		"list":    []any{"a", "b"},
+	}
+	override := map[string]any{
+		"nested": map[string]any{"h": 50},
+		"list":   []any{"c"},
+	}
+
+	merged := deepMerge(base, override)
+	expected := map[string]any{
+		"quality": 90,
+		"nested":  map[string]any{"w": 100, "h": 50},
+		"list":    []any{"c"},
+	}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Errorf("deepMerge = %v, expected %v", merged, expected)
+	}
+	if base["nested"].(map[string]any)["h"] != 100 {
+		t.Error("deepMerge modified its base")
+	}
+}
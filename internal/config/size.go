@@ -0,0 +1,190 @@
+package config
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Fit modes for sizes with both a width and a height
+const (
+	FitCover   = "cover"   // Fill the box, cropping the overflow at the gravity (default)
+	FitContain = "contain" // Fit inside the box, padded to it with the background color
+	FitFill    = "fill"    // Stretch to the box, ignoring the aspect ratio
+	FitInside  = "inside"  // Fit inside the box without padding, so one side may be shorter
+)
+
+// Crop gravities for fit: cover
+const (
+	GravityCenter = "center" // Default
+	GravityNorth  = "north"
+	GravityEast   = "east"
+	GravitySouth  = "south"
+	GravityWest   = "west"
+	GravitySmart  = "smart" // libvips attention-based crop; "attention" is an alias
+)
+
+// DefaultBackground pads fit: contain thumbnails when the profile sets no background
+const DefaultBackground = "ffffff"
+
+var fitModes = map[string]bool{FitCover: true, FitContain: true, FitFill: true, FitInside: true}
+
+var gravities = map[string]string{
+	GravityCenter: GravityCenter, "centre": GravityCenter,
+	GravityNorth: GravityNorth, GravityEast: GravityEast, GravitySouth: GravitySouth, GravityWest: GravityWest,
+	GravitySmart: GravitySmart, "attention": GravitySmart,
+}
+
+// SizeSpec is a thumbnail size with the profile's fit settings applied. Height is 0 for sizes
+// that only set a width, which keep the original's aspect ratio.
+type SizeSpec struct {
+	Width      int
+	Height     int
+	Fit        string
+	Gravity    string
+	Background string // Hex RGB without '#'
+}
+
+// ParseSize parses a size as written in sizes: a width ("256") or width x height ("256x256")
+func ParseSize(size string) (width, height int, err error) {
+	w, h, hasHeight := strings.Cut(size, "x")
+	width, err = strconv.Atoi(w)
+	if err != nil || width <= 0 {
+		return 0, 0, fmt.Errorf("size %q must be a positive width or WIDTHxHEIGHT", size)
+	}
+	if hasHeight {
+		height, err = strconv.Atoi(h)
+		if err != nil || height <= 0 {
+			return 0, 0, fmt.Errorf("size %q must be a positive width or WIDTHxHEIGHT", size)
+		}
+	}
+	return width, height, nil
+}
+
+// Variant is a named thumbnail with its own size, fit and output settings. Unset fields fall back
+// to the profile's.
+type Variant struct {
+	Width      int    `yaml:"w"`
+	Height     int    `yaml:"h,omitempty"`
+	Fit        string `yaml:"fit,omitempty"`
+	Gravity    string `yaml:"gravity,omitempty"`
+	Background string `yaml:"background,omitempty"`
+	Format     string `yaml:"format,omitempty"`
+	Quality    int    `yaml:"quality,omitempty"`
+}
+
+// SizeSpec returns the full spec of size for this profile
+func (p *Profile) SizeSpec(size string) (SizeSpec, error) {
+	width, height, err := ParseSize(size)
+	if err != nil {
+		return SizeSpec{}, err
+	}
+	return newSizeSpec(width, height, p.Fit, p.Gravity, p.Background), nil
+}
+
+// VariantSpec returns the size spec of a variant, with the profile's fit settings for those it doesn't set
+func (p *Profile) VariantSpec(v Variant) SizeSpec {
+	return newSizeSpec(v.Width, v.Height, cmp.Or(v.Fit, p.Fit), cmp.Or(v.Gravity, p.Gravity), cmp.Or(v.Background, p.Background))
+}
+
+// VariantFormat returns the output format of a variant, normalized with NormalizeFormat
+func (p *Profile) VariantFormat(v Variant) string {
+	if v.Format == "" {
+		return p.OutputFormat()
+	}
+	return NormalizeFormat(v.Format)
+}
+
+func newSizeSpec(width, height int, fit, gravity, background string) SizeSpec {
+	spec := SizeSpec{Width: width, Height: height}
+	if height == 0 {
+		return spec
+	}
+
+	spec.Fit = cmp.Or(fit, FitCover)
+	switch spec.Fit {
+	case FitCover:
+		spec.Gravity = cmp.Or(gravities[gravity], GravityCenter)
+	case FitContain:
+		spec.Background = cmp.Or(normalizeColor(background), DefaultBackground)
+	}
+	return spec
+}
+
+// Name returns the spec as it appears in thumbnail keys. Width-only sizes keep their plain width;
+// others carry everything that changes the rendering, as in "256x256_cover_center" or
+// "1200x630_contain_ffffff", so changing fit settings never serves a stale thumbnail.
+func (s SizeSpec) Name() string {
+	if s.Height == 0 {
+		return strconv.Itoa(s.Width)
+	}
+	name := fmt.Sprintf("%dx%d_%s", s.Width, s.Height, s.Fit)
+	if s.Gravity != "" {
+		name += "_" + s.Gravity
+	}
+	if s.Background != "" {
+		name += "_" + s.Background
+	}
+	return name
+}
+
+// specNamePattern matches what Name returns
+var specNamePattern = `[0-9]+(?:x[0-9]+_(?:cover_(?:center|north|east|south|west|smart)|contain_[0-9a-f]{6}|fill|inside))?`
+
+// ThumbnailMatcher returns a func reporting whether a key is one of keyBase's thumbnails in the
+// profile's thumb_folder: a size or variant in any quality and format, or a transform. Thumbnails
+// of key_bases that merely start with keyBase and "_", such as abc_1 for abc, don't match.
+func (p *Profile) ThumbnailMatcher(keyBase string) func(key string) bool {
+	variants := make([]string, 0, len(p.Variants))
+	for name := range p.Variants {
+		variants = append(variants, regexp.QuoteMeta(name)+"_")
+	}
+	// {key_base}_[{variant}_]{spec}[_q{quality}].{format}, or {key_base}_t_w{width}_q{quality}.{format}
+	rendition := "(?:" + strings.Join(variants, "|") + ")?" + specNamePattern + `(?:_q[0-9]+)?`
+	re := regexp.MustCompile("^" + regexp.QuoteMeta(p.ThumbFolder+"/"+keyBase+"_") + "(?:" + rendition + `|t_w[0-9]+_q[0-9]+)\.[a-z0-9]+$`)
+	return re.MatchString
+}
+
+// Color returns the background as RGB
+func (s SizeSpec) Color() (r, g, b uint8) {
+	rgb, _ := strconv.ParseUint(s.Background, 16, 32)
+	return uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb)
+}
+
+// HasSize reports whether size is one of the profile's sizes. Sizes are compared by dimensions,
+// so "256x0256" matches "256x256".
+func (p *Profile) HasSize(size string) bool {
+	width, height, err := ParseSize(size)
+	if err != nil {
+		return false
+	}
+	for _, s := range p.Sizes {
+		if w, h, err := ParseSize(s); err == nil && w == width && h == height {
+			return true
+		}
+	}
+	return false
+}
+
+// variantNamePattern keeps variant names apart from sizes, which start with a digit
+var variantNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+
+// IsVariant reports whether name is one of the profile's variants
+func (p *Profile) IsVariant(name string) bool {
+	_, ok := p.Variants[name]
+	return ok
+}
+
+// normalizeColor returns a "#rrggbb" or "rrggbb" color as lowercase "rrggbb", or "" if it isn't one
+func normalizeColor(color string) string {
+	color = strings.ToLower(strings.TrimPrefix(color, "#"))
+	if len(color) != 6 {
+		return ""
+	}
+	if _, err := strconv.ParseUint(color, 16, 32); err != nil {
+		return ""
+	}
+	return color
+}
@@ -0,0 +1,128 @@
+package config
+
+import "testing"
+
+func TestProfile_SizeSpec(t *testing.T) {
+	tests := []struct {
+		name     string
+		profile  Profile
+		size     string
+		expected string // Name of the spec; empty when the size is invalid
+	}{
+		{"Width only", Profile{Fit: FitContain}, "256", "256"},
+		{"Default cover", Profile{}, "256x256", "256x256_cover_center"},
+		{"Smart gravity", Profile{Gravity: "attention"}, "256x256", "256x256_cover_smart"},
+		{"Contain with default background", Profile{Fit: FitContain}, "1200x630", "1200x630_contain_ffffff"},
+		{"Contain with background", Profile{Fit: FitContain, Background: "#1A2B3C", Gravity: GravityNorth}, "1200x630", "1200x630_contain_1a2b3c"},
+		{"Fill", Profile{Fit: FitFill, Gravity: GravityNorth}, "300x100", "300x100_fill"},
+		{"Inside", Profile{Fit: FitInside}, "300x100", "300x100_inside"},
+		{"Missing height", Profile{}, "256x", ""},
+		{"Not a size", Profile{}, "large", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := tt.profile.SizeSpec(tt.size)
+			if tt.expected == "" {
+				if err == nil {
+					t.Errorf("Expected an error for %q, got %+v", tt.size, spec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SizeSpec(%q) failed: %v", tt.size, err)
+			}
+			if spec.Name() != tt.expected {
+				t.Errorf("SizeSpec(%q).Name() = %q, expected %q", tt.size, spec.Name(), tt.expected)
+			}
+		})
+	}
+}
+
+func TestSizeSpec_Color(t *testing.T) {
+	spec, err := (&Profile{Fit: FitContain, Background: "#1a2b3c"}).SizeSpec("10x10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, g, b := spec.Color(); r != 0x1a || g != 0x2b || b != 0x3c {
+		t.Errorf("Color() = %d, %d, %d; expected 26, 43, 60", r, g, b)
+	}
+}
+
+func TestProfile_HasSize(t *testing.T) {
+	profile := &Profile{Sizes: []string{"256", "1200x630"}}
+	tests := map[string]bool{
+		"256":       true,
+		"1200x630":  true,
+		"1200x0630": true,
+		"256x256":   false,
+		"1200":      false,
+		"abc":       false,
+	}
+	for size, expected := range tests {
+		if got := profile.HasSize(size); got != expected {
+			t.Errorf("HasSize(%q) = %t, expected %t", size, got, expected)
+		}
+	}
+}
+
+func TestProfile_VariantSpec(t *testing.T) {
+	profile := &Profile{Fit: FitContain, Background: "#000000", ConvertTo: "webp"}
+
+	card := Variant{Width: 600, Height: 400, Fit: FitCover, Gravity: GravityNorth, Format: "jpeg"}
+	if name := profile.VariantSpec(card).Name(); name != "600x400_cover_north" {
+		t.Errorf("VariantSpec(card).Name() = %q, expected 600x400_cover_north", name)
+	}
+	if format := profile.VariantFormat(card); format != "jpeg" {
+		t.Errorf("VariantFormat(card) = %q, expected jpeg", format)
+	}
+
+	// Unset fields fall back to the profile's
+	banner := Variant{Width: 1200, Height: 630}
+	if name := profile.VariantSpec(banner).Name(); name != "1200x630_contain_000000" {
+		t.Errorf("VariantSpec(banner).Name() = %q, expected 1200x630_contain_000000", name)
+	}
+	if format := profile.VariantFormat(banner); format != "webp" {
+		t.Errorf("VariantFormat(banner) = %q, expected webp", format)
+	}
+
+	// Formats are normalized, so keys and Content-Types never end in "" or jpg
+	tests := []struct {
+		convertTo, variantFormat, expected string
+	}{
+		{"", "", "jpeg"},
+		{"jpg", "", "jpeg"},
+		{"auto", "", "jpeg"},
+		{"webp", "jpg", "jpeg"},
+		{"", "png", "png"},
+	}
+	for _, tt := range tests {
+		p := &Profile{ConvertTo: tt.convertTo}
+		if format := p.VariantFormat(Variant{Width: 100, Format: tt.variantFormat}); format != tt.expected {
+			t.Errorf("VariantFormat with convert_to %q and format %q = %q, expected %s", tt.convertTo, tt.variantFormat, format, tt.expected)
+		}
+	}
+}
+
+func TestProfile_ThumbnailMatcher(t *testing.T) {
+	profile := &Profile{ThumbFolder: "thumbs", Variants: map[string]Variant{"card": {Width: 600, Height: 400}}}
+	isThumbnail := profile.ThumbnailMatcher("abc")
+	tests := map[string]bool{
+		"thumbs/abc_256.webp":                     true,
+		"thumbs/abc_256_q60.jpeg":                 true,
+		"thumbs/abc_256x256_cover_center.webp":    true,
+		"thumbs/abc_1200x630_contain_ffffff.png":  true,
+		"thumbs/abc_card_600x400_cover_north.jpg": true,
+		"thumbs/abc_t_w512_q80.webp":              true,
+		"thumbs/abc_1_256.webp":                   false,
+		"thumbs/abc_def_256.webp":                 false,
+		"thumbs/abcd_256.webp":                    false,
+		"thumbs/abc_256x256_cover_128.webp":       false,
+		"other/abc_256.webp":                      false,
+	}
+	for key, expected := range tests {
+		if got := isThumbnail(key); got != expected {
+			t.Errorf("ThumbnailMatcher(abc)(%q) = %t, expected %t", key, got, expected)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Store holds the current storage config and swaps in new versions atomically.
+// Callers take a snapshot with Current for each request, so a reload never changes
+// the config under a request that is already running.
+type Store struct {
+	current atomic.Pointer[StorageConfig]
+	load    func() (*StorageConfig, error)
+	mu      sync.Mutex // Serializes reloads
+}
+
+// NewStore creates a store serving initial. load re-reads and validates the config for Reload;
+// it may be nil for a static config.
+func NewStore(initial *StorageConfig, load func() (*StorageConfig, error)) *Store {
+	s := &Store{load: load}
+	s.current.Store(initial)
+	return s
+}
+
+// Current returns the current config snapshot. It must not be modified.
+func (s *Store) Current() *StorageConfig {
+	return s.current.Load()
+}
+
+// Reload re-reads the config and swaps it in if it changed. Configs changing api_keys or the upload
+// signing settings are rejected. On error the current config stays in place. Returns whether a new
+// config was swapped in.
+func (s *Store) Reload() (bool, error) {
+	if s.load == nil {
+		return false, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next, err := s.load()
+	if err != nil {
+		return false, err
+	}
+	prev := s.current.Load()
+	if reflect.DeepEqual(prev, next) {
+		return false, nil
+	}
+	// These are read once at startup, so swapping in a config that changes them would leave
+	// the running keyring and token issuer disagreeing with what Current reports
+	if !reflect.DeepEqual(prev.APIKeys, next.APIKeys) {
+		return false, fmt.Errorf("api_keys changed, restart to apply them")
+	}
+	if prev.Upload.SigningAlgorithm != next.Upload.SigningAlgorithm || prev.Upload.ActiveKeyID != next.Upload.ActiveKeyID {
+		return false, fmt.Errorf("upload signing settings changed, restart to apply them")
+	}
+	s.current.Store(next)
+	return true, nil
+}
+
+// Watch reloads the config on every signal received and, if interval is positive, every interval,
+// until ctx is done. Failed reloads are logged and the current config is kept.
+func (s *Store) Watch(ctx context.Context, signals <-chan os.Signal, interval time.Duration) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+		case <-tick:
+		}
+
+		changed, err := s.Reload()
+		if err != nil {
+			fmt.Printf("🚨 Failed to reload storage config, keeping the current one: %v\n", err)
+			continue
+		}
+		if changed {
+			fmt.Printf("🔄 Reloaded storage config (%d profiles)\n", len(s.Current().Profiles))
+		}
+	}
+}
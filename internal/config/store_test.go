@@ -0,0 +1,132 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"mediaflow/internal/auth"
+)
+
+func TestStore_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage-config.yaml")
+	t.Setenv("STORAGE_CONFIG_PATH", path)
+	writeConfig := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	load := func() (*StorageConfig, error) { return LoadStorageConfig(nil, &Config{}) }
+
+	avatar := "  avatar:\n    kind: image\n    part_size_mb: 8\n    storage_path: \"originals/avatars/{key_base}\"\n"
+	photo := "  photo:\n    kind: image\n    part_size_mb: 8\n    storage_path: \"originals/photos/{key_base}\"\n"
+
+	writeConfig("profiles:\n" + avatar)
+	initial, err := load()
+	if err != nil {
+		t.Fatalf("LoadStorageConfig failed: %v", err)
+	}
+	store := NewStore(initial, load)
+	snapshot := store.Current()
+
+	// Unchanged file
+	if changed, err := store.Reload(); err != nil || changed {
+		t.Errorf("Reload of an unchanged config = %v, %v; expected false, nil", changed, err)
+	}
+
+	// New profile
+	writeConfig("profiles:\n" + avatar + photo)
+	if changed, err := store.Reload(); err != nil || !changed {
+		t.Fatalf("Reload = %v, %v; expected true, nil", changed, err)
+	}
+	if store.Current().GetProfile("photo") == nil {
+		t.Error("Expected the new profile after reload")
+	}
+	// A snapshot taken before the reload is unaffected
+	if snapshot.GetProfile("photo") != nil {
+		t.Error("Expected the old snapshot to keep the old profiles")
+	}
+
+	// Invalid configs are rejected and the current one kept
+	for name, content := range map[string]string{
+		"Malformed YAML":       "profiles: [",
+		"Missing storage_path": "profiles:\n  avatar:\n    kind: image\n    part_size_mb: 8\n",
+	} {
+		writeConfig(content)
+		if _, err := store.Reload(); err == nil {
+			t.Errorf("%s: expected a reload error", name)
+		}
+		if store.Current().GetProfile("photo") == nil {
+			t.Errorf("%s: expected the previous config to be kept", name)
+		}
+	}
+}
+
+func TestStore_ReloadRejectsStartupSettings(t *testing.T) {
+	initial := &StorageConfig{
+		Upload:   UploadConfig{SigningAlgorithm: "HS256", ActiveKeyID: "k1"},
+		Profiles: map[string]Profile{"avatar": {StoragePath: "a/{key_base}"}},
+	}
+	tests := []struct {
+		name   string
+		modify func(c *StorageConfig)
+	}{
+		{"Signing algorithm", func(c *StorageConfig) { c.Upload.SigningAlgorithm = "EdDSA" }},
+		{"Active key", func(c *StorageConfig) { c.Upload.ActiveKeyID = "k2" }},
+		{"API keys", func(c *StorageConfig) { c.APIKeys = []auth.Key{{ID: "ci", Scopes: []string{auth.ScopeAdmin}}} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := *initial
+			next.Profiles = map[string]Profile{"avatar": {StoragePath: "b/{key_base}"}}
+			tt.modify(&next)
+			store := NewStore(initial, func() (*StorageConfig, error) { return &next, nil })
+			if changed, err := store.Reload(); err == nil || changed {
+				t.Errorf("Reload = %v, %v; expected the change to be rejected", changed, err)
+			}
+			if store.Current() != initial {
+				t.Error("Expected the current config to be kept")
+			}
+		})
+	}
+}
+
+func TestStore_WatchReloadsOnSignal(t *testing.T) {
+	versions := []*StorageConfig{
+		{Profiles: map[string]Profile{"avatar": {StoragePath: "a/{key_base}"}}},
+		{Profiles: map[string]Profile{"avatar": {StoragePath: "b/{key_base}"}}},
+	}
+	loads := 0
+	store := NewStore(versions[0], func() (*StorageConfig, error) {
+		loads++
+		return versions[1], nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal)
+	done := make(chan struct{})
+	go func() {
+		store.Watch(ctx, signals, 0)
+		close(done)
+	}()
+
+	signals <- syscall.SIGHUP
+	// The unbuffered send returns once Watch received it; wait for the reload to land
+	deadline := time.Now().Add(time.Second)
+	for store.Current() != versions[1] && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if store.Current() != versions[1] {
+		t.Error("Expected SIGHUP to reload the config")
+	}
+
+	cancel()
+	<-done
+	if loads != 1 {
+		t.Errorf("Expected 1 load, got %d", loads)
+	}
+}
@@ -0,0 +1,171 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+
+	"mediaflow/internal/objectkey"
+	"mediaflow/internal/sanitize"
+)
+
+// minPartSizeMB and maxPartSizeMB are S3's multipart part size limits
+const (
+	minPartSizeMB = 5
+	maxPartSizeMB = 5 * 1024
+)
+
+// convertFormats are the accepted convert_to values
+var convertFormats = map[string]bool{"webp": true, "jpeg": true, "jpg": true, "png": true, "avif": true, "jxl": true, ConvertAuto: true}
+
+// ValidationError lists every problem found in a storage config
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid storage config (%d problems):\n  - %s", len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+// validateStorageConfig checks every profile and returns a *ValidationError listing all problems,
+// so a broken config can be fixed in one pass instead of failing on the first request that hits it
+func validateStorageConfig(config *StorageConfig) error {
+	names := make([]string, 0, len(config.Profiles))
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []string
+	for _, name := range names {
+		profile := config.Profiles[name]
+		// Abstract profiles may be partial; what they provide is checked in their children
+		if profile.Abstract {
+			continue
+		}
+		for _, problem := range validateProfile(&profile) {
+			problems = append(problems, fmt.Sprintf("profile '%s': %s", name, problem))
+		}
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// validateProfile returns the problems with a single profile
+func validateProfile(p *Profile) []string {
+	var problems []string
+
+	if p.Kind != "image" && p.Kind != "video" {
+		problems = append(problems, fmt.Sprintf("kind %q must be image or video", p.Kind))
+	}
+	if p.StoragePath == "" {
+		problems = append(problems, "missing required 'storage_path' field")
+	}
+	problems = append(problems, p.Sharding().Validate()...)
+	for _, problem := range objectkey.Validate(p.StoragePath) {
+		problems = append(problems, "storage_path: "+problem)
+	}
+	problems = append(problems, validateFolder("thumb_folder", p.ThumbFolder)...)
+	problems = append(problems, validateFolder("proxy_folder", p.ProxyFolder)...)
+
+	if p.PartSizeMB < minPartSizeMB || p.PartSizeMB > maxPartSizeMB {
+		problems = append(problems, fmt.Sprintf("part_size_mb %d must be between %d and %d (S3 part size limits)", p.PartSizeMB, minPartSizeMB, maxPartSizeMB))
+	}
+	if p.MultipartThresholdMB < 0 {
+		problems = append(problems, fmt.Sprintf("multipart_threshold_mb %d must not be negative", p.MultipartThresholdMB))
+	}
+	// 0 leaves the quality to the encoder
+	if p.Quality < 0 || p.Quality > 100 {
+		problems = append(problems, fmt.Sprintf("quality %d must be between 1 and 100", p.Quality))
+	}
+	for i, quality := range p.AllowedQualities {
+		if quality < 1 || quality > 100 {
+			problems = append(problems, fmt.Sprintf("allowed_qualities[%d] %d must be between 1 and 100", i, quality))
+		}
+	}
+
+	sizes := make(map[string]bool, len(p.Sizes))
+	for i, size := range p.Sizes {
+		if _, _, err := ParseSize(size); err != nil {
+			problems = append(problems, fmt.Sprintf("sizes[%d] %q must be a positive width or WIDTHxHEIGHT", i, size))
+		}
+		sizes[size] = true
+	}
+	if p.DefaultSize != "" && !sizes[p.DefaultSize] && !p.IsVariant(p.DefaultSize) {
+		problems = append(problems, fmt.Sprintf("default_size %q must be one of sizes %v or a variant", p.DefaultSize, p.Sizes))
+	}
+	problems = append(problems, validateFit(p.Fit, p.Gravity, p.Background)...)
+	if p.SanitizeOriginal && p.Kind != "image" {
+		problems = append(problems, fmt.Sprintf("sanitize_original only applies to kind image, not %q", p.Kind))
+	}
+	if p.SanitizeOriginal && p.Kind == "image" {
+		// Other formats would be stored with their metadata
+		for _, mime := range p.AllowedMimes {
+			if !slices.Contains(sanitize.Formats, mime) {
+				problems = append(problems, fmt.Sprintf("sanitize_original can't rewrite %q in allowed_mimes, only %s", mime, strings.Join(sanitize.Formats, " and ")))
+			}
+		}
+	}
+	if p.ConvertTo != "" && !convertFormats[p.ConvertTo] {
+		problems = append(problems, fmt.Sprintf("convert_to %q must be one of webp, jpeg, png, avif, jxl or auto", p.ConvertTo))
+	}
+	for _, name := range slices.Sorted(maps.Keys(p.Variants)) {
+		for _, problem := range validateVariant(name, p.Variants[name]) {
+			problems = append(problems, fmt.Sprintf("variants.%s: %s", name, problem))
+		}
+	}
+
+	if p.Visibility != "" && p.Visibility != VisibilityPublic && p.Visibility != VisibilityPrivate {
+		problems = append(problems, fmt.Sprintf("visibility %q must be public or private", p.Visibility))
+	}
+	if p.PrivateDelivery != "" && p.PrivateDelivery != DeliveryProxy && p.PrivateDelivery != DeliveryRedirect {
+		problems = append(problems, fmt.Sprintf("private_delivery %q must be proxy or redirect", p.PrivateDelivery))
+	}
+	return problems
+}
+
+// validateFit returns the problems with the settings that fit images to WIDTHxHEIGHT boxes
+func validateFit(fit, gravity, background string) []string {
+	var problems []string
+	if fit != "" && !fitModes[fit] {
+		problems = append(problems, fmt.Sprintf("fit %q must be cover, contain, fill or inside", fit))
+	}
+	if _, ok := gravities[gravity]; gravity != "" && !ok {
+		problems = append(problems, fmt.Sprintf("gravity %q must be center, north, east, south, west or smart", gravity))
+	}
+	if background != "" && normalizeColor(background) == "" {
+		problems = append(problems, fmt.Sprintf("background %q must be a hex color like #ffffff", background))
+	}
+	return problems
+}
+
+// validateVariant returns the problems with a named variant
+func validateVariant(name string, v Variant) []string {
+	var problems []string
+	if !variantNamePattern.MatchString(name) {
+		problems = append(problems, "name must start with a letter and contain only letters, digits, '_' and '-'")
+	}
+	if v.Width <= 0 || v.Height < 0 {
+		problems = append(problems, fmt.Sprintf("w %d and h %d must be positive (h may be left out)", v.Width, v.Height))
+	}
+	problems = append(problems, validateFit(v.Fit, v.Gravity, v.Background)...)
+	if v.Format != "" && (v.Format == ConvertAuto || !convertFormats[v.Format]) {
+		problems = append(problems, fmt.Sprintf("format %q must be one of webp, jpeg, png, avif or jxl", v.Format))
+	}
+	if v.Quality < 0 || v.Quality > 100 {
+		problems = append(problems, fmt.Sprintf("quality %d must be between 1 and 100", v.Quality))
+	}
+	return problems
+}
+
+// validateFolder reports placeholders in a folder, which is used as-is
+func validateFolder(field, folder string) []string {
+	if strings.ContainsAny(folder, "{}") {
+		return []string{fmt.Sprintf("%s %q can't contain placeholders", field, folder)}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func validProfile() Profile {
+	return Profile{
+		Kind:        "image",
+		PartSizeMB:  8,
+		StoragePath: "originals/{shard?}/{key_base}.{ext}",
+		ThumbFolder: "thumbnails",
+		Sizes:       []string{"256", "512"},
+		DefaultSize: "256",
+		Quality:     90,
+		ConvertTo:   "webp",
+	}
+}
+
+func TestValidateProfile(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(p *Profile)
+		expected string // Substring of the single expected problem; empty for a valid profile
+	}{
+		{"Valid", func(p *Profile) {}, ""},
+		{"Video without sizes", func(p *Profile) {
+			*p = Profile{Kind: "video", PartSizeMB: 8, StoragePath: "videos/{key_base}", Quality: 80}
+		}, ""},
+		{"Unset quality", func(p *Profile) { p.Quality = 0 }, ""},
+		{"Auto format", func(p *Profile) { p.ConvertTo = ConvertAuto }, ""},
+		{"Non-numeric size", func(p *Profile) { p.Sizes = []string{"256", "25b"} }, `sizes[1] "25b" must be a positive width or WIDTHxHEIGHT`},
+		{"Zero size", func(p *Profile) { p.Sizes = []string{"0"}; p.DefaultSize = "" }, `sizes[0] "0" must be a positive width or WIDTHxHEIGHT`},
+		{"Box sizes", func(p *Profile) { p.Sizes = []string{"256x256", "1200x630"}; p.DefaultSize = "256x256" }, ""},
+		{"Zero height", func(p *Profile) { p.Sizes = []string{"256x0"}; p.DefaultSize = "" }, `sizes[0] "256x0"`},
+		{"Fit settings", func(p *Profile) { p.Fit = FitContain; p.Gravity = "attention"; p.Background = "#1A2B3C" }, ""},
+		{"Unknown fit", func(p *Profile) { p.Fit = "crop" }, `fit "crop" must be cover, contain, fill or inside`},
+		{"Unknown gravity", func(p *Profile) { p.Gravity = "top" }, `gravity "top"`},
+		{"Invalid background", func(p *Profile) { p.Background = "white" }, `background "white" must be a hex color`},
+		{"Default size not in sizes", func(p *Profile) { p.DefaultSize = "1024" }, `default_size "1024" must be one of sizes`},
+		{"Metadata settings", func(p *Profile) {
+			keep := false
+			p.StripMetadata = &keep
+			p.AutoOrient = &keep
+			p.SanitizeOriginal = true
+		}, ""},
+		{"Sanitized WebP", func(p *Profile) {
+			p.SanitizeOriginal = true
+			p.AllowedMimes = []string{"image/jpeg", "image/webp"}
+		}, `sanitize_original can't rewrite "image/webp" in allowed_mimes`},
+		{"Sanitized video", func(p *Profile) {
+			*p = Profile{Kind: "video", PartSizeMB: 8, StoragePath: "videos/{key_base}", SanitizeOriginal: true}
+		}, `sanitize_original only applies to kind image, not "video"`},
+		{"JPEG XL format", func(p *Profile) { p.ConvertTo = "jxl" }, ""},
+		{"Unknown format", func(p *Profile) { p.ConvertTo = "gif" }, `convert_to "gif"`},
+		{"Variants", func(p *Profile) {
+			p.Variants = map[string]Variant{"card": {Width: 600, Height: 400, Fit: FitContain, Format: "jpeg", Quality: 80}, "hero_2x": {Width: 2400}}
+			p.DefaultSize = "card"
+		}, ""},
+		{"Variant named like a size", func(p *Profile) { p.Variants = map[string]Variant{"2x": {Width: 512}} }, "variants.2x: name must start with a letter"},
+		{"Variant without width", func(p *Profile) { p.Variants = map[string]Variant{"card": {Height: 400}} }, "variants.card: w 0 and h 400 must be positive"},
+		{"Variant with unknown fit", func(p *Profile) { p.Variants = map[string]Variant{"card": {Width: 600, Height: 400, Fit: "crop"}} }, `variants.card: fit "crop"`},
+		{"Variant with auto format", func(p *Profile) { p.Variants = map[string]Variant{"card": {Width: 600, Format: ConvertAuto}} }, `variants.card: format "auto" must be one of webp, jpeg, png, avif or jxl`},
+		{"Variant quality too high", func(p *Profile) { p.Variants = map[string]Variant{"card": {Width: 600, Quality: 101}} }, "variants.card: quality 101"},
+		{"Unknown kind", func(p *Profile) { p.Kind = "audio" }, `kind "audio" must be image or video`},
+		{"Missing kind", func(p *Profile) { p.Kind = "" }, `kind "" must be image or video`},
+		{"Part size below S3 minimum", func(p *Profile) { p.PartSizeMB = 4 }, "part_size_mb 4 must be between 5"},
+		{"Part size unset", func(p *Profile) { p.PartSizeMB = 0 }, "part_size_mb 0 must be between 5"},
+		{"Negative multipart threshold", func(p *Profile) { p.MultipartThresholdMB = -1 }, "multipart_threshold_mb -1"},
+		{"Quality too high", func(p *Profile) { p.Quality = 101 }, "quality 101 must be between 1 and 100"},
+		{"Allowed quality out of range", func(p *Profile) { p.AllowedQualities = []int{75, 0} }, "allowed_qualities[1] 0 must be between 1 and 100"},
+		{"Missing storage_path", func(p *Profile) { p.StoragePath = "" }, "missing required 'storage_path'"},
+		{"Unknown placeholder", func(p *Profile) { p.StoragePath = "originals/{keybase}" }, "storage_path: unknown placeholder {keybase}"},
+		{"Date, hash and tenant placeholders", func(p *Profile) { p.StoragePath = "{tenant|shared}/{year}/{month}/{sha256:8}/{uuid}.{ext}" }, ""},
+		{"Hash without length", func(p *Profile) { p.StoragePath = "originals/{sha256}/{key_base}" }, "{sha256} needs a length"},
+		{"Argument on a plain placeholder", func(p *Profile) { p.StoragePath = "originals/{year:2}/{key_base}" }, "{year:2} takes no argument"},
+		{"Unbalanced braces", func(p *Profile) { p.StoragePath = "originals/{key_base" }, "unbalanced braces"},
+		{"Placeholder in thumb_folder", func(p *Profile) { p.ThumbFolder = "thumbnails/{key_base}" }, `thumb_folder "thumbnails/{key_base}" can't contain placeholders`},
+		{"Deeper shards", func(p *Profile) { p.ShardHash = "xxhash"; p.ShardDepth = 2 }, ""},
+		{"Unknown shard hash", func(p *Profile) { p.ShardHash = "md5" }, `shard_hash "md5"`},
+		{"Unknown visibility", func(p *Profile) { p.Visibility = "secret" }, `visibility "secret"`},
+		{"Unknown private delivery", func(p *Profile) { p.PrivateDelivery = "stream" }, `private_delivery "stream"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile := validProfile()
+			tt.modify(&profile)
+			problems := validateProfile(&profile)
+			if tt.expected == "" {
+				if len(problems) > 0 {
+					t.Errorf("Expected no problems, got %v", problems)
+				}
+				return
+			}
+			if len(problems) != 1 || !strings.Contains(problems[0], tt.expected) {
+				t.Errorf("Expected one problem containing %q, got %v", tt.expected, problems)
+			}
+		})
+	}
+}
+
+func TestValidateStorageConfig_ReportsAllProblems(t *testing.T) {
+	broken := validProfile()
+	broken.Sizes = []string{"25b"}
+	broken.DefaultSize = ""
+	broken.Quality = 0
+	broken.PartSizeMB = 1
+	other := validProfile()
+	other.ConvertTo = "bmp"
+
+	err := validateStorageConfig(&StorageConfig{Profiles: map[string]Profile{
+		"avatar": validProfile(),
+		"photo":  broken,
+		"banner": other,
+	}})
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected a *ValidationError, got %v", err)
+	}
+	expected := []string{
+		`profile 'banner': convert_to "bmp"`,
+		"profile 'photo': part_size_mb 1",
+		`profile 'photo': sizes[0] "25b"`,
+	}
+	if len(validationErr.Problems) != len(expected) {
+		t.Fatalf("Expected %d problems, got %v", len(expected), validationErr.Problems)
+	}
+	for i, prefix := range expected {
+		if !strings.HasPrefix(validationErr.Problems[i], prefix) {
+			t.Errorf("Problem %d = %q, expected it to start with %q", i, validationErr.Problems[i], prefix)
+		}
+	}
+}
+
+func TestLoadStorageConfig_Example(t *testing.T) {
+	t.Setenv("STORAGE_CONFIG_PATH", "../../examples/storage-config.yaml")
+	if _, err := LoadStorageConfig(nil, &Config{}); err != nil {
+		t.Errorf("Example storage config is invalid: %v", err)
+	}
+}
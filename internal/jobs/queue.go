@@ -0,0 +1,227 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"mediaflow/internal/auth"
+	"mediaflow/internal/response"
+)
+
+// Job states
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// retention is how long finished jobs stay queryable
+const retention = time.Hour
+
+// ErrQueueFull is returned by Enqueue when the backlog is at capacity
+var ErrQueueFull = errors.New("job queue is full")
+
+// Job is a post-upload processing job for one original
+type Job struct {
+	ID        string    `json:"id"`
+	Status    string    `json:"status"`
+	Profile   string    `json:"profile"`
+	ObjectKey string    `json:"object_key"`
+	KeyBase   string    `json:"key_base"`
+	ETag      string    `json:"etag,omitempty"`    // Of the uploaded original the job was queued for
+	Outputs   []string  `json:"outputs,omitempty"` // Storage keys written by the job
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ProcessFunc runs a job and returns the storage keys it wrote
+type ProcessFunc func(ctx context.Context, job *Job) ([]string, error)
+
+// Queue is an in-process job queue with a fixed pool of workers.
+// Job state is kept in memory and is lost on restart.
+type Queue struct {
+	workers int
+	process ProcessFunc
+	pending chan *Job
+
+	mu     sync.Mutex
+	jobs   map[string]*Job
+	latest map[string]*Job // Last job queued for each object key
+
+	wg sync.WaitGroup
+}
+
+// NewQueue creates a queue with the given number of workers and backlog capacity
+func NewQueue(workers, capacity int, process ProcessFunc) *Queue {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Queue{
+		workers: workers,
+		process: process,
+		pending: make(chan *Job, capacity),
+		jobs:    make(map[string]*Job),
+		latest:  make(map[string]*Job),
+	}
+}
+
+// Start launches the workers. They run until Stop is called.
+func (q *Queue) Start(ctx context.Context) {
+	pending := q.pending
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			for job := range pending {
+				q.run(ctx, job)
+			}
+		}()
+	}
+}
+
+// Stop stops accepting jobs and waits for queued and running jobs to finish
+func (q *Queue) Stop() {
+	q.mu.Lock()
+	close(q.pending)
+	q.pending = nil
+	q.mu.Unlock()
+	q.wg.Wait()
+}
+
+// Enqueue queues a job for an uploaded original and returns its ID. etag identifies the upload:
+// while the last job for objectKey was queued for the same etag and hasn't failed, its ID is
+// returned instead, so finalizing an upload again doesn't process it again. An empty etag always
+// queues a job.
+func (q *Queue) Enqueue(profile, objectKey, keyBase, etag string) (string, error) {
+	id, err := newJobID()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	job := &Job{
+		ID:        id,
+		Status:    StatusQueued,
+		Profile:   profile,
+		ObjectKey: objectKey,
+		KeyBase:   keyBase,
+		ETag:      etag,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.pending == nil {
+		return "", errors.New("job queue is stopped")
+	}
+	q.prune(now)
+	if last, ok := q.latest[objectKey]; ok && etag != "" && last.ETag == etag && last.Status != StatusFailed {
+		return last.ID, nil
+	}
+	select {
+	case q.pending <- job:
+	default:
+		return "", ErrQueueFull
+	}
+	q.jobs[id] = job
+	q.latest[objectKey] = job
+	return id, nil
+}
+
+// Get returns a snapshot of a job
+func (q *Queue) Get(id string) (*Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	snapshot := *job
+	return &snapshot, true
+}
+
+// HandleGetJob handles GET /v1/jobs/{id}
+func (q *Queue) HandleGetJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		response.JSON("Method not allowed").WriteError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/v1/jobs/")
+	job, ok := q.Get(id)
+	if !ok {
+		response.JSON(fmt.Sprintf("Job '%s' not found", id)).WriteError(w, http.StatusNotFound)
+		return
+	}
+	// Jobs are visible to keys that may upload to their profile, and to upload tokens covering the upload
+	if err := auth.Authorize(r.Context(), auth.ScopeUploadPresign, job.Profile); err != nil {
+		response.JSON(err.Error()).WriteError(w, http.StatusForbidden)
+		return
+	}
+	if claims, ok := auth.UploadClaimsFromContext(r.Context()); ok {
+		if err := claims.Authorize(job.Profile, job.KeyBase, 0); err != nil {
+			response.JSON(err.Error()).WriteError(w, http.StatusForbidden)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(job)
+}
+
+func (q *Queue) run(ctx context.Context, job *Job) {
+	q.update(job, func(j *Job) { j.Status = StatusRunning })
+
+	snapshot := *job
+	outputs, err := q.process(ctx, &snapshot)
+	q.update(job, func(j *Job) {
+		j.Outputs = outputs
+		if err != nil {
+			j.Status = StatusFailed
+			j.Error = err.Error()
+			return
+		}
+		j.Status = StatusSucceeded
+	})
+	if err != nil {
+		fmt.Printf("❌ Job %s (%s) failed: %v\n", job.ID, job.ObjectKey, err)
+	}
+}
+
+func (q *Queue) update(job *Job, fn func(*Job)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	fn(job)
+	job.UpdatedAt = time.Now().UTC()
+}
+
+// prune forgets finished jobs past retention. Callers must hold q.mu.
+func (q *Queue) prune(now time.Time) {
+	for id, job := range q.jobs {
+		finished := job.Status == StatusSucceeded || job.Status == StatusFailed
+		if finished && now.Sub(job.UpdatedAt) > retention {
+			delete(q.jobs, id)
+			if q.latest[job.ObjectKey] == job {
+				delete(q.latest, job.ObjectKey)
+			}
+		}
+	}
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate job id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,147 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"mediaflow/internal/auth"
+)
+
+// waitForStatus polls until a job reaches a finished state
+func waitForStatus(t *testing.T, q *Queue, id string) *Job {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		job, ok := q.Get(id)
+		if !ok {
+			t.Fatalf("Job %s not found", id)
+		}
+		if job.Status == StatusSucceeded || job.Status == StatusFailed {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Job %s did not finish", id)
+	return nil
+}
+
+func TestQueue_RunsJobs(t *testing.T) {
+	q := NewQueue(2, 10, func(ctx context.Context, job *Job) ([]string, error) {
+		if job.KeyBase == "broken" {
+			return nil, errors.New("corrupt image")
+		}
+		return []string{"thumbnails/" + job.KeyBase + "_256.webp"}, nil
+	})
+	q.Start(context.Background())
+	defer q.Stop()
+
+	okID, err := q.Enqueue("avatar", "originals/avatars/me", "me", "")
+	if err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	failID, _ := q.Enqueue("avatar", "originals/avatars/broken", "broken", "")
+
+	job := waitForStatus(t, q, okID)
+	if job.Status != StatusSucceeded || len(job.Outputs) != 1 || job.Outputs[0] != "thumbnails/me_256.webp" {
+		t.Errorf("Unexpected succeeded job: %+v", job)
+	}
+	job = waitForStatus(t, q, failID)
+	if job.Status != StatusFailed || job.Error != "corrupt image" {
+		t.Errorf("Unexpected failed job: %+v", job)
+	}
+}
+
+func TestQueue_RejectsWhenFull(t *testing.T) {
+	// No workers started, so nothing drains the backlog
+	q := NewQueue(1, 1, func(ctx context.Context, job *Job) ([]string, error) { return nil, nil })
+
+	if _, err := q.Enqueue("avatar", "originals/a", "a", ""); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	if _, err := q.Enqueue("avatar", "originals/b", "b", ""); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Expected ErrQueueFull, got %v", err)
+	}
+}
+
+func TestQueue_EnqueueSameUpload(t *testing.T) {
+	q := NewQueue(1, 10, func(ctx context.Context, job *Job) ([]string, error) {
+		if job.ETag == `"broken"` {
+			return nil, errors.New("corrupt image")
+		}
+		return nil, nil
+	})
+	q.Start(context.Background())
+	defer q.Stop()
+
+	first, _ := q.Enqueue("avatar", "originals/me", "me", `"v1"`)
+	if again, _ := q.Enqueue("avatar", "originals/me", "me", `"v1"`); again != first {
+		t.Errorf("Expected the queued job %s for the same upload, got %s", first, again)
+	}
+	waitForStatus(t, q, first)
+	if again, _ := q.Enqueue("avatar", "originals/me", "me", `"v1"`); again != first {
+		t.Errorf("Expected the finished job %s for the same upload, got %s", first, again)
+	}
+
+	// A new upload to the same key, or one without an etag, is processed again
+	second, _ := q.Enqueue("avatar", "originals/me", "me", `"v2"`)
+	if second == first {
+		t.Error("Expected a new job for a new upload")
+	}
+	if again, _ := q.Enqueue("avatar", "originals/me", "me", ""); again == second {
+		t.Error("Expected a new job without an etag")
+	}
+
+	// Failed jobs are retried
+	failed, _ := q.Enqueue("avatar", "originals/broken", "broken", `"broken"`)
+	waitForStatus(t, q, failed)
+	if again, _ := q.Enqueue("avatar", "originals/broken", "broken", `"broken"`); again == failed {
+		t.Error("Expected a failed job to be queued again")
+	}
+}
+
+func TestQueue_HandleGetJob(t *testing.T) {
+	q := NewQueue(1, 10, func(ctx context.Context, job *Job) ([]string, error) { return nil, nil })
+	id, _ := q.Enqueue("avatar", "originals/avatars/me", "me", "")
+
+	rr := httptest.NewRecorder()
+	auth.APIKeyMiddleware(&auth.Config{})(http.HandlerFunc(q.HandleGetJob)).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/jobs/"+id, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rr.Code)
+	}
+	var job Job
+	if err := json.NewDecoder(rr.Body).Decode(&job); err != nil {
+		t.Fatalf("Failed to decode job: %v", err)
+	}
+	if job.ID != id || job.Status != StatusQueued || job.ObjectKey != "originals/avatars/me" {
+		t.Errorf("Unexpected job: %+v", job)
+	}
+
+	// Upload tokens see the jobs of the uploads they cover
+	for _, tt := range []struct {
+		claims   *auth.UploadClaims
+		expected int
+	}{
+		{&auth.UploadClaims{Profile: "avatar"}, http.StatusOK},
+		{&auth.UploadClaims{Profile: "avatar", KeyBasePrefix: "m"}, http.StatusOK},
+		{&auth.UploadClaims{Profile: "avatar", KeyBasePrefix: "other"}, http.StatusForbidden},
+		{&auth.UploadClaims{Profile: "photo"}, http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/v1/jobs/"+id, nil)
+		rr = httptest.NewRecorder()
+		q.HandleGetJob(rr, req.WithContext(auth.WithUploadClaims(req.Context(), tt.claims)))
+		if rr.Code != tt.expected {
+			t.Errorf("Expected %d for a token for %+v, got %d", tt.expected, *tt.claims, rr.Code)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	q.HandleGetJob(rr, httptest.NewRequest(http.MethodGet, "/v1/jobs/unknown", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown job, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,444 @@
+// Package objectkey renders storage_path templates into object keys. It is shared by uploads,
+// which choose an asset's key, and reads and deletes, which have to find it again.
+package objectkey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"mediaflow/internal/storage"
+)
+
+// ErrAmbiguousKey is returned when an unrecorded asset matches several keys
+var ErrAmbiguousKey = errors.New("ambiguous object key")
+
+// indexPrefix is where the resolved keys of assets with unstable templates are recorded. Keys of
+// tenants go under indexPrefix + "@" + tenant, which no key_base can render to.
+const indexPrefix = "_keys"
+
+var placeholderPattern = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// stablePlaceholders can be rendered again from the profile and key_base alone
+var stablePlaceholders = map[string]bool{"key_base": true, "shard": true, "shard?": true, "profile": true, "sha256": true}
+
+// knownPlaceholders are all supported placeholders; sha256 takes a length, as in {sha256:8}
+var knownPlaceholders = map[string]bool{
+	"key_base": true, "ext": true, "shard": true, "shard?": true, "profile": true, "tenant": true,
+	"year": true, "month": true, "day": true, "uuid": true, "sha256": true,
+}
+
+// Index is the storage that resolved keys are recorded in, usually the asset's own backend
+type Index interface {
+	PutObject(ctx context.Context, key string, body io.Reader, contentType string) error
+	GetObjectStream(ctx context.Context, key string) (io.ReadCloser, *storage.ObjectInfo, error)
+	ListByPrefix(ctx context.Context, prefix string) ([]string, error)
+}
+
+// Vars are the values substituted into a template
+type Vars struct {
+	KeyBase string
+	Ext     string
+	Shard   string // Empty when sharding is disabled
+	Profile string
+	Tenant  string
+	Time    time.Time // For {year}, {month} and {day}; zero means now
+	UUID    string    // For {uuid}; generated when empty
+}
+
+// placeholder is a parsed {name:arg|default}
+type placeholder struct {
+	name, arg, def string
+}
+
+func parsePlaceholder(inner string) placeholder {
+	var p placeholder
+	inner, p.def, _ = strings.Cut(inner, "|")
+	p.name, p.arg, _ = strings.Cut(inner, ":")
+	return p
+}
+
+// Render substitutes vars into template. Placeholders that render empty (and have no
+// |default) are dropped together with the slash next to them, so "a/{shard?}/b" becomes "a/b".
+func Render(template string, vars Vars) string {
+	if vars.Time.IsZero() {
+		vars.Time = time.Now()
+	}
+	vars.Time = vars.Time.UTC()
+
+	rendered := placeholderPattern.ReplaceAllStringFunc(template, func(match string) string {
+		p := parsePlaceholder(match[1 : len(match)-1])
+		var value string
+		switch p.name {
+		case "key_base":
+			value = vars.KeyBase
+		case "ext":
+			value = vars.Ext
+		case "shard", "shard?":
+			value = vars.Shard
+		case "profile":
+			value = vars.Profile
+		case "tenant":
+			value = vars.Tenant
+		case "year":
+			value = fmt.Sprintf("%04d", vars.Time.Year())
+		case "month":
+			value = fmt.Sprintf("%02d", int(vars.Time.Month()))
+		case "day":
+			value = fmt.Sprintf("%02d", vars.Time.Day())
+		case "uuid":
+			if vars.UUID == "" {
+				vars.UUID = NewUUID()
+			}
+			value = vars.UUID
+		case "sha256":
+			sum := sha256.Sum256([]byte(vars.KeyBase))
+			value = hex.EncodeToString(sum[:])
+			if n, err := strconv.Atoi(p.arg); err == nil && n > 0 && n < len(value) {
+				value = value[:n]
+			}
+		default:
+			return match
+		}
+		if value == "" {
+			value = p.def
+		}
+		return value
+	})
+
+	// Drop the empty segments left by empty placeholders
+	segments := strings.Split(rendered, "/")
+	kept := segments[:0]
+	for _, segment := range segments {
+		if segment != "" {
+			kept = append(kept, segment)
+		}
+	}
+	return strings.Join(kept, "/")
+}
+
+// Validate returns the problems with a template: unknown placeholders, bad {sha256:N} lengths
+// and unbalanced braces
+func Validate(template string) []string {
+	var problems []string
+	for _, match := range placeholderPattern.FindAllStringSubmatch(template, -1) {
+		p := parsePlaceholder(match[1])
+		switch {
+		case !knownPlaceholders[p.name]:
+			problems = append(problems, fmt.Sprintf("unknown placeholder %s", match[0]))
+		case p.name == "sha256":
+			if n, err := strconv.Atoi(p.arg); err != nil || n < 1 || n > sha256.Size*2 {
+				problems = append(problems, fmt.Sprintf("%s needs a length between 1 and %d, as in {sha256:8}", match[0], sha256.Size*2))
+			}
+		case p.arg != "":
+			problems = append(problems, fmt.Sprintf("%s takes no argument", match[0]))
+		}
+	}
+	if rest := placeholderPattern.ReplaceAllString(template, ""); strings.ContainsAny(rest, "{}") {
+		problems = append(problems, "unbalanced braces")
+	}
+	return problems
+}
+
+// IsStable reports whether template renders the same key from the profile and key_base alone.
+// Keys from other templates (dates, {uuid}, {tenant}, {ext}) are recorded at upload time.
+func IsStable(template string) bool {
+	for _, match := range placeholderPattern.FindAllStringSubmatch(template, -1) {
+		if !stablePlaceholders[parsePlaceholder(match[1]).name] {
+			return false
+		}
+	}
+	return true
+}
+
+// renderedPatterns match what the placeholders that aren't stable render to. {ext} is a single
+// extension, so abc.jpg is never taken for an original of key_base "ab" or abc.def.jpg for one of
+// "abc"; it can render empty, which drops its segment.
+var renderedPatterns = map[string]string{
+	"ext":   `[^./]+`,
+	"year":  `[0-9]{4}`,
+	"month": `[0-9]{2}`,
+	"day":   `[0-9]{2}`,
+	"uuid":  `[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`,
+}
+
+// Match reports whether key is one that template renders for vars. Stable placeholders and
+// {tenant} must render as they do now; the others only need to match what they can render to,
+// such as four digits for {year}. Used to check keys that clients send back after uploading.
+func Match(template string, vars Vars, key string) bool {
+	// Render never leaves empty segments, and keys must not climb out of their prefix
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	for _, segment := range strings.Split(template, "/") {
+		segmentPattern, canBeEmpty := matchSegment(segment, vars)
+		switch {
+		case segmentPattern == "":
+			// Renders empty, so Render drops it
+		case canBeEmpty:
+			pattern.WriteString("(?:" + segmentPattern + "/)?")
+		default:
+			pattern.WriteString(segmentPattern + "/")
+		}
+	}
+	pattern.WriteString("$")
+
+	re, err := regexp.Compile(pattern.String())
+	return err == nil && re.MatchString(key+"/")
+}
+
+// matchSegment returns a pattern for one segment of a template, and whether it can render empty
+func matchSegment(segment string, vars Vars) (string, bool) {
+	var pattern strings.Builder
+	canBeEmpty := true
+	last := 0
+	for _, loc := range placeholderPattern.FindAllStringIndex(segment, -1) {
+		if loc[0] > last {
+			pattern.WriteString(regexp.QuoteMeta(segment[last:loc[0]]))
+			canBeEmpty = false
+		}
+		last = loc[1]
+
+		p := parsePlaceholder(segment[loc[0]+1 : loc[1]-1])
+		rendered, ok := renderedPatterns[p.name]
+		switch {
+		case !ok:
+			// Stable, or unknown and left as is
+			if value := Render(segment[loc[0]:loc[1]], vars); value != "" {
+				pattern.WriteString(regexp.QuoteMeta(value))
+				canBeEmpty = false
+			}
+		case p.def != "":
+			pattern.WriteString("(?:" + rendered + "|" + regexp.QuoteMeta(p.def) + ")")
+			canBeEmpty = false
+		case p.name == "ext":
+			pattern.WriteString("(?:" + rendered + ")?")
+		default:
+			pattern.WriteString(rendered)
+			canBeEmpty = false
+		}
+	}
+	if last < len(segment) {
+		pattern.WriteString(regexp.QuoteMeta(segment[last:]))
+		canBeEmpty = false
+	}
+	return pattern.String(), canBeEmpty
+}
+
+// Shard hash algorithms
+const (
+	HashSHA1   = "sha1"
+	HashSHA256 = "sha256"
+	HashXXHash = "xxhash"
+)
+
+// Default shard layout: one segment of two hex characters, 256 prefixes
+const (
+	defaultShardDepth = 1
+	defaultShardWidth = 2
+	maxShardDepth     = 4
+)
+
+// Sharding is how shards are derived from key_base: Depth segments of Width hex characters of
+// the key_base's Hash, as in "ab/cd". Zero values use the defaults (sha1, depth 1, width 2).
+type Sharding struct {
+	Hash  string
+	Depth int
+	Width int
+}
+
+func (sh Sharding) withDefaults() Sharding {
+	if sh.Hash == "" {
+		sh.Hash = HashSHA1
+	}
+	if sh.Depth == 0 {
+		sh.Depth = defaultShardDepth
+	}
+	if sh.Width == 0 {
+		sh.Width = defaultShardWidth
+	}
+	return sh
+}
+
+// Shard returns the shard of keyBase
+func (sh Sharding) Shard(keyBase string) string {
+	sh = sh.withDefaults()
+	var digest []byte
+	switch sh.Hash {
+	case HashSHA256:
+		sum := sha256.Sum256([]byte(keyBase))
+		digest = sum[:]
+	case HashXXHash:
+		digest = binary.BigEndian.AppendUint64(nil, xxhash64([]byte(keyBase)))
+	default:
+		sum := sha1.Sum([]byte(keyBase))
+		digest = sum[:]
+	}
+	hexDigest := hex.EncodeToString(digest)
+
+	segments := make([]string, sh.Depth)
+	for i := range segments {
+		segments[i] = hexDigest[i*sh.Width : (i+1)*sh.Width]
+	}
+	return strings.Join(segments, "/")
+}
+
+// Validate returns the problems with a shard layout: unknown hashes, and depths and widths
+// that are out of range or need more characters than the hash has
+func (sh Sharding) Validate() []string {
+	var problems []string
+	hexLen := 0
+	switch sh.Hash {
+	case "", HashSHA1:
+		hexLen = sha1.Size * 2
+	case HashSHA256:
+		hexLen = sha256.Size * 2
+	case HashXXHash:
+		hexLen = 16
+	default:
+		problems = append(problems, fmt.Sprintf("shard_hash %q must be sha1, sha256 or xxhash", sh.Hash))
+	}
+	if sh.Depth < 0 || sh.Depth > maxShardDepth {
+		problems = append(problems, fmt.Sprintf("shard_depth %d must be between 1 and %d", sh.Depth, maxShardDepth))
+	}
+	if sh.Width < 0 {
+		problems = append(problems, fmt.Sprintf("shard_width %d must be positive", sh.Width))
+	}
+	if d := sh.withDefaults(); len(problems) == 0 && d.Depth*d.Width > hexLen {
+		problems = append(problems, fmt.Sprintf("shard_depth %d x shard_width %d needs more than the %d hex characters of %s", d.Depth, d.Width, hexLen, d.Hash))
+	}
+	return problems
+}
+
+// Shard returns the default shard of a key_base: the first byte of its SHA-1, in hex
+func Shard(keyBase string) string {
+	return Sharding{}.Shard(keyBase)
+}
+
+// IndexKey returns where the resolved key of an asset is recorded. Assets of different tenants
+// may share a key_base, so each tenant has its own index.
+func IndexKey(profile, tenant, keyBase string) string {
+	if tenant == "" {
+		return fmt.Sprintf("%s/%s/%s", indexPrefix, profile, keyBase)
+	}
+	return fmt.Sprintf("%s@%s/%s/%s", indexPrefix, tenant, profile, keyBase)
+}
+
+// metadataSuffix is appended to an original's key for its metadata sidecar
+const metadataSuffix = ".metadata.json"
+
+// MetadataKey returns where the metadata sidecar of an original is stored, next to the original
+func MetadataKey(originalKey string) string {
+	return originalKey + metadataSuffix
+}
+
+// IsMetadataKey reports whether key is a metadata sidecar
+func IsMetadataKey(key string) bool {
+	return strings.HasSuffix(key, metadataSuffix)
+}
+
+// Record stores the resolved key of an asset whose template isn't stable, so Resolve can find it
+func Record(ctx context.Context, index Index, template string, vars Vars, objectKey string) error {
+	if IsStable(template) {
+		return nil
+	}
+	if err := index.PutObject(ctx, IndexKey(vars.Profile, vars.Tenant, vars.KeyBase), strings.NewReader(objectKey), "text/plain"); err != nil {
+		return fmt.Errorf("failed to record object key: %w", err)
+	}
+	return nil
+}
+
+// Resolve returns the key of an existing asset: rendered for stable templates, otherwise the key
+// recorded at upload time. Assets of {ext} templates uploaded before keys were recorded are found
+// with resolveExt. Returns storage.ErrNotFound if nothing was recorded.
+func Resolve(ctx context.Context, index Index, template string, vars Vars) (string, error) {
+	if IsStable(template) {
+		return Render(template, vars), nil
+	}
+	// GetObjectStream maps missing objects to storage.ErrNotFound on every backend
+	body, _, err := index.GetObjectStream(ctx, IndexKey(vars.Profile, vars.Tenant, vars.KeyBase))
+	if errors.Is(err, storage.ErrNotFound) {
+		return resolveExt(ctx, index, template, vars)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	key, err := io.ReadAll(body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read recorded object key: %w", err)
+	}
+	return string(key), nil
+}
+
+// resolveExt finds the key of an asset whose template is stable except for {ext}. Such keys were
+// rendered again on every read before {ext} keys were recorded, so older assets have no record:
+// the key is rendered with vars.Ext when the request has one, otherwise found by listing the keys
+// that start with everything before {ext}. Returns storage.ErrNotFound for other templates, and
+// ErrAmbiguousKey when several extensions were uploaded.
+func resolveExt(ctx context.Context, index Index, template string, vars Vars) (string, error) {
+	extAt := -1
+	for _, loc := range placeholderPattern.FindAllStringSubmatchIndex(template, -1) {
+		name := parsePlaceholder(template[loc[2]:loc[3]]).name
+		if name == "ext" && extAt < 0 {
+			extAt = loc[0]
+		} else if name != "ext" && !stablePlaceholders[name] {
+			return "", storage.ErrNotFound
+		}
+	}
+	if extAt < 0 {
+		return "", storage.ErrNotFound
+	}
+	if vars.Ext != "" {
+		return Render(template, vars), nil
+	}
+
+	prefix := Render(template[:extAt], vars)
+	if prefix == "" {
+		// Never list the whole bucket
+		return "", storage.ErrNotFound
+	}
+	keys, err := index.ListByPrefix(ctx, prefix)
+	if err != nil {
+		return "", fmt.Errorf("failed to list keys of %s: %w", vars.KeyBase, err)
+	}
+	var found []string
+	for _, key := range keys {
+		if !IsMetadataKey(key) && Match(template, vars, key) {
+			found = append(found, key)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", storage.ErrNotFound
+	case 1:
+		return found[0], nil
+	default:
+		// Picking one would serve or delete an arbitrary original
+		return "", fmt.Errorf("%w: %s has several originals: %s", ErrAmbiguousKey, vars.KeyBase, strings.Join(found, ", "))
+	}
+}
+
+// NewUUID returns a random (version 4) UUID
+func NewUUID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b) // Never returns an error since Go 1.24
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
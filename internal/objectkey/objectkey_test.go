@@ -0,0 +1,314 @@
+package objectkey
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"mediaflow/internal/storage"
+)
+
+func TestRender(t *testing.T) {
+	uploadedAt := time.Date(2025, time.March, 7, 23, 30, 0, 0, time.FixedZone("EST", -5*60*60))
+
+	tests := []struct {
+		name     string
+		template string
+		vars     Vars
+		expected string
+	}{
+		{"Key base and ext", "originals/{key_base}.{ext}", Vars{KeyBase: "abc", Ext: "jpg"}, "originals/abc.jpg"},
+		{"Shard", "originals/{shard?}/{key_base}", Vars{KeyBase: "abc", Shard: "a9"}, "originals/a9/abc"},
+		{"Empty optional shard", "originals/{shard?}/{key_base}", Vars{KeyBase: "abc"}, "originals/abc"},
+		{"Dates in UTC", "uploads/{year}/{month}/{day}/{key_base}", Vars{KeyBase: "abc", Time: uploadedAt}, "uploads/2025/03/08/abc"},
+		{"Hash prefix", "{sha256:8}/{key_base}", Vars{KeyBase: "abc"}, "ba7816bf/abc"},
+		{"Profile and tenant", "{profile}/{tenant}/{key_base}", Vars{KeyBase: "abc", Profile: "avatar", Tenant: "acme"}, "avatar/acme/abc"},
+		{"Default for empty tenant", "{tenant|shared}/{key_base}", Vars{KeyBase: "abc"}, "shared/abc"},
+		{"Empty tenant without default", "{tenant}/{key_base}", Vars{KeyBase: "abc"}, "abc"},
+		{"Given UUID", "{uuid}", Vars{UUID: "0f8e9c1a-0000-4000-8000-000000000000"}, "0f8e9c1a-0000-4000-8000-000000000000"},
+		{"Unknown placeholder kept", "{keybase}/{key_base}", Vars{KeyBase: "abc"}, "{keybase}/abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Render(tt.template, tt.vars); got != tt.expected {
+				t.Errorf("Render(%q) = %q, expected %q", tt.template, got, tt.expected)
+			}
+		})
+	}
+
+	// A generated UUID differs on every render
+	first, second := Render("{uuid}", Vars{}), Render("{uuid}", Vars{})
+	if len(first) != 36 || first == second {
+		t.Errorf("Expected two distinct UUIDs, got %q and %q", first, second)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		template string
+		expected string // Substring of the single expected problem; empty for a valid template
+	}{
+		{"originals/{shard?}/{key_base}", ""},
+		{"{tenant|shared}/{year}/{month}/{day}/{sha256:8}/{uuid}.{ext}", ""},
+		{"{sha256:64}/{key_base}", ""},
+		{"{keybase}", "unknown placeholder {keybase}"},
+		{"{sha256}/{key_base}", "{sha256} needs a length"},
+		{"{sha256:65}/{key_base}", "{sha256:65} needs a length"},
+		{"{year:4}/{key_base}", "{year:4} takes no argument"},
+		{"originals/{key_base", "unbalanced braces"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			problems := Validate(tt.template)
+			if tt.expected == "" {
+				if len(problems) > 0 {
+					t.Errorf("Expected no problems, got %v", problems)
+				}
+				return
+			}
+			if len(problems) != 1 || !strings.Contains(problems[0], tt.expected) {
+				t.Errorf("Expected one problem containing %q, got %v", tt.expected, problems)
+			}
+		})
+	}
+}
+
+func TestIsStable(t *testing.T) {
+	tests := map[string]bool{
+		"originals/{shard?}/{key_base}":   true,
+		"{profile}/{sha256:4}/{key_base}": true,
+		"originals/{key_base}.{ext}":      false,
+		"uploads/{year}/{key_base}":       false,
+		"{tenant|shared}/{key_base}":      false,
+		"{uuid}":                          false,
+	}
+	for template, expected := range tests {
+		if got := IsStable(template); got != expected {
+			t.Errorf("IsStable(%q) = %t, expected %t", template, got, expected)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	vars := Vars{KeyBase: "abc", Shard: "a9", Profile: "avatar"}
+
+	tests := []struct {
+		template string
+		key      string
+		expected bool
+	}{
+		{"originals/{shard?}/{key_base}", "originals/a9/abc", true},
+		{"originals/{shard?}/{key_base}", "originals/a9/abd", false},
+		{"originals/{shard?}/{key_base}", "originals/abc", false},
+		{"originals/{key_base}.{ext}", "originals/abc.jpg", true},
+		{"originals/{key_base}.{ext}", "originals/abc.jpg/x", false},
+		{"originals/{key_base}.{ext}", "originals/other.jpg", false},
+		{"originals/{key_base}.{ext}", "originals/abc.def.jpg", false},
+		{"uploads/{year}/{month}/{day}/{key_base}", "uploads/2025/03/08/abc", true},
+		{"uploads/{year}/{month}/{day}/{key_base}", "uploads/2025/3/08/abc", false},
+		{"{profile}/{tenant}/{key_base}", "avatar/abc", true},
+		{"{profile}/{tenant}/{key_base}", "avatar/acme/abc", false},
+		{"{profile}/{tenant}/{key_base}", "avatar/../abc", false},
+		{"{profile}/{tenant}/{key_base}", "avatar//abc", false},
+		{"{tenant|shared}/{key_base}", "shared/abc", true},
+		{"{uuid}.{ext}", "0f8e9c1a-0000-4000-8000-000000000000.png", true},
+		{"{uuid}.{ext}", "abc.png", false},
+		{"{sha256:8}/{key_base}", "ba7816bf/abc", true},
+	}
+
+	for _, tt := range tests {
+		if got := Match(tt.template, vars, tt.key); got != tt.expected {
+			t.Errorf("Match(%q, %q) = %t, expected %t", tt.template, tt.key, got, tt.expected)
+		}
+	}
+
+	// {tenant} is bound to the tenant in vars
+	tenantVars := Vars{KeyBase: "abc", Profile: "avatar", Tenant: "acme"}
+	for key, expected := range map[string]bool{"avatar/acme/abc": true, "avatar/other/abc": false, "avatar/abc": false} {
+		if got := Match("{profile}/{tenant}/{key_base}", tenantVars, key); got != expected {
+			t.Errorf("Match(%q) for tenant acme = %t, expected %t", key, got, expected)
+		}
+	}
+
+	// Whatever Render produces matches
+	template := "{tenant}/{year}/{sha256:4}/{shard?}/{uuid}-{key_base}.{ext}"
+	for _, v := range []Vars{vars, {KeyBase: "abc", Tenant: "acme", Ext: "png"}} {
+		if key := Render(template, v); !Match(template, v, key) {
+			t.Errorf("Expected rendered key %q to match", key)
+		}
+	}
+}
+
+func TestRecordAndResolve(t *testing.T) {
+	backend, err := storage.NewLocalBackend(t.TempDir(), "http://localhost", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	vars := Vars{KeyBase: "abc", Profile: "avatar"}
+
+	// Stable templates are rendered and never recorded
+	if err := Record(ctx, backend, "originals/{key_base}", vars, "originals/abc"); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	if _, err := backend.HeadObject(ctx, IndexKey("avatar", "", "abc")); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected no recorded key for a stable template, got %v", err)
+	}
+	if key, err := Resolve(ctx, backend, "originals/{key_base}", vars); err != nil || key != "originals/abc" {
+		t.Errorf("Resolve = %q, %v; expected originals/abc", key, err)
+	}
+
+	// Other templates resolve to the recorded key
+	template := "uploads/{year}/{uuid}"
+	if _, err := Resolve(ctx, backend, template, vars); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected storage.ErrNotFound before recording, got %v", err)
+	}
+	objectKey := Render(template, vars)
+	if err := Record(ctx, backend, template, vars, objectKey); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	if key, err := Resolve(ctx, backend, template, vars); err != nil || key != objectKey {
+		t.Errorf("Resolve = %q, %v; expected %s", key, err, objectKey)
+	}
+
+	// Tenants sharing a key_base have their own records
+	tenantVars := vars
+	tenantVars.Tenant = "acme"
+	tenantKey := Render(template, tenantVars)
+	if err := Record(ctx, backend, template, tenantVars, tenantKey); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	if key, err := Resolve(ctx, backend, template, vars); err != nil || key != objectKey {
+		t.Errorf("Resolve without tenant = %q, %v; expected %s", key, err, objectKey)
+	}
+	if key, err := Resolve(ctx, backend, template, tenantVars); err != nil || key != tenantKey {
+		t.Errorf("Resolve for tenant = %q, %v; expected %s", key, err, tenantKey)
+	}
+}
+
+func TestResolve_UnrecordedExt(t *testing.T) {
+	backend, err := storage.NewLocalBackend(t.TempDir(), "http://localhost", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	// Uploaded before {ext} keys were recorded
+	for _, key := range []string{"originals/abc.jpg", "originals/abc.jpg.metadata.json", "originals/abcd.png", "originals/abc.def.png", "originals/xyz.jpg", "originals/xyz.png"} {
+		if err := backend.PutObject(ctx, key, strings.NewReader("data"), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		vars     Vars
+		expected string // Empty for storage.ErrNotFound
+	}{
+		{"Listed", "originals/{key_base}.{ext}", Vars{KeyBase: "abc", Profile: "avatar"}, "originals/abc.jpg"},
+		{"Rendered with the request's ext", "originals/{key_base}.{ext}", Vars{KeyBase: "abc", Ext: "png", Profile: "avatar"}, "originals/abc.png"},
+		{"Nothing uploaded", "originals/{key_base}.{ext}", Vars{KeyBase: "ab", Profile: "avatar"}, ""},
+		{"Other unstable placeholders", "originals/{year}/{key_base}.{ext}", Vars{KeyBase: "abc", Profile: "avatar"}, ""},
+		{"Nothing before {ext}", "{ext}/{key_base}", Vars{KeyBase: "abc", Profile: "avatar"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := Resolve(ctx, backend, tt.template, tt.vars)
+			if tt.expected == "" {
+				if !errors.Is(err, storage.ErrNotFound) {
+					t.Errorf("Expected storage.ErrNotFound, got %q, %v", key, err)
+				}
+				return
+			}
+			if err != nil || key != tt.expected {
+				t.Errorf("Resolve = %q, %v; expected %s", key, err, tt.expected)
+			}
+		})
+	}
+
+	// Several extensions of one key_base are refused rather than one of them picked
+	if key, err := Resolve(ctx, backend, "originals/{key_base}.{ext}", Vars{KeyBase: "xyz", Profile: "avatar"}); !errors.Is(err, ErrAmbiguousKey) {
+		t.Errorf("Expected ErrAmbiguousKey, got %q, %v", key, err)
+	}
+
+	// A recorded key wins
+	if err := Record(ctx, backend, "originals/{key_base}.{ext}", Vars{KeyBase: "abc", Profile: "avatar"}, "originals/abc.webp"); err != nil {
+		t.Fatal(err)
+	}
+	if key, err := Resolve(ctx, backend, "originals/{key_base}.{ext}", Vars{KeyBase: "abc", Profile: "avatar"}); err != nil || key != "originals/abc.webp" {
+		t.Errorf("Resolve = %q, %v; expected the recorded key", key, err)
+	}
+}
+
+func TestSharding_Shard(t *testing.T) {
+	tests := []struct {
+		name     string
+		sharding Sharding
+		expected string
+	}{
+		{"Default", Sharding{}, "a9"},
+		{"Depth 2", Sharding{Depth: 2}, "a9/99"},
+		{"Width 3", Sharding{Width: 3}, "a99"},
+		{"SHA-256", Sharding{Hash: HashSHA256, Depth: 2, Width: 1}, "b/a"},
+		{"xxHash", Sharding{Hash: HashXXHash, Depth: 3}, "44/bc/2c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.sharding.Shard("abc"); got != tt.expected {
+				t.Errorf("Shard(abc) = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+
+	if Shard("abc") != (Sharding{Hash: HashSHA1, Depth: 1, Width: 2}).Shard("abc") {
+		t.Error("Expected Shard to use the default layout")
+	}
+}
+
+func TestSharding_Validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		sharding Sharding
+		expected string // Substring of the single expected problem; empty for a valid layout
+	}{
+		{"Defaults", Sharding{}, ""},
+		{"Deep SHA-1", Sharding{Depth: 4, Width: 10}, ""},
+		{"Unknown hash", Sharding{Hash: "md5"}, `shard_hash "md5"`},
+		{"Too deep", Sharding{Depth: 5}, "shard_depth 5 must be between 1 and 4"},
+		{"Negative width", Sharding{Width: -1}, "shard_width -1 must be positive"},
+		{"Longer than the hash", Sharding{Hash: HashXXHash, Depth: 3, Width: 6}, "more than the 16 hex characters of xxhash"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := tt.sharding.Validate()
+			if tt.expected == "" {
+				if len(problems) > 0 {
+					t.Errorf("Expected no problems, got %v", problems)
+				}
+				return
+			}
+			if len(problems) != 1 || !strings.Contains(problems[0], tt.expected) {
+				t.Errorf("Expected one problem containing %q, got %v", tt.expected, problems)
+			}
+		})
+	}
+}
+
+func TestXXHash64(t *testing.T) {
+	// Reference values of XXH64 with seed 0
+	tests := map[string]uint64{
+		"":    0xef46db3751d8e999,
+		"a":   0xd24ec4f1a98c6e5b,
+		"abc": 0x44bc2cf5ad770999,
+		"Nobody inspects the spammish repetition": 0xfbcea83c8a378bf1,
+	}
+	for input, expected := range tests {
+		if got := xxhash64([]byte(input)); got != expected {
+			t.Errorf("xxhash64(%q) = %016x, expected %016x", input, got, expected)
+		}
+	}
+}
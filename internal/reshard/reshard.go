@@ -0,0 +1,102 @@
+// Package reshard moves a profile's originals from one storage_path and shard layout to another,
+// so an existing bucket can adopt new sharding settings.
+package reshard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"mediaflow/internal/config"
+	"mediaflow/internal/objectkey"
+	"mediaflow/internal/storage"
+)
+
+// Move is an original that has to move to the key of the new layout
+type Move struct {
+	KeyBase string
+	From    string
+	To      string
+}
+
+// Plan lists the originals stored under from's layout and the keys to's layout gives them.
+// Both storage_path templates must render from key_base alone (recorded keys aren't sharded
+// on read) and from's must have {key_base} in its last segment, so it can be read back from a key.
+// Objects under the same prefix that don't belong to from's layout are left alone, except the
+// metadata sidecars of originals, which move with them.
+func Plan(ctx context.Context, backend storage.Backend, from, to *config.Profile) ([]Move, error) {
+	for _, p := range []*config.Profile{from, to} {
+		if !objectkey.IsStable(p.StoragePath) {
+			return nil, fmt.Errorf("storage_path %q has placeholders that are recorded at upload time, only {key_base}, {shard}, {profile} and {sha256:N} can be resharded", p.StoragePath)
+		}
+	}
+	before, after, ok := strings.Cut(path.Base(from.StoragePath), "{key_base}")
+	if !ok || strings.ContainsAny(before+after, "{}") {
+		return nil, fmt.Errorf("storage_path %q must have {key_base} and no other placeholder in its last segment", from.StoragePath)
+	}
+
+	// Everything up to the first placeholder is shared by all of from's keys
+	prefix, _, _ := strings.Cut(from.StoragePath, "{")
+	keys, err := backend.ListByPrefix(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", prefix, err)
+	}
+
+	listed := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		listed[key] = true
+	}
+
+	var moves []Move
+	for _, key := range keys {
+		if objectkey.IsMetadataKey(key) {
+			continue
+		}
+		name := path.Base(key)
+		if !strings.HasPrefix(name, before) || !strings.HasSuffix(name, after) || len(name) <= len(before)+len(after) {
+			continue
+		}
+		keyBase := name[len(before) : len(name)-len(after)]
+		// Only keys that from's layout renders exactly are its originals
+		if objectkey.Render(from.StoragePath, objectkey.Vars{KeyBase: keyBase, Shard: from.Shard(keyBase), Profile: from.Name}) != key {
+			continue
+		}
+		newKey := objectkey.Render(to.StoragePath, objectkey.Vars{KeyBase: keyBase, Shard: to.Shard(keyBase), Profile: to.Name})
+		if newKey != key {
+			moves = append(moves, Move{KeyBase: keyBase, From: key, To: newKey})
+			if sidecar := objectkey.MetadataKey(key); listed[sidecar] {
+				moves = append(moves, Move{KeyBase: keyBase, From: sidecar, To: objectkey.MetadataKey(newKey)})
+			}
+		}
+	}
+	return moves, nil
+}
+
+// Apply copies each original to its new key and then deletes the old one. Originals whose new key
+// is already taken are skipped and reported, so an interrupted run can be repeated.
+// Returns the number of originals moved.
+func Apply(ctx context.Context, backend storage.Backend, moves []Move, out io.Writer) (int, error) {
+	moved := 0
+	for _, move := range moves {
+		if _, err := backend.HeadObject(ctx, move.To); err == nil {
+			fmt.Fprintf(out, "⚠️ Skipping %s: %s already exists\n", move.From, move.To)
+			continue
+		} else if !errors.Is(err, storage.ErrNotFound) {
+			return moved, fmt.Errorf("failed to check %s: %w", move.To, err)
+		}
+
+		// Copied server-side: streaming through a PUT can't size the body, and can't exceed 5 GB
+		if err := backend.CopyObject(ctx, move.From, move.To); err != nil {
+			return moved, fmt.Errorf("failed to copy %s to %s: %w", move.From, move.To, err)
+		}
+		if err := backend.DeleteObject(ctx, move.From); err != nil {
+			return moved, fmt.Errorf("copied %s to %s but failed to delete it: %w", move.From, move.To, err)
+		}
+		fmt.Fprintf(out, "📦 %s -> %s\n", move.From, move.To)
+		moved++
+	}
+	return moved, nil
+}
@@ -0,0 +1,118 @@
+package reshard
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"mediaflow/internal/config"
+	"mediaflow/internal/s3"
+	"mediaflow/internal/s3/s3test"
+	"mediaflow/internal/storage"
+)
+
+func TestPlanAndApply(t *testing.T) {
+	local, err := storage.NewLocalBackend(t.TempDir(), "http://localhost", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := s3test.NewServer(t, "media")
+	bucket, err := s3.NewClient(context.Background(), "us-east-1", server.Bucket, "access", "secret", server.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, backend := range map[string]storage.Backend{"Local": local, "S3": bucket} {
+		t.Run(name, func(t *testing.T) {
+			testPlanAndApply(t, backend)
+		})
+	}
+	// Originals are copied server-side rather than streamed through a PUT
+	if server.Requests["CopyObject"] != 4 || server.Requests["PutObject"] != 5 {
+		t.Errorf("Expected 4 server-side copies after the 5 setup PUTs, got %v", server.Requests)
+	}
+}
+
+func testPlanAndApply(t *testing.T, backend storage.Backend) {
+	ctx := context.Background()
+
+	from := &config.Profile{Name: "avatar", StoragePath: "originals/avatars/{shard?}/{key_base}", EnableSharding: true}
+	to := &config.Profile{Name: "avatar", StoragePath: "originals/avatars/{shard?}/{key_base}", EnableSharding: true,
+		ShardHash: "sha256", ShardDepth: 2}
+
+	keyBases := []string{"abc", "def", "ghi"}
+	for _, keyBase := range keyBases {
+		key := "originals/avatars/" + from.Shard(keyBase) + "/" + keyBase
+		if err := backend.PutObject(ctx, key, strings.NewReader("image "+keyBase), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A metadata sidecar moves with its original
+	if err := backend.PutObject(ctx, "originals/avatars/"+from.Shard("abc")+"/abc.metadata.json", strings.NewReader("{}"), ""); err != nil {
+		t.Fatal(err)
+	}
+	// Under the prefix, but not at the key the old layout gives it
+	if err := backend.PutObject(ctx, "originals/avatars/zz/abc", strings.NewReader("other"), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	moves, err := Plan(ctx, backend, from, to)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if len(moves) != len(keyBases)+1 {
+		t.Fatalf("Expected %d moves, got %+v", len(keyBases)+1, moves)
+	}
+
+	moved, err := Apply(ctx, backend, moves, io.Discard)
+	if err != nil || moved != len(keyBases)+1 {
+		t.Fatalf("Apply = %d, %v; expected %d moves", moved, err, len(keyBases)+1)
+	}
+	if _, err := backend.HeadObject(ctx, "originals/avatars/"+to.Shard("abc")+"/abc.metadata.json"); err != nil {
+		t.Errorf("Expected the metadata sidecar to move: %v", err)
+	}
+	for _, keyBase := range keyBases {
+		newKey := "originals/avatars/" + to.Shard(keyBase) + "/" + keyBase
+		data, err := backend.GetObject(ctx, newKey)
+		if err != nil || string(data) != "image "+keyBase {
+			t.Errorf("Expected %s at %s, got %q, %v", keyBase, newKey, data, err)
+		}
+		if _, err := backend.HeadObject(ctx, "originals/avatars/"+from.Shard(keyBase)+"/"+keyBase); err == nil {
+			t.Errorf("Expected the old key of %s to be deleted", keyBase)
+		}
+	}
+	if _, err := backend.HeadObject(ctx, "originals/avatars/zz/abc"); err != nil {
+		t.Errorf("Expected unrelated objects to be left alone, got %v", err)
+	}
+
+	// A second run has nothing left to move
+	if moves, err := Plan(ctx, backend, from, to); err != nil || len(moves) != 0 {
+		t.Errorf("Expected no moves after resharding, got %+v, %v", moves, err)
+	}
+}
+
+func TestPlan_UnsupportedTemplates(t *testing.T) {
+	backend, err := storage.NewLocalBackend(t.TempDir(), "http://localhost", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stable := &config.Profile{StoragePath: "originals/{shard?}/{key_base}"}
+
+	tests := []struct {
+		name     string
+		from, to string
+	}{
+		{"Recorded source keys", "uploads/{year}/{key_base}", stable.StoragePath},
+		{"Recorded target keys", stable.StoragePath, "uploads/{uuid}"},
+		{"key_base not in the last segment", "originals/{key_base}/original", stable.StoragePath},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Plan(context.Background(), backend, &config.Profile{StoragePath: tt.from}, &config.Profile{StoragePath: tt.to})
+			if err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
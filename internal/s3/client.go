@@ -2,9 +2,12 @@ package s3
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	utils "mediaflow/internal"
+	"mediaflow/internal/storage"
+	"net/url"
 	"os"
 	"time"
 
@@ -15,6 +18,7 @@ import (
 	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
+// Client implements storage.Backend on top of S3 (or any S3-compatible store)
 type Client struct {
 	s3Client  *s3.Client
 	bucket    string
@@ -69,28 +73,159 @@ func NewClient(ctx context.Context, region, bucket, accessKey, secretKey, endpoi
 	}, nil
 }
 
+var _ storage.Backend = (*Client)(nil)
+
 func (c *Client) GetObject(ctx context.Context, key string) ([]byte, error) {
+	body, _, err := c.GetObjectStream(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return io.ReadAll(body)
+}
+
+// GetObjectStream returns the object body as a stream along with its metadata. The caller must close it.
+func (c *Client) GetObjectStream(ctx context.Context, key string) (io.ReadCloser, *storage.ObjectInfo, error) {
+	result, err := c.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noSuchKey *s3Types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, nil, fmt.Errorf("%w: %s", storage.ErrNotFound, key)
+		}
+		return nil, nil, err
+	}
+
+	info := &storage.ObjectInfo{
+		Size:        aws.ToInt64(result.ContentLength),
+		ContentType: aws.ToString(result.ContentType),
+		ETag:        aws.ToString(result.ETag),
+	}
+	if result.LastModified != nil {
+		info.LastModified = result.LastModified.UTC()
+	}
+	return result.Body, info, nil
+}
+
+// GetObjectRange returns length bytes of the object starting at offset, using a ranged GET. The caller must close it.
+func (c *Client) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
 	result, err := c.s3Client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(c.bucket),
 		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if err != nil {
+		var noSuchKey *s3Types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, fmt.Errorf("%w: %s", storage.ErrNotFound, key)
+		}
+		return nil, err
+	}
+	return result.Body, nil
+}
+
+// HeadObject returns object metadata without downloading the body
+func (c *Client) HeadObject(ctx context.Context, key string) (*storage.ObjectInfo, error) {
+	result, err := c.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(key),
 	})
 	if err != nil {
+		// HEAD responses have no body, so S3 reports a missing key as NotFound rather than NoSuchKey
+		var notFound *s3Types.NotFound
+		var noSuchKey *s3Types.NoSuchKey
+		if errors.As(err, &notFound) || errors.As(err, &noSuchKey) {
+			return nil, fmt.Errorf("%w: %s", storage.ErrNotFound, key)
+		}
 		return nil, err
 	}
-	defer result.Body.Close()
 
-	return io.ReadAll(result.Body)
+	info := &storage.ObjectInfo{
+		Size:        aws.ToInt64(result.ContentLength),
+		ContentType: aws.ToString(result.ContentType),
+		ETag:        aws.ToString(result.ETag),
+	}
+	if result.LastModified != nil {
+		info.LastModified = result.LastModified.UTC()
+	}
+	return info, nil
 }
 
-func (c *Client) PutObject(ctx context.Context, key string, body io.Reader) error {
-	_, err := c.s3Client.PutObject(ctx, &s3.PutObjectInput{
+// PutObject uploads an object. The content type is what S3 serves it with, including through
+// presigned GET redirects, so it's set whenever the caller knows it.
+func (c *Client) PutObject(ctx context.Context, key string, body io.Reader, contentType string) error {
+	input := &s3.PutObjectInput{
 		Bucket: aws.String(c.bucket),
 		Key:    aws.String(key),
 		Body:   body,
-	})
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	_, err := c.s3Client.PutObject(ctx, input)
 	return err
 }
 
+// S3 copies objects of up to 5 GB in one request; larger ones are copied part by part
+var (
+	maxCopyObjectSize int64 = 5 << 30
+	copyPartSize      int64 = 512 << 20
+)
+
+// CopyObject copies an object server-side, so its bytes never pass through MediaFlow. Objects
+// larger than a single copy allows are copied with a multipart upload of UploadPartCopy parts.
+func (c *Client) CopyObject(ctx context.Context, from, to string) error {
+	info, err := c.HeadObject(ctx, from)
+	if err != nil {
+		return err
+	}
+	source := c.bucket + "/" + url.PathEscape(from)
+	if info.Size <= maxCopyObjectSize {
+		_, err := c.s3Client.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(c.bucket),
+			Key:        aws.String(to),
+			CopySource: aws.String(source),
+		})
+		return err
+	}
+
+	headers := map[string]string{}
+	if info.ContentType != "" {
+		headers["Content-Type"] = info.ContentType
+	}
+	uploadID, err := c.CreateMultipartUpload(ctx, to, headers)
+	if err != nil {
+		return err
+	}
+	// Parts stay within the 10,000 part limit
+	partSize := max(copyPartSize, (info.Size+9999)/10000)
+	var parts []PartInfo
+	for offset := int64(0); offset < info.Size; offset += partSize {
+		partNumber := int32(len(parts) + 1)
+		result, err := c.s3Client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:          aws.String(c.bucket),
+			Key:             aws.String(to),
+			UploadId:        aws.String(uploadID),
+			PartNumber:      aws.Int32(partNumber),
+			CopySource:      aws.String(source),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", offset, min(offset+partSize, info.Size)-1)),
+		})
+		if err != nil {
+			_ = c.AbortMultipartUpload(ctx, to, uploadID)
+			return fmt.Errorf("failed to copy part %d: %w", partNumber, err)
+		}
+		parts = append(parts, PartInfo{PartNumber: int(partNumber), ETag: aws.ToString(result.CopyPartResult.ETag)})
+	}
+	if err := c.CompleteMultipartUpload(ctx, to, uploadID, parts); err != nil {
+		_ = c.AbortMultipartUpload(ctx, to, uploadID)
+		return err
+	}
+	return nil
+}
+
 // PresignPutObject generates a presigned URL for PUT operations
 func (c *Client) PresignPutObject(ctx context.Context, key string, expires time.Duration, headers map[string]string) (string, error) {
 	input := &s3.PutObjectInput{
@@ -114,6 +249,23 @@ func (c *Client) PresignPutObject(ctx context.Context, key string, expires time.
 	return request.URL, nil
 }
 
+// PresignGetObject generates a presigned URL for GET operations.
+// Responses carry Cache-Control: private so shared caches don't keep them.
+func (c *Client) PresignGetObject(ctx context.Context, key string, expires time.Duration) (string, error) {
+	request, err := c.presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket:               aws.String(c.bucket),
+		Key:                  aws.String(key),
+		ResponseCacheControl: aws.String("private"),
+	}, func(opts *s3.PresignOptions) {
+		opts.Expires = expires
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return request.URL, nil
+}
+
 // CreateMultipartUpload creates a multipart upload and returns the upload ID
 func (c *Client) CreateMultipartUpload(ctx context.Context, key string, headers map[string]string) (string, error) {
 	input := &s3.CreateMultipartUploadInput{
@@ -189,6 +341,36 @@ func (c *Client) AbortMultipartUpload(ctx context.Context, key, uploadID string)
 	return err
 }
 
+// ListParts returns the parts uploaded so far for a multipart upload.
+// Returns storage.ErrNoSuchUpload if the upload ID is unknown for the given key.
+func (c *Client) ListParts(ctx context.Context, key, uploadID string) ([]PartInfo, error) {
+	input := &s3.ListPartsInput{
+		Bucket:   aws.String(c.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	}
+
+	var parts []PartInfo
+	paginator := s3.NewListPartsPaginator(c.s3Client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			var noSuchUpload *s3Types.NoSuchUpload
+			if errors.As(err, &noSuchUpload) {
+				return nil, storage.ErrNoSuchUpload
+			}
+			return nil, err
+		}
+		for _, part := range page.Parts {
+			parts = append(parts, PartInfo{
+				ETag:       aws.ToString(part.ETag),
+				PartNumber: int(aws.ToInt32(part.PartNumber)),
+			})
+		}
+	}
+	return parts, nil
+}
+
 // DeleteObject deletes a single object from S3/R2 by key.
 func (c *Client) DeleteObject(ctx context.Context, key string) error {
 	_, err := c.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
@@ -218,7 +400,4 @@ func (c *Client) ListByPrefix(ctx context.Context, prefix string) ([]string, err
 }
 
 // PartInfo represents a completed part for multipart upload
-type PartInfo struct {
-	ETag       string
-	PartNumber int
-}
+type PartInfo = storage.PartInfo
@@ -2,7 +2,11 @@ package s3
 
 import (
 	"context"
+	"errors"
 	"testing"
+
+	"mediaflow/internal/s3/s3test"
+	"mediaflow/internal/storage"
 )
 
 func TestPartInfo_Struct(t *testing.T) {
@@ -59,4 +63,47 @@ func TestClient_AbortMultipartUpload_Interface(t *testing.T) {
 // 3. Or using localstack/minio for testing
 //
 // The main logic testing is covered in the service layer tests
-// which use the S3Client interface with mocks.
\ No newline at end of file
+// which use the S3Client interface with mocks.
+func newTestClient(t *testing.T, server *s3test.Server) *Client {
+	t.Helper()
+	client, err := NewClient(context.Background(), "us-east-1", server.Bucket, "access", "secret", server.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestClient_CopyObject(t *testing.T) {
+	server := s3test.NewServer(t, "media")
+	client := newTestClient(t, server)
+	ctx := context.Background()
+	data := []byte("0123456789abcdefghij")
+	server.Put("originals/ab/photo one.jpg", data, "image/jpeg")
+
+	if err := client.CopyObject(ctx, "originals/ab/photo one.jpg", "originals/cd/photo one.jpg"); err != nil {
+		t.Fatalf("CopyObject failed: %v", err)
+	}
+	if copied := server.Get("originals/cd/photo one.jpg"); copied == nil || string(copied.Data) != string(data) || copied.ContentType != "image/jpeg" {
+		t.Errorf("Unexpected copy %+v", copied)
+	}
+	if server.Requests["CopyObject"] != 1 || server.Requests["PutObject"] != 0 {
+		t.Errorf("Expected a single server-side copy, got %v", server.Requests)
+	}
+
+	// Objects over the single copy limit are copied part by part
+	defer func(size, part int64) { maxCopyObjectSize, copyPartSize = size, part }(maxCopyObjectSize, copyPartSize)
+	maxCopyObjectSize, copyPartSize = 10, 8
+	if err := client.CopyObject(ctx, "originals/ab/photo one.jpg", "originals/ef/photo.jpg"); err != nil {
+		t.Fatalf("CopyObject failed: %v", err)
+	}
+	if copied := server.Get("originals/ef/photo.jpg"); copied == nil || string(copied.Data) != string(data) || copied.ContentType != "image/jpeg" {
+		t.Errorf("Unexpected multipart copy %+v", copied)
+	}
+	if server.Requests["UploadPartCopy"] != 3 || server.Requests["CompleteMultipartUpload"] != 1 {
+		t.Errorf("Expected 3 part copies and a completion, got %v", server.Requests)
+	}
+
+	if err := client.CopyObject(ctx, "originals/missing.jpg", "originals/other.jpg"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing source, got %v", err)
+	}
+}
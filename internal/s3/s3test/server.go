@@ -0,0 +1,219 @@
+// Package s3test is an in-memory S3 server for tests of code running against the S3 client. It
+// speaks just enough of the API for objects, listings, server-side copies and multipart uploads.
+package s3test
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Object is a stored object
+type Object struct {
+	Data        []byte
+	ContentType string
+}
+
+// Server is an in-memory bucket served over HTTP with path-style addressing
+type Server struct {
+	URL    string
+	Bucket string
+
+	mu      sync.Mutex
+	objects map[string]*Object
+	uploads map[string]map[int][]byte
+	// Requests counts requests by operation, such as "CopyObject" or "UploadPartCopy"
+	Requests map[string]int
+}
+
+// NewServer starts a server for bucket, closed when the test ends
+func NewServer(t *testing.T, bucket string) *Server {
+	t.Helper()
+	s := &Server{Bucket: bucket, objects: map[string]*Object{}, uploads: map[string]map[int][]byte{}, Requests: map[string]int{}}
+	server := httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(server.Close)
+	s.URL = server.URL
+	return s
+}
+
+// Put stores an object directly
+func (s *Server) Put(key string, data []byte, contentType string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = &Object{Data: data, ContentType: contentType}
+}
+
+// Get returns a stored object, or nil
+func (s *Server) Get(key string) *Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.objects[key]
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+s.Bucket)
+	if !ok {
+		http.Error(w, "unknown bucket", http.StatusNotFound)
+		return
+	}
+	key = strings.TrimPrefix(key, "/")
+	query := r.URL.Query()
+	copySource := r.Header.Get("X-Amz-Copy-Source")
+
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		s.Requests["ListObjectsV2"]++
+		s.list(w, query.Get("prefix"))
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		s.Requests[map[bool]string{true: "HeadObject", false: "GetObject"}[r.Method == http.MethodHead]]++
+		obj := s.objects[key]
+		if obj == nil {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.Data)))
+		w.Header().Set("Content-Type", obj.ContentType)
+		w.Header().Set("ETag", etag(obj.Data))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(obj.Data)
+		}
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		s.Requests["CreateMultipartUpload"]++
+		uploadID := strconv.Itoa(len(s.uploads) + 1)
+		s.uploads[uploadID] = map[int][]byte{}
+		s.uploads[uploadID][0] = []byte(r.Header.Get("Content-Type"))
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: s.Bucket, Key: key, UploadId: uploadID})
+	case r.Method == http.MethodPut && query.Has("uploadId") && copySource != "":
+		s.Requests["UploadPartCopy"]++
+		parts := s.uploads[query.Get("uploadId")]
+		src := s.source(copySource)
+		if parts == nil || src == nil {
+			writeError(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("X-Amz-Copy-Source-Range"), "bytes=%d-%d", &start, &end); err != nil || end >= len(src.Data) {
+			writeError(w, http.StatusBadRequest, "InvalidArgument")
+			return
+		}
+		partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+		parts[partNumber] = src.Data[start : end+1]
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CopyPartResult"`
+			ETag    string
+		}{ETag: etag(parts[partNumber])})
+	case r.Method == http.MethodPut && copySource != "":
+		s.Requests["CopyObject"]++
+		src := s.source(copySource)
+		if src == nil {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		s.objects[key] = &Object{Data: src.Data, ContentType: src.ContentType}
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CopyObjectResult"`
+			ETag    string
+		}{ETag: etag(src.Data)})
+	case r.Method == http.MethodPut:
+		s.Requests["PutObject"]++
+		data, _ := io.ReadAll(r.Body)
+		s.objects[key] = &Object{Data: data, ContentType: r.Header.Get("Content-Type")}
+		w.Header().Set("ETag", etag(data))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		s.Requests["CompleteMultipartUpload"]++
+		parts := s.uploads[query.Get("uploadId")]
+		if parts == nil {
+			writeError(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		var data []byte
+		for i := 1; i < len(parts); i++ {
+			data = append(data, parts[i]...)
+		}
+		s.objects[key] = &Object{Data: data, ContentType: string(parts[0])}
+		delete(s.uploads, query.Get("uploadId"))
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Key     string
+			ETag    string
+		}{Key: key, ETag: etag(data)})
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		s.Requests["AbortMultipartUpload"]++
+		delete(s.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete:
+		s.Requests["DeleteObject"]++
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// source returns the object an x-amz-copy-source header of this bucket points to
+func (s *Server) source(header string) *Object {
+	source, err := url.PathUnescape(strings.TrimPrefix(header, "/"))
+	if err != nil {
+		return nil
+	}
+	key, ok := strings.CutPrefix(source, s.Bucket+"/")
+	if !ok {
+		return nil
+	}
+	return s.objects[key]
+}
+
+func (s *Server) list(w http.ResponseWriter, prefix string) {
+	type content struct{ Key string }
+	result := struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		Prefix      string
+		IsTruncated bool
+		Contents    []content
+	}{Name: s.Bucket, Prefix: prefix}
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, content{Key: key})
+		}
+	}
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+	writeXML(w, result)
+}
+
+func writeXML(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+	}{Code: code})
+}
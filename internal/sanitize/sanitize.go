@@ -0,0 +1,145 @@
+// Package sanitize removes privacy-sensitive metadata from uploaded originals without re-encoding
+// them: GPS positions, camera and lens serial numbers, owner names, maker notes and XMP packets.
+// Everything else, including the ICC profile and the EXIF orientation, is kept byte for byte.
+package sanitize
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// ErrMalformed is returned for JPEG and PNG files whose structure can't be followed
+var ErrMalformed = errors.New("malformed image")
+
+var (
+	jpegSOI      = []byte{0xFF, 0xD8}
+	pngSignature = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1A, '\n'}
+
+	exifHeader        = []byte("Exif\x00\x00")
+	xmpHeader         = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	xmpExtendedHeader = []byte("http://ns.adobe.com/xmp/extension/\x00")
+)
+
+// pngPrivateKeywords are the text chunk keywords carrying metadata: XMP, and the hex dumps of EXIF,
+// XMP and IPTC that ImageMagick and exiftool write as "Raw profile type" chunks
+var pngPrivateKeywords = map[string]bool{
+	"XML:com.adobe.xmp":     true,
+	"Raw profile type exif": true,
+	"Raw profile type APP1": true,
+	"Raw profile type xmp":  true,
+	"Raw profile type iptc": true,
+}
+
+// Formats are the content types Image rewrites; it returns other formats unchanged
+var Formats = []string{"image/jpeg", "image/png"}
+
+// Image returns data without GPS, serial number and XMP metadata, and whether anything was removed.
+// JPEG and PNG are rewritten; other formats are returned unchanged.
+func Image(data []byte) ([]byte, bool, error) {
+	switch {
+	case bytes.HasPrefix(data, jpegSOI):
+		return sanitizeJPEG(data)
+	case bytes.HasPrefix(data, pngSignature):
+		return sanitizePNG(data)
+	default:
+		return data, false, nil
+	}
+}
+
+// sanitizeJPEG rewrites the APP1 EXIF segment and drops XMP segments. Segments after the start of
+// scan are image data and copied as they are.
+func sanitizeJPEG(data []byte) ([]byte, bool, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, jpegSOI...)
+	changed := false
+
+	pos := len(jpegSOI)
+	for {
+		if pos+4 > len(data) || data[pos] != 0xFF {
+			return nil, false, ErrMalformed
+		}
+		marker := data[pos+1]
+		if marker == 0xFF {
+			// Fill byte before a marker
+			pos++
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, false, ErrMalformed
+		}
+		segment, payload := data[pos:end], data[pos+4:end]
+
+		if marker == 0xDA {
+			// Start of scan: the rest is entropy-coded data and trailing markers
+			out = append(out, data[pos:]...)
+			return out, changed, nil
+		}
+		if marker == 0xE1 {
+			switch {
+			case bytes.HasPrefix(payload, xmpHeader), bytes.HasPrefix(payload, xmpExtendedHeader):
+				changed = true
+				pos = end
+				continue
+			case bytes.HasPrefix(payload, exifHeader):
+				tiff := bytes.Clone(payload[len(exifHeader):])
+				removed, err := sanitizeTIFF(tiff)
+				if err != nil {
+					// EXIF we can't follow might hide anything; drop it whole
+					changed = true
+					pos = end
+					continue
+				}
+				if removed {
+					changed = true
+					out = append(out, segment[:4+len(exifHeader)]...)
+					out = append(out, tiff...)
+					pos = end
+					continue
+				}
+			}
+		}
+		out = append(out, segment...)
+		pos = end
+	}
+}
+
+// sanitizePNG drops the eXIf chunk and text chunks with XMP or raw EXIF, XMP and IPTC profiles.
+// Chunks are copied whole, so their CRCs stay valid.
+func sanitizePNG(data []byte) ([]byte, bool, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+	changed := false
+
+	pos := len(pngSignature)
+	for pos < len(data) {
+		if pos+12 > len(data) {
+			return nil, false, ErrMalformed
+		}
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, false, ErrMalformed
+		}
+		chunkType, body := string(data[pos+4:pos+8]), data[pos+8:pos+8+length]
+
+		if chunkType == "eXIf" || isPrivateText(chunkType, body) {
+			changed = true
+		} else {
+			out = append(out, data[pos:end]...)
+		}
+		pos = end
+	}
+	return out, changed, nil
+}
+
+// isPrivateText reports whether a chunk is a tEXt, zTXt or iTXt chunk whose keyword is in pngPrivateKeywords
+func isPrivateText(chunkType string, body []byte) bool {
+	if chunkType != "tEXt" && chunkType != "zTXt" && chunkType != "iTXt" {
+		return false
+	}
+	keyword, _, ok := bytes.Cut(body, []byte{0})
+	return ok && pngPrivateKeywords[string(keyword)]
+}
@@ -0,0 +1,165 @@
+package sanitize
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+var le = binary.LittleEndian
+
+// testTIFF builds little-endian EXIF with a camera make and orientation in IFD0, a serial number
+// and capture date in the EXIF IFD, and a GPS IFD:
+//
+//	  8 IFD0: Make, Orientation, ExifIFD, GPSInfo
+//	 62 "Apple"
+//	 68 EXIF IFD: BodySerialNumber, DateTimeOriginal
+//	 98 "SN1234567"
+//	108 "2024:01:02 03:04:05"
+//	128 GPS IFD: GPSLatitudeRef, GPSLatitude
+//	158 latitude rationals
+func testTIFF() []byte {
+	data := make([]byte, 182)
+	copy(data, "II")
+	le.PutUint16(data[2:], 42)
+	le.PutUint32(data[4:], 8)
+
+	writeIFD := func(offset int, entries [][4]uint32) {
+		le.PutUint16(data[offset:], uint16(len(entries)))
+		for i, e := range entries {
+			entry := data[offset+2+12*i:]
+			le.PutUint16(entry, uint16(e[0]))
+			le.PutUint16(entry[2:], uint16(e[1]))
+			le.PutUint32(entry[4:], e[2])
+			le.PutUint32(entry[8:], e[3])
+		}
+	}
+	writeIFD(8, [][4]uint32{{0x010F, 2, 6, 62}, {0x0112, 3, 1, 6}, {tagExifIFD, 4, 1, 68}, {tagGPSIFD, 4, 1, 128}})
+	copy(data[62:], "Apple\x00")
+	writeIFD(68, [][4]uint32{{0xA431, 2, 10, 98}, {0x9003, 2, 20, 108}})
+	copy(data[98:], "SN1234567\x00")
+	copy(data[108:], "2024:01:02 03:04:05\x00")
+	writeIFD(128, [][4]uint32{{0x0001, 2, 2, 'N'}, {0x0002, 5, 3, 158}})
+	for i, v := range []uint32{37, 1, 46, 1, 1234, 100} {
+		le.PutUint32(data[158+4*i:], v)
+	}
+	return data
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+func pngChunk(chunkType string, body []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(body)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, body...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+func tagsOf(t *testing.T, data []byte, offset uint32) map[uint16]bool {
+	t.Helper()
+	tr := &tiff{data: data, order: le}
+	entries, err := tr.entries(offset)
+	if err != nil {
+		t.Fatalf("Failed to read IFD at %d: %v", offset, err)
+	}
+	tags := make(map[uint16]bool)
+	for _, e := range entries {
+		tags[e.tag] = true
+	}
+	return tags
+}
+
+func TestImage_JPEG(t *testing.T) {
+	icc := jpegSegment(0xE2, []byte("ICC_PROFILE\x00\x01\x01profile"))
+	scan := []byte{0xFF, 0xDA, 0x00, 0x04, 0x01, 0x02, 0xAB, 0xCD, 0xFF, 0xD9}
+	var original []byte
+	original = append(original, jpegSOI...)
+	original = append(original, jpegSegment(0xE1, append(bytes.Clone(exifHeader), testTIFF()...))...)
+	original = append(original, icc...)
+	original = append(original, jpegSegment(0xE1, append(bytes.Clone(xmpHeader), "<x:xmpmeta>GPS</x:xmpmeta>"...))...)
+	original = append(original, scan...)
+
+	sanitized, changed, err := Image(original)
+	if err != nil || !changed {
+		t.Fatalf("Image = changed %t, %v; expected a change", changed, err)
+	}
+
+	for _, gone := range [][]byte{[]byte("SN1234567"), []byte("xmpmeta"), {46, 0, 0, 0, 1, 0, 0, 0, 0xD2, 0x04}} {
+		if bytes.Contains(sanitized, gone) {
+			t.Errorf("Expected %q to be removed", gone)
+		}
+	}
+	for _, kept := range [][]byte{[]byte("Apple"), []byte("2024:01:02 03:04:05"), icc, scan} {
+		if !bytes.Contains(sanitized, kept) {
+			t.Errorf("Expected %q to be kept", kept)
+		}
+	}
+
+	exif := sanitized[len(jpegSOI)+4+len(exifHeader):]
+	ifd0 := tagsOf(t, exif, 8)
+	if !ifd0[0x010F] || !ifd0[0x0112] || !ifd0[tagExifIFD] || ifd0[tagGPSIFD] {
+		t.Errorf("Expected IFD0 to keep Make, Orientation and the EXIF IFD without GPS, got %v", ifd0)
+	}
+	exifIFD := tagsOf(t, exif, 68)
+	if !exifIFD[0x9003] || exifIFD[0xA431] {
+		t.Errorf("Expected the EXIF IFD to keep DateTimeOriginal without BodySerialNumber, got %v", exifIFD)
+	}
+
+	// Sanitizing again changes nothing
+	if again, changed, err := Image(sanitized); err != nil || changed || !bytes.Equal(again, sanitized) {
+		t.Errorf("Expected a sanitized image to be left alone, got changed %t, %v", changed, err)
+	}
+}
+
+func TestImage_JPEGWithMalformedEXIF(t *testing.T) {
+	var original []byte
+	original = append(original, jpegSOI...)
+	original = append(original, jpegSegment(0xE1, append(bytes.Clone(exifHeader), "II*\x00\xff\xff\x00\x00GPS"...))...)
+	original = append(original, 0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xD9)
+
+	sanitized, changed, err := Image(original)
+	if err != nil || !changed || bytes.Contains(sanitized, exifHeader) {
+		t.Errorf("Expected EXIF that can't be followed to be dropped, got %q, %t, %v", sanitized, changed, err)
+	}
+}
+
+func TestImage_PNG(t *testing.T) {
+	ihdr := pngChunk("IHDR", make([]byte, 13))
+	iccp := pngChunk("iCCP", []byte("sRGB\x00\x00profile"))
+	title := pngChunk("tEXt", []byte("Title\x00Holiday"))
+	iend := pngChunk("IEND", nil)
+	var original []byte
+	original = append(original, pngSignature...)
+	original = append(original, ihdr...)
+	original = append(original, iccp...)
+	original = append(original, pngChunk("eXIf", testTIFF())...)
+	original = append(original, pngChunk("iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00<x:xmpmeta/>"))...)
+	original = append(original, pngChunk("tEXt", []byte("Raw profile type exif\x00\nexif\n     182\n4949..."))...)
+	original = append(original, pngChunk("zTXt", []byte("Raw profile type iptc\x00\x00x\x9c..."))...)
+	original = append(original, title...)
+	original = append(original, iend...)
+
+	sanitized, changed, err := Image(original)
+	if err != nil || !changed {
+		t.Fatalf("Image = changed %t, %v; expected a change", changed, err)
+	}
+	expected := bytes.Join([][]byte{pngSignature, ihdr, iccp, title, iend}, nil)
+	if !bytes.Equal(sanitized, expected) {
+		t.Errorf("Expected only eXIf, XMP and raw profiles to be dropped, got %q", sanitized)
+	}
+}
+
+func TestImage_OtherFormats(t *testing.T) {
+	webp := []byte("RIFF\x00\x00\x00\x00WEBPVP8 ")
+	if out, changed, err := Image(webp); err != nil || changed || !bytes.Equal(out, webp) {
+		t.Errorf("Expected other formats to be returned unchanged, got %q, %t, %v", out, changed, err)
+	}
+	if _, _, err := Image(append(bytes.Clone(jpegSOI), 0xFF, 0xE1, 0xFF)); err == nil {
+		t.Error("Expected an error for a truncated JPEG")
+	}
+}
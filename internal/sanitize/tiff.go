@@ -0,0 +1,170 @@
+package sanitize
+
+import "encoding/binary"
+
+// EXIF tags that point to sub-IFDs
+const (
+	tagExifIFD = 0x8769
+	tagGPSIFD  = 0x8825
+)
+
+// privateTags are removed from IFD0 and the EXIF IFD. GPS is removed with its whole IFD.
+var privateTags = map[uint16]bool{
+	tagGPSIFD: true,
+	0x927C:    true, // MakerNote: vendor data, often including the body serial number
+	0xA430:    true, // CameraOwnerName
+	0xA431:    true, // BodySerialNumber
+	0xA435:    true, // LensSerialNumber
+	0xC62F:    true, // CameraSerialNumber (DNG)
+}
+
+// typeSizes are the byte sizes of TIFF field types
+var typeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// tiff is an EXIF TIFF structure edited in place
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// ifdEntry is one 12-byte IFD entry
+type ifdEntry struct {
+	tag, typ uint16
+	count    uint32
+	value    uint32 // The value itself when it fits in 4 bytes, otherwise its offset
+}
+
+// sanitizeTIFF removes privateTags from IFD0 and the EXIF IFD of data in place, zeroing their
+// values and the GPS IFD so none of it is left in the file. Reports whether anything was removed.
+func sanitizeTIFF(data []byte) (bool, error) {
+	if len(data) < 8 {
+		return false, ErrMalformed
+	}
+	t := &tiff{data: data}
+	switch string(data[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return false, ErrMalformed
+	}
+	if t.order.Uint16(data[2:]) != 42 {
+		return false, ErrMalformed
+	}
+
+	ifd0 := t.order.Uint32(data[4:])
+	entries, err := t.entries(ifd0)
+	if err != nil {
+		return false, err
+	}
+	removed := false
+	for _, entry := range entries {
+		if entry.tag != tagExifIFD {
+			continue
+		}
+		exifRemoved, err := t.removeTags(entry.value)
+		if err != nil {
+			return false, err
+		}
+		removed = removed || exifRemoved
+	}
+	ifd0Removed, err := t.removeTags(ifd0)
+	if err != nil {
+		return false, err
+	}
+	return removed || ifd0Removed, nil
+}
+
+// entries reads the entries of the IFD at offset
+func (t *tiff) entries(offset uint32) ([]ifdEntry, error) {
+	start := int(offset)
+	if offset > uint32(len(t.data)) || start+2 > len(t.data) {
+		return nil, ErrMalformed
+	}
+	count := int(t.order.Uint16(t.data[start:]))
+	if start+2+12*count+4 > len(t.data) {
+		return nil, ErrMalformed
+	}
+	entries := make([]ifdEntry, count)
+	for i := range entries {
+		e := t.data[start+2+12*i:]
+		entries[i] = ifdEntry{
+			tag:   t.order.Uint16(e),
+			typ:   t.order.Uint16(e[2:]),
+			count: t.order.Uint32(e[4:]),
+			value: t.order.Uint32(e[8:]),
+		}
+	}
+	return entries, nil
+}
+
+// removeTags drops privateTags from the IFD at offset, compacting the remaining entries and
+// zeroing what was freed. Reports whether anything was removed.
+func (t *tiff) removeTags(offset uint32) (bool, error) {
+	entries, err := t.entries(offset)
+	if err != nil {
+		return false, err
+	}
+	start := int(offset)
+	next := t.order.Uint32(t.data[start+2+12*len(entries):])
+
+	kept := entries[:0:0]
+	for _, entry := range entries {
+		if !privateTags[entry.tag] {
+			kept = append(kept, entry)
+			continue
+		}
+		if entry.tag == tagGPSIFD {
+			if err := t.zeroIFD(entry.value); err != nil {
+				return false, err
+			}
+		} else if err := t.zeroValue(entry); err != nil {
+			return false, err
+		}
+	}
+	if len(kept) == len(entries) {
+		return false, nil
+	}
+
+	t.order.PutUint16(t.data[start:], uint16(len(kept)))
+	for i, entry := range kept {
+		e := t.data[start+2+12*i:]
+		t.order.PutUint16(e, entry.tag)
+		t.order.PutUint16(e[2:], entry.typ)
+		t.order.PutUint32(e[4:], entry.count)
+		t.order.PutUint32(e[8:], entry.value)
+	}
+	tail := t.data[start+2+12*len(kept):]
+	t.order.PutUint32(tail, next)
+	clear(tail[4 : 4+12*(len(entries)-len(kept))])
+	return true, nil
+}
+
+// zeroValue zeroes an entry's value when it's stored outside the entry
+func (t *tiff) zeroValue(entry ifdEntry) error {
+	size := uint64(typeSizes[entry.typ]) * uint64(entry.count)
+	if size <= 4 {
+		return nil
+	}
+	if uint64(entry.value)+size > uint64(len(t.data)) {
+		return ErrMalformed
+	}
+	clear(t.data[entry.value : uint64(entry.value)+size])
+	return nil
+}
+
+// zeroIFD zeroes the IFD at offset and every value it stores outside its entries
+func (t *tiff) zeroIFD(offset uint32) error {
+	entries, err := t.entries(offset)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := t.zeroValue(entry); err != nil {
+			return err
+		}
+	}
+	clear(t.data[offset : int(offset)+2+12*len(entries)+4])
+	return nil
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"gopkg.in/h2non/bimg.v1"
+
+	"mediaflow/internal/config"
+)
+
+// ErrUnsupportedFormat is returned when the linked libvips can't encode a requested output format
+var ErrUnsupportedFormat = errors.New("output format not supported by this libvips build")
+
+// outputTypes maps output formats to the libvips types that encode them. jxl has no entry:
+// bimg v1.1.9 can't ask libvips for JPEG XL, so no build encodes it until bimg does.
+var outputTypes = map[string]bimg.ImageType{
+	"webp": bimg.WEBP,
+	"jpeg": bimg.JPEG,
+	"jpg":  bimg.JPEG,
+	"png":  bimg.PNG,
+	"avif": bimg.AVIF,
+}
+
+// CanEncode reports whether the linked libvips can encode thumbnails in format
+func CanEncode(format string) bool {
+	t, ok := outputTypes[format]
+	return ok && bimg.IsTypeSupportedSave(t)
+}
+
+// outputType returns the libvips type for format, or ErrUnsupportedFormat
+func outputType(format string) (bimg.ImageType, error) {
+	if !CanEncode(format) {
+		return bimg.UNKNOWN, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+	return outputTypes[format], nil
+}
+
+// CheckOutputFormats returns an error listing every profile format the linked libvips can't encode:
+// convert_to and variant formats. convert_to: auto only needs its JPEG fallback, as negotiation
+// skips formats the build can't encode. Run at startup and on reload so a profile never quietly
+// serves the wrong format.
+func CheckOutputFormats(storageConfig *config.StorageConfig) error {
+	var problems []string
+	for _, name := range slices.Sorted(maps.Keys(storageConfig.Profiles)) {
+		profile := storageConfig.Profiles[name]
+		if profile.Kind != "image" || profile.Abstract {
+			continue
+		}
+		if format := profile.OutputFormat(); format != "" && !CanEncode(format) {
+			problems = append(problems, fmt.Sprintf("profile '%s': convert_to %q", name, format))
+		}
+		for _, variant := range slices.Sorted(maps.Keys(profile.Variants)) {
+			if format := profile.Variants[variant].Format; format != "" && !CanEncode(format) {
+				problems = append(problems, fmt.Sprintf("profile '%s': variants.%s format %q", name, variant, format))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("libvips can't encode (bimg %s):\n  - %s", bimg.Version, strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
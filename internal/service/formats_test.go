@@ -0,0 +1,47 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"mediaflow/internal/config"
+)
+
+func TestCheckOutputFormats(t *testing.T) {
+	storageConfig := &config.StorageConfig{Profiles: map[string]config.Profile{
+		"avatar": {Kind: "image", ConvertTo: "avif"},
+		"photo":  {Kind: "image", ConvertTo: config.ConvertAuto},
+		"video":  {Kind: "video"},
+	}}
+	// AVIF needs libvips built with libheif, so the expectation follows the linked build
+	if err := CheckOutputFormats(storageConfig); CanEncode("avif") && err != nil {
+		t.Fatalf("Expected no problems, got %v", err)
+	} else if !CanEncode("avif") && (err == nil || !strings.Contains(err.Error(), `profile 'avatar': convert_to "avif"`)) {
+		t.Fatalf("Expected avif to be reported for a build without it, got %v", err)
+	}
+	delete(storageConfig.Profiles, "avatar")
+
+	storageConfig.Profiles["base"] = config.Profile{Kind: "image", Abstract: true, ConvertTo: "jxl"}
+	storageConfig.Profiles["banner"] = config.Profile{Kind: "image", ConvertTo: "jxl",
+		Variants: map[string]config.Variant{"og": {Width: 1200, Format: "jxl"}, "card": {Width: 600, Format: "png"}}}
+	err := CheckOutputFormats(storageConfig)
+	if err == nil {
+		t.Fatal("Expected an error for jxl")
+	}
+	for _, expected := range []string{`profile 'banner': convert_to "jxl"`, `profile 'banner': variants.og format "jxl"`} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q in %v", expected, err)
+		}
+	}
+	if strings.Contains(err.Error(), "'base'") || strings.Contains(err.Error(), "card") {
+		t.Errorf("Expected only banner's jxl formats to be reported, got %v", err)
+	}
+}
+
+func TestGenerateThumbnail_UnsupportedFormat(t *testing.T) {
+	s := &ImageService{}
+	if _, err := s.generateThumbnail(&config.Profile{}, []byte("image"), config.SizeSpec{Width: 256}, 90, "jxl"); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Expected ErrUnsupportedFormat instead of a JPEG fallback, got %v", err)
+	}
+}
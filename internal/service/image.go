@@ -2,99 +2,142 @@ package service
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"crypto/sha1"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"mime/multipart"
 	"net/http"
 	"path/filepath"
-	"strconv"
+	"slices"
 	"strings"
+	"time"
 
 	"gopkg.in/h2non/bimg.v1"
 
+	"mediaflow/internal/cache"
 	"mediaflow/internal/config"
+	"mediaflow/internal/objectkey"
 	"mediaflow/internal/s3"
+	"mediaflow/internal/sanitize"
+	"mediaflow/internal/storage"
 )
 
+// ErrSizeNotAllowed is returned when a requested thumbnail width is not enabled for the profile
+var ErrSizeNotAllowed = errors.New("size not allowed for this profile")
+
+// ErrQualityNotAllowed is returned when a requested thumbnail quality is not enabled for the profile
+var ErrQualityNotAllowed = errors.New("quality not allowed for this profile")
+
 type ImageService struct {
-	S3Client *s3.Client
-	config   *config.Config
+	Storage storage.Backend
+	Cache   *cache.Memory // Hot thumbnails, keyed by storage path (memory, then optional disk tier)
+	config  *config.Config
 }
 
 func NewImageService(cfg *config.Config) *ImageService {
-	s3Client, err := s3.NewClient(
-		context.Background(),
-		cfg.S3Region,
-		cfg.S3Bucket,
-		cfg.AWSAccessKey,
-		cfg.AWSSecretKey,
-		cfg.S3Endpoint,
-		cfg.PublicS3Endpoint,
-	)
+	backend, err := NewStorageBackend(cfg)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to create S3 client: %v", err))
+		panic(fmt.Sprintf("Failed to create storage backend: %v", err))
+	}
+
+	var disk cache.Tier
+	if cfg.DiskCacheDir != "" {
+		d, err := cache.NewDisk(cfg.DiskCacheDir, cfg.DiskCacheMB<<20)
+		if err != nil {
+			panic(fmt.Sprintf("Failed to open disk cache: %v", err))
+		}
+		fmt.Printf("💾 Disk cache enabled at %s (%d MB)\n", cfg.DiskCacheDir, cfg.DiskCacheMB)
+		disk = d
 	}
 
 	return &ImageService{
-		S3Client: s3Client,
-		config:   cfg,
+		Storage: backend,
+		Cache:   cache.NewMemory(cfg.MemoryCacheMB<<20, disk),
+		config:  cfg,
 	}
 }
 
-// buildStoragePath builds the storage path from template and filename
-// This derives the path where files are actually stored based on the upload template
-func (s *ImageService) buildStoragePath(template, filename string, enableSharding bool) string {
-	// Extract key_base and ext from filename
-	parts := strings.Split(filename, ".")
-	keyBase := parts[0]
-	ext := ""
-	if len(parts) > 1 {
-		ext = parts[len(parts)-1]
+// NewStorageBackend creates the storage driver selected by STORAGE_BACKEND
+func NewStorageBackend(cfg *config.Config) (storage.Backend, error) {
+	switch cfg.StorageBackend {
+	case storage.DriverLocal:
+		return storage.NewLocalBackend(cfg.LocalStoragePath, cfg.LocalStorageURL, cfg.LocalStorageSecret)
+	case storage.DriverS3, "":
+		return s3.NewClient(
+			context.Background(),
+			cfg.S3Region,
+			cfg.S3Bucket,
+			cfg.AWSAccessKey,
+			cfg.AWSSecretKey,
+			cfg.S3Endpoint,
+			cfg.PublicS3Endpoint,
+		)
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", cfg.StorageBackend)
 	}
+}
 
-	// Generate shard if sharding is enabled
-	shard := ""
-	if enableSharding {
-		shard = s.generateShard(keyBase)
-	}
+type contextKey int
 
-	// Template replacement for image retrieval
-	path := template
-	path = strings.ReplaceAll(path, "{key_base}", keyBase)
-	path = strings.ReplaceAll(path, "{ext}", ext)
+const tenantKey contextKey = iota
 
-	// Handle shard placeholders
-	if shard != "" {
-		path = strings.ReplaceAll(path, "{shard?}", shard)
-		path = strings.ReplaceAll(path, "{shard}", shard)
-	} else {
-		// Remove shard placeholders if no shard
-		path = strings.ReplaceAll(path, "/{shard?}", "")
-		path = strings.ReplaceAll(path, "{shard?}/", "")
-		path = strings.ReplaceAll(path, "{shard?}", "")
-	}
+// WithTenant returns a context whose reads find the originals uploaded for tenant. Tenants may
+// share key_bases, and originals of {tenant} templates are recorded per tenant.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey, tenant)
+}
+
+// tenantFromContext returns the tenant set with WithTenant, or ""
+func tenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey).(string)
+	return tenant
+}
 
-	return path
+// storageVars returns the storage_path template vars of an asset; filename may carry an extension
+func (s *ImageService) storageVars(ctx context.Context, profile *config.Profile, filename string) objectkey.Vars {
+	parts := strings.Split(filename, ".")
+	vars := objectkey.Vars{KeyBase: parts[0], Profile: profile.Name, Tenant: tenantFromContext(ctx)}
+	if len(parts) > 1 {
+		vars.Ext = parts[len(parts)-1]
+	}
+	vars.Shard = profile.Shard(vars.KeyBase)
+	return vars
 }
 
-// generateShard creates a shard from key_base using SHA1 hash (same logic as upload service)
-func (s *ImageService) generateShard(keyBase string) string {
-	hash := sha1.Sum([]byte(keyBase))
-	return fmt.Sprintf("%02x", hash[:1]) // First 2 hex characters
+// originalPath returns where an asset's original is stored: rendered from storage_path, or the
+// key recorded at upload time for templates with dates or UUIDs (same logic as upload service)
+func (s *ImageService) originalPath(ctx context.Context, profile *config.Profile, filename string) (string, error) {
+	path, err := objectkey.Resolve(ctx, s.Storage, profile.StoragePath, s.storageVars(ctx, profile, filename))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve original of %s: %w", filename, err)
+	}
+	return path, nil
 }
 
 func (s *ImageService) UploadImage(ctx context.Context, profile *config.Profile, imageData []byte, thumbType, imagePath string) error {
-	orig_path := s.buildStoragePath(profile.StoragePath, imagePath, profile.EnableSharding)
-	convertType := profile.ConvertTo
+	vars := s.storageVars(ctx, profile, imagePath)
+	orig_path := objectkey.Render(profile.StoragePath, vars)
+	baseName := strings.TrimSuffix(imagePath, filepath.Ext(imagePath))
+	thumbs, err := s.renditions(profile, baseName)
+	if err != nil {
+		return err
+	}
+	if profile.SanitizeOriginal {
+		if imageData, _, err = sanitize.Image(imageData); err != nil {
+			return fmt.Errorf("failed to sanitize original: %w", err)
+		}
+	}
 
 	// Upload original image in parallel with thumbnail generation
 	origUploadChan := make(chan error, 1)
 	go func() {
-		err := s.S3Client.PutObject(ctx, orig_path, bytes.NewReader(imageData))
+		err := s.Storage.PutObject(ctx, orig_path, bytes.NewReader(imageData), http.DetectContentType(imageData))
 		if err != nil {
-			origUploadChan <- fmt.Errorf("failed to upload original image to S3: %w", err)
+			origUploadChan <- fmt.Errorf("failed to upload original image to storage: %w", err)
 		} else {
 			origUploadChan <- nil
 		}
@@ -102,44 +145,37 @@ func (s *ImageService) UploadImage(ctx context.Context, profile *config.Profile,
 
 	// Generate and upload thumbnails in parallel
 	type thumbnailJob struct {
-		sizeStr string
-		data    []byte
-		path    string
-		err     error
+		name   string
+		data   []byte
+		path   string
+		format string
+		err    error
 	}
 
-	thumbJobs := make(chan thumbnailJob, len(profile.Sizes))
-	uploadErrors := make(chan error, len(profile.Sizes))
+	thumbJobs := make(chan thumbnailJob, len(thumbs))
+	uploadErrors := make(chan error, len(thumbs))
 
 	// Generate thumbnails in parallel
-	for _, sizeStr := range profile.Sizes {
-		go func(size string) {
-			sizeInt, err := strconv.Atoi(size)
+	for _, thumb := range thumbs {
+		go func(thumb *thumbnailSpec) {
+			thumbnailData, err := s.generateThumbnail(profile, imageData, thumb.size, thumb.quality, thumb.format)
 			if err != nil {
-				thumbJobs <- thumbnailJob{sizeStr: size, err: fmt.Errorf("invalid size format: %s", size)}
+				thumbJobs <- thumbnailJob{name: thumb.name, err: fmt.Errorf("failed to generate thumbnail for size %s: %w", thumb.name, err)}
 				return
 			}
 
-			thumbnailData, err := s.generateThumbnail(imageData, sizeInt, profile.Quality, convertType)
-			if err != nil {
-				thumbJobs <- thumbnailJob{sizeStr: size, err: fmt.Errorf("failed to generate thumbnail for size %s: %w", size, err)}
-				return
-			}
-
-			thumbSizePath := s.createThumbnailPathForSize(imagePath, size, convertType)
-			thumbFullPath := fmt.Sprintf("%s/%s", profile.ThumbFolder, thumbSizePath)
-
 			thumbJobs <- thumbnailJob{
-				sizeStr: size,
-				data:    thumbnailData,
-				path:    thumbFullPath,
-				err:     nil,
+				name:   thumb.name,
+				data:   thumbnailData,
+				path:   thumb.path,
+				format: thumb.format,
+				err:    nil,
 			}
-		}(sizeStr)
+		}(thumb)
 	}
 
 	// Upload thumbnails in parallel as they're generated
-	for i := 0; i < len(profile.Sizes); i++ {
+	for i := 0; i < len(thumbs); i++ {
 		go func() {
 			job := <-thumbJobs
 			if job.err != nil {
@@ -147,22 +183,32 @@ func (s *ImageService) UploadImage(ctx context.Context, profile *config.Profile,
 				return
 			}
 
-			err := s.S3Client.PutObject(ctx, job.path, bytes.NewReader(job.data))
+			err := s.Storage.PutObject(ctx, job.path, bytes.NewReader(job.data), "image/"+job.format)
 			if err != nil {
-				uploadErrors <- fmt.Errorf("failed to upload thumbnail for size %s: %w", job.sizeStr, err)
+				uploadErrors <- fmt.Errorf("failed to upload thumbnail for size %s: %w", job.name, err)
 			} else {
 				uploadErrors <- nil
 			}
 		}()
 	}
 
-	// Wait for original upload
+	// Wait for original upload; its key is only recorded once it is stored, so a failed upload
+	// never points reads at a missing original
 	if err := <-origUploadChan; err != nil {
 		return err
 	}
+	// The original is replaced, so nothing rendered from the old one may be served from cache,
+	// including on-demand sizes and transforms
+	defer func() {
+		s.Cache.Delete(orig_path)
+		s.Cache.DeleteFunc(profile.ThumbnailMatcher(baseName))
+	}()
+	if err := objectkey.Record(ctx, s.Storage, profile.StoragePath, vars, orig_path); err != nil {
+		return err
+	}
 
 	// Wait for all thumbnail uploads
-	for i := 0; i < len(profile.Sizes); i++ {
+	for i := 0; i < len(thumbs); i++ {
 		if err := <-uploadErrors; err != nil {
 			return err
 		}
@@ -171,23 +217,100 @@ func (s *ImageService) UploadImage(ctx context.Context, profile *config.Profile,
 	return nil
 }
 
-func (s *ImageService) generateThumbnail(imageData []byte, width, quality int, convertTo string) ([]byte, error) {
+// ProcessOriginal generates every profile size and variant from an uploaded original and writes them to thumb_folder.
+// With sanitize_original, the original is rewritten without its private metadata first.
+// Used by the post-upload pipeline for presigned uploads, which never pass through UploadImage.
+// Returns the storage keys written.
+func (s *ImageService) ProcessOriginal(ctx context.Context, profile *config.Profile, objectKey, keyBase string) ([]string, error) {
+	original, err := s.Storage.GetObject(ctx, objectKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get original image from storage: %w", err)
+	}
+
+	var written []string
+	if profile.SanitizeOriginal {
+		sanitized, changed, err := sanitize.Image(original)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sanitize original: %w", err)
+		}
+		if changed {
+			if err := s.Storage.PutObject(ctx, objectKey, bytes.NewReader(sanitized), http.DetectContentType(sanitized)); err != nil {
+				return nil, fmt.Errorf("failed to upload sanitized original: %w", err)
+			}
+			original = sanitized
+			written = append(written, objectKey)
+		}
+	}
+
+	if profile.ThumbFolder == "" {
+		return written, nil
+	}
+	thumbs, err := s.renditions(profile, keyBase)
+	if err != nil {
+		return written, err
+	}
+	for _, thumb := range thumbs {
+		imageData, err := s.generateThumbnail(profile, original, thumb.size, thumb.quality, thumb.format)
+		if err != nil {
+			return written, fmt.Errorf("failed to generate thumbnail for size %s: %w", thumb.name, err)
+		}
+
+		if err := s.Storage.PutObject(ctx, thumb.path, bytes.NewReader(imageData), "image/"+thumb.format); err != nil {
+			return written, fmt.Errorf("failed to upload thumbnail for size %s: %w", thumb.name, err)
+		}
+		// Don't keep serving a cached render of a replaced original
+		s.Cache.Delete(thumb.path)
+		written = append(written, thumb.path)
+	}
+
+	return written, nil
+}
+
+// bimgGravities maps profile gravities to libvips; smart is libvips' attention-based crop
+var bimgGravities = map[string]bimg.Gravity{
+	config.GravityCenter: bimg.GravityCentre,
+	config.GravityNorth:  bimg.GravityNorth,
+	config.GravityEast:   bimg.GravityEast,
+	config.GravitySouth:  bimg.GravitySouth,
+	config.GravityWest:   bimg.GravityWest,
+	config.GravitySmart:  bimg.GravitySmart,
+}
+
+func (s *ImageService) generateThumbnail(profile *config.Profile, imageData []byte, size config.SizeSpec, quality int, convertTo string) ([]byte, error) {
 	options := bimg.Options{
-		Width:   width,
-		Quality: quality,
-	}
-
-	// Set output format
-	switch convertTo {
-	case "webp":
-		options.Type = bimg.WEBP
-	case "jpeg", "jpg":
-		options.Type = bimg.JPEG
-	case "png":
-		options.Type = bimg.PNG
-	default:
-		// Default to JPEG if format is unknown (fallback)
-		options.Type = bimg.JPEG
+		Width:         size.Width,
+		Height:        size.Height,
+		Quality:       quality,
+		StripMetadata: profile.StripsMetadata(),
+		NoAutoRotate:  !profile.AutoOrients(),
+	}
+
+	// Sizes with a height are fitted to the box, enlarging small originals so every thumbnail has
+	// the box's dimensions. fit: inside is libvips' default for a width and a height.
+	switch size.Fit {
+	case config.FitCover:
+		options.Crop = true
+		options.Enlarge = true
+		options.Gravity = bimgGravities[size.Gravity]
+	case config.FitContain:
+		options.Embed = true
+		options.Enlarge = true
+		options.Extend = bimg.ExtendBackground
+		r, g, b := size.Color()
+		options.Background = bimg.Color{R: r, G: g, B: b}
+	case config.FitFill:
+		options.Force = true
+	}
+
+	// Set output format. Profiles without convert_to get JPEG; unknown formats and those the
+	// libvips build can't encode are errors rather than JPEGs served under another Content-Type.
+	options.Type = bimg.JPEG
+	if convertTo != "" {
+		t, err := outputType(convertTo)
+		if err != nil {
+			return nil, err
+		}
+		options.Type = t
 	}
 
 	resizedData, err := bimg.NewImage(imageData).Process(options)
@@ -198,40 +321,293 @@ func (s *ImageService) generateThumbnail(imageData []byte, width, quality int, c
 	return resizedData, nil
 }
 
-func (s *ImageService) createThumbnailPathForSize(originalPath, size, newType string) string {
-	ext := fmt.Sprintf(".%s", newType)
-	origExt := filepath.Ext(originalPath)
-	if ext == "." {
-		ext = origExt
-	}
-	baseName := strings.TrimSuffix(originalPath, origExt)
-	return fmt.Sprintf("%s_%s%s", baseName, size, ext)
-}
-
 // GetImage gets the image from the S3 bucket
 func (s *ImageService) GetImage(ctx context.Context, profile *config.Profile, original bool, baseImageName, size string) ([]byte, error) {
 	var path string
 	if original {
-		path = s.buildStoragePath(profile.StoragePath, baseImageName, profile.EnableSharding)
+		var err error
+		if path, err = s.originalPath(ctx, profile, baseImageName); err != nil {
+			return nil, err
+		}
 	} else {
-		if size == "" && !original {
-			if profile.DefaultSize == "" {
-				return nil, fmt.Errorf("please specify a size, as `default_size` is not set for this configuration")
-			}
-			size = profile.DefaultSize
+		thumb, err := s.resolveThumbnail(profile, baseImageName, size, 0, "")
+		if err != nil {
+			return nil, err
 		}
-		// example -> folder/file_size.ext
-		path = fmt.Sprintf("%s/%s_%s.%s", profile.ThumbFolder, baseImageName, size, profile.ConvertTo)
+		path = thumb.path
 	}
 
-	imageData, err := s.S3Client.GetObject(ctx, path)
+	imageData, err := s.Storage.GetObject(ctx, path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get image from S3: %w", err)
+		return nil, fmt.Errorf("failed to get image from storage: %w", err)
 	}
 
 	return imageData, nil
 }
 
+// GetThumbnail gets a thumbnail and its storage metadata, rendering it from the original on a miss.
+// Thumbnails are served from the memory cache when hot; concurrent misses for the same thumbnail share one load.
+// Rendered thumbnails are written back to thumb_folder so later requests are plain reads.
+// A quality of 0 (or equal to the profile's) uses the profile quality; an empty format uses the profile's output format.
+func (s *ImageService) GetThumbnail(ctx context.Context, profile *config.Profile, baseImageName, size string, quality int, format string) ([]byte, *storage.ObjectInfo, error) {
+	thumb, err := s.resolveThumbnail(profile, baseImageName, size, quality, format)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entry, err := s.Cache.Do(thumb.path, func() (*cache.Entry, error) {
+		return s.loadThumbnail(ctx, profile, baseImageName, thumb)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return entry.Data, entry.Info, nil
+}
+
+// loadThumbnail reads a thumbnail from storage, or renders and writes it back if it doesn't exist yet
+func (s *ImageService) loadThumbnail(ctx context.Context, profile *config.Profile, baseImageName string, thumb *thumbnailSpec) (*cache.Entry, error) {
+	body, info, err := s.Storage.GetObjectStream(ctx, thumb.path)
+	if err == nil {
+		defer body.Close()
+		imageData, err := io.ReadAll(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image from storage: %w", err)
+		}
+		return &cache.Entry{Data: imageData, Info: info}, nil
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("failed to get image from storage: %w", err)
+	}
+
+	// Cache miss: render from the original
+	origPath, err := s.originalPath(ctx, profile, baseImageName)
+	if err != nil {
+		return nil, err
+	}
+	original, err := s.Storage.GetObject(ctx, origPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get original image from storage: %w", err)
+	}
+
+	imageData, err := s.generateThumbnail(profile, original, thumb.size, thumb.quality, thumb.format)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Storage.PutObject(ctx, thumb.path, bytes.NewReader(imageData), "image/"+thumb.format); err != nil {
+		// Non-fatal: serve the rendered image, it will be rendered again next time
+		fmt.Printf("Failed to cache thumbnail %s: %v\n", thumb.path, err)
+		return &cache.Entry{Data: imageData, Info: &storage.ObjectInfo{Size: int64(len(imageData))}}, nil
+	}
+	// Pick up the validators of the written-back object
+	info, err = s.Storage.HeadObject(ctx, thumb.path)
+	if err != nil {
+		info = &storage.ObjectInfo{Size: int64(len(imageData))}
+	}
+	return &cache.Entry{Data: imageData, Info: info}, nil
+}
+
+// StatThumbnail returns the storage metadata of a thumbnail without reading it.
+// Returns storage.ErrNotFound if the thumbnail has not been rendered yet.
+func (s *ImageService) StatThumbnail(ctx context.Context, profile *config.Profile, baseImageName, size string, quality int, format string) (*storage.ObjectInfo, error) {
+	thumb, err := s.resolveThumbnail(profile, baseImageName, size, quality, format)
+	if err != nil {
+		return nil, err
+	}
+	if entry, ok := s.Cache.Peek(thumb.path); ok {
+		return entry.Info, nil
+	}
+	return s.Storage.HeadObject(ctx, thumb.path)
+}
+
+// OpenOriginal opens an original for streaming. The caller must close the reader.
+func (s *ImageService) OpenOriginal(ctx context.Context, profile *config.Profile, baseImageName string) (io.ReadCloser, *storage.ObjectInfo, error) {
+	path, err := s.originalPath(ctx, profile, baseImageName)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, info, err := s.Storage.GetObjectStream(ctx, path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get image from storage: %w", err)
+	}
+	return body, info, nil
+}
+
+// OpenOriginalRange opens length bytes of an original starting at offset. The caller must close the reader.
+func (s *ImageService) OpenOriginalRange(ctx context.Context, profile *config.Profile, baseImageName string, offset, length int64) (io.ReadCloser, error) {
+	path, err := s.originalPath(ctx, profile, baseImageName)
+	if err != nil {
+		return nil, err
+	}
+	body, err := s.Storage.GetObjectRange(ctx, path, offset, length)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image range from storage: %w", err)
+	}
+	return body, nil
+}
+
+// StatOriginal returns the storage metadata of an original without reading it
+func (s *ImageService) StatOriginal(ctx context.Context, profile *config.Profile, baseImageName string) (*storage.ObjectInfo, error) {
+	path, err := s.originalPath(ctx, profile, baseImageName)
+	if err != nil {
+		return nil, err
+	}
+	return s.Storage.HeadObject(ctx, path)
+}
+
+// PresignOriginal returns a presigned GET URL for an original that expires after ttl
+func (s *ImageService) PresignOriginal(ctx context.Context, profile *config.Profile, baseImageName string, ttl time.Duration) (string, error) {
+	path, err := s.originalPath(ctx, profile, baseImageName)
+	if err != nil {
+		return "", err
+	}
+	if _, err := s.Storage.HeadObject(ctx, path); err != nil {
+		return "", err
+	}
+	return s.Storage.PresignGetObject(ctx, path, ttl)
+}
+
+// PresignThumbnail returns a presigned GET URL for a thumbnail that expires after ttl,
+// rendering and writing the thumbnail back first if it doesn't exist yet
+func (s *ImageService) PresignThumbnail(ctx context.Context, profile *config.Profile, baseImageName, size string, quality int, format string, ttl time.Duration) (string, error) {
+	thumb, err := s.resolveThumbnail(profile, baseImageName, size, quality, format)
+	if err != nil {
+		return "", err
+	}
+	if _, err := s.Storage.HeadObject(ctx, thumb.path); err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			return "", err
+		}
+		if _, _, err := s.GetThumbnail(ctx, profile, baseImageName, size, quality, format); err != nil {
+			return "", err
+		}
+	}
+	return s.Storage.PresignGetObject(ctx, thumb.path, ttl)
+}
+
+// TransformSpec is an on-the-fly transformation requested through a signed URL.
+// Zero values fall back to the original width and the profile's quality and output format.
+type TransformSpec struct {
+	Width   int
+	Quality int
+	Format  string
+}
+
+// Transform renders an original with spec. Results are kept in the thumbnail cache tiers but never
+// written to storage, since signed URLs can ask for any number of variants.
+func (s *ImageService) Transform(ctx context.Context, profile *config.Profile, baseImageName string, spec TransformSpec) ([]byte, *storage.ObjectInfo, error) {
+	if spec.Quality == 0 {
+		spec.Quality = profile.Quality
+	}
+	if spec.Format == "" {
+		spec.Format = profile.OutputFormat()
+	}
+	// Under the asset's thumbnail prefix so deleting the asset invalidates it
+	key := fmt.Sprintf("%s/%s_t_w%d_q%d.%s", profile.ThumbFolder, baseImageName, spec.Width, spec.Quality, spec.Format)
+
+	entry, err := s.Cache.Do(key, func() (*cache.Entry, error) {
+		origPath, err := s.originalPath(ctx, profile, baseImageName)
+		if err != nil {
+			return nil, err
+		}
+		original, err := s.Storage.GetObject(ctx, origPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get original image from storage: %w", err)
+		}
+		imageData, err := s.generateThumbnail(profile, original, config.SizeSpec{Width: spec.Width}, spec.Quality, spec.Format)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha1.Sum(imageData)
+		return &cache.Entry{Data: imageData, Info: &storage.ObjectInfo{
+			Size:        int64(len(imageData)),
+			ContentType: "image/" + spec.Format,
+			ETag:        fmt.Sprintf(`"%x"`, sum[:8]),
+		}}, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return entry.Data, entry.Info, nil
+}
+
+// thumbnailSpec is a validated thumbnail request
+type thumbnailSpec struct {
+	name    string // Size or variant as requested
+	path    string
+	size    config.SizeSpec
+	quality int // Quality to render with
+	format  string
+}
+
+// resolveThumbnail validates a thumbnail request against the profile and resolves its storage key.
+// size is a size or the name of one of the profile's variants.
+func (s *ImageService) resolveThumbnail(profile *config.Profile, baseImageName, size string, quality int, format string) (*thumbnailSpec, error) {
+	if size == "" {
+		if profile.DefaultSize == "" {
+			return nil, fmt.Errorf("please specify a size, as `default_size` is not set for this configuration")
+		}
+		size = profile.DefaultSize
+	}
+	// Every quality is stored as its own thumbnail, so only the profile's are rendered
+	if quality != 0 && !profile.AllowsQuality(quality) {
+		return nil, fmt.Errorf("%w: %d", ErrQualityNotAllowed, quality)
+	}
+
+	thumb := &thumbnailSpec{name: size}
+	var keyName string
+	if v, ok := profile.Variants[size]; ok {
+		// Variants bring their own format and quality. Their keys carry the full spec too, so
+		// editing a variant never serves a stale thumbnail.
+		thumb.size = profile.VariantSpec(v)
+		thumb.format = cmp.Or(format, profile.VariantFormat(v))
+		thumb.quality = cmp.Or(quality, v.Quality, profile.Quality)
+		keyName = size + "_" + thumb.size.Name()
+	} else {
+		// Widths may be rendered on demand; sizes with a height must be one of the profile's sizes
+		spec, err := profile.SizeSpec(size)
+		if err != nil || (spec.Height == 0 && !profile.AllowsWidth(spec.Width)) || (spec.Height > 0 && !profile.HasSize(size)) {
+			return nil, fmt.Errorf("%w: %s", ErrSizeNotAllowed, size)
+		}
+		thumb.size = spec
+		thumb.format = cmp.Or(format, profile.OutputFormat())
+		thumb.quality = cmp.Or(quality, profile.Quality)
+		keyName = spec.Name()
+	}
+
+	keyQuality := thumb.quality
+	if keyQuality == profile.Quality {
+		keyQuality = 0
+	}
+	thumb.path = s.thumbnailKey(profile, baseImageName, keyName, keyQuality, thumb.format)
+	return thumb, nil
+}
+
+// renditions resolves the thumbnails generated for every upload: each of the profile's sizes,
+// then each variant by name
+func (s *ImageService) renditions(profile *config.Profile, baseImageName string) ([]*thumbnailSpec, error) {
+	names := append(slices.Clone(profile.Sizes), slices.Sorted(maps.Keys(profile.Variants))...)
+	thumbs := make([]*thumbnailSpec, 0, len(names))
+	for _, name := range names {
+		thumb, err := s.resolveThumbnail(profile, baseImageName, name, 0, "")
+		if err != nil {
+			return nil, fmt.Errorf("invalid size format: %s", name)
+		}
+		thumbs = append(thumbs, thumb)
+	}
+	return thumbs, nil
+}
+
+// thumbnailKey returns the storage key of a thumbnail, named after its full size spec.
+// Non-default qualities get their own key so they don't overwrite the profile's thumbnails.
+func (s *ImageService) thumbnailKey(profile *config.Profile, baseImageName, name string, quality int, format string) string {
+	if quality > 0 {
+		name = fmt.Sprintf("%s_q%d", name, quality)
+	}
+	// example -> folder/file_size.ext
+	return fmt.Sprintf("%s/%s_%s.%s", profile.ThumbFolder, baseImageName, name, format)
+}
+
 // Read the first 512 bytes to determine the MIME type
 func DetermineMimeType(file multipart.File) (string, error) {
 	buf := make([]byte, 512)
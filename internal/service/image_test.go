@@ -0,0 +1,193 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"mediaflow/internal/cache"
+	"mediaflow/internal/config"
+	"mediaflow/internal/objectkey"
+	"mediaflow/internal/storage"
+)
+
+func TestProcessOriginal_SanitizeOriginal(t *testing.T) {
+	backend, err := storage.NewLocalBackend(t.TempDir(), "http://localhost", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &ImageService{Storage: backend, Cache: cache.NewMemory(0, nil)}
+	ctx := context.Background()
+
+	// A JPEG with an XMP packet, which sanitizing drops
+	xmp := []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta>GPS</x:xmpmeta>")
+	original := append([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, byte(len(xmp) + 2)}, xmp...)
+	original = append(original, 0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xD9)
+	if err := backend.PutObject(ctx, "originals/abc", bytes.NewReader(original), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	profile := &config.Profile{Kind: "image", SanitizeOriginal: true, ThumbFolder: "thumbnails", Sizes: []string{"256"}, ConvertTo: "jpeg"}
+	written, err := s.ProcessOriginal(ctx, profile, "originals/abc", "abc")
+	if err != nil {
+		t.Fatalf("ProcessOriginal failed: %v", err)
+	}
+	if !slices.Equal(written, []string{"originals/abc", "thumbnails/abc_256.jpeg"}) {
+		t.Errorf("Expected the original and its thumbnail to be written, got %v", written)
+	}
+	stored, err := backend.GetObject(ctx, "originals/abc")
+	if err != nil || bytes.Contains(stored, []byte("xmpmeta")) {
+		t.Errorf("Expected the stored original to be sanitized, got %q, %v", stored, err)
+	}
+
+	// Sanitized originals aren't rewritten again
+	if written, err := s.ProcessOriginal(ctx, profile, "originals/abc", "abc"); err != nil || slices.Contains(written, "originals/abc") {
+		t.Errorf("Expected only the thumbnail to be written, got %v, %v", written, err)
+	}
+}
+
+func TestUploadImage_InvalidatesCache(t *testing.T) {
+	backend, err := storage.NewLocalBackend(t.TempDir(), "http://localhost", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory := cache.NewMemory(1<<20, nil)
+	s := &ImageService{Storage: backend, Cache: memory}
+
+	stale := []string{"originals/abc", "thumbnails/abc_256.jpeg", "thumbnails/abc_300_q50.jpeg", "thumbnails/abc_t_w100_q80.webp"}
+	for _, key := range append(stale, "thumbnails/abcd_256.jpeg") {
+		memory.Set(key, &cache.Entry{Data: []byte("old")})
+	}
+
+	// No sizes, so nothing has to be rendered
+	profile := &config.Profile{Kind: "image", StoragePath: "originals/{key_base}", ThumbFolder: "thumbnails"}
+	if err := s.UploadImage(context.Background(), profile, []byte("new"), "avatar", "abc.jpg"); err != nil {
+		t.Fatalf("UploadImage failed: %v", err)
+	}
+	for _, key := range stale {
+		if _, ok := memory.Peek(key); ok {
+			t.Errorf("Expected %s to be evicted", key)
+		}
+	}
+	if _, ok := memory.Peek("thumbnails/abcd_256.jpeg"); !ok {
+		t.Error("Expected another asset's thumbnail to stay cached")
+	}
+}
+
+// failingOriginals is a backend that fails to store originals
+type failingOriginals struct {
+	storage.Backend
+}
+
+func (b failingOriginals) PutObject(ctx context.Context, key string, body io.Reader, contentType string) error {
+	if strings.HasPrefix(key, "originals/") {
+		return errors.New("storage unavailable")
+	}
+	return b.Backend.PutObject(ctx, key, body, contentType)
+}
+
+func TestUploadImage_RecordsKeyOnceStored(t *testing.T) {
+	backend, err := storage.NewLocalBackend(t.TempDir(), "http://localhost", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := &config.Profile{Name: "avatar", Kind: "image", StoragePath: "originals/{uuid}", ThumbFolder: "thumbnails"}
+	ctx := context.Background()
+
+	// A failed upload records nothing, so reads don't resolve to a missing original
+	s := &ImageService{Storage: failingOriginals{backend}, Cache: cache.NewMemory(0, nil)}
+	if err := s.UploadImage(ctx, profile, []byte("new"), "avatar", "abc.jpg"); err == nil {
+		t.Fatal("Expected UploadImage to fail")
+	}
+	if _, err := backend.HeadObject(ctx, objectkey.IndexKey("avatar", "", "abc")); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected no recorded key after a failed upload, got %v", err)
+	}
+
+	s.Storage = backend
+	if err := s.UploadImage(ctx, profile, []byte("new"), "avatar", "abc.jpg"); err != nil {
+		t.Fatalf("UploadImage failed: %v", err)
+	}
+	if data, _, err := s.OpenOriginal(ctx, profile, "abc"); err != nil {
+		t.Errorf("Expected the recorded original to be readable, got %v", err)
+	} else {
+		data.Close()
+	}
+}
+
+func TestResolveThumbnail_Quality(t *testing.T) {
+	s := &ImageService{}
+	profile := &config.Profile{Kind: "image", ThumbFolder: "thumbnails", Sizes: []string{"256"}, Quality: 90, AllowedQualities: []int{60}, ConvertTo: "jpeg"}
+
+	tests := []struct {
+		name     string
+		quality  int
+		expected string
+	}{
+		{"Profile quality", 0, "thumbnails/abc_256.jpeg"},
+		{"Explicit profile quality", 90, "thumbnails/abc_256.jpeg"},
+		{"Allowed quality", 60, "thumbnails/abc_256_q60.jpeg"},
+		{"Quality not allowed", 61, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			thumb, err := s.resolveThumbnail(profile, "abc", "256", tt.quality, "")
+			if tt.expected == "" {
+				if !errors.Is(err, ErrQualityNotAllowed) {
+					t.Errorf("Expected ErrQualityNotAllowed, got %v", err)
+				}
+				return
+			}
+			if err != nil || thumb.path != tt.expected {
+				t.Errorf("Expected %s, got %+v, %v", tt.expected, thumb, err)
+			}
+		})
+	}
+}
+
+// contentTypeBackend records the content type objects are written with
+type contentTypeBackend struct {
+	*storage.LocalBackend
+	contentTypes map[string]string
+}
+
+func (b *contentTypeBackend) PutObject(ctx context.Context, key string, body io.Reader, contentType string) error {
+	b.contentTypes[key] = contentType
+	return b.LocalBackend.PutObject(ctx, key, body, contentType)
+}
+
+func TestProcessOriginal_ContentTypes(t *testing.T) {
+	local, err := storage.NewLocalBackend(t.TempDir(), "http://localhost", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &contentTypeBackend{LocalBackend: local, contentTypes: map[string]string{}}
+	s := &ImageService{Storage: backend, Cache: cache.NewMemory(0, nil)}
+	ctx := context.Background()
+	var original bytes.Buffer
+	if err := png.Encode(&original, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	if err := local.PutObject(ctx, "originals/abc", &original, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	profile := &config.Profile{Kind: "image", StoragePath: "originals/{key_base}", ThumbFolder: "thumbnails", Sizes: []string{"256"}, ConvertTo: "webp"}
+	if _, err := s.ProcessOriginal(ctx, profile, "originals/abc", "abc"); err != nil {
+		t.Fatalf("ProcessOriginal failed: %v", err)
+	}
+	if _, err := s.Metadata(ctx, profile, "abc"); err != nil {
+		t.Fatalf("Metadata failed: %v", err)
+	}
+	// Served as-is by storage through presigned redirects
+	for key, expected := range map[string]string{"thumbnails/abc_256.webp": "image/webp", "originals/abc.metadata.json": "application/json"} {
+		if backend.contentTypes[key] != expected {
+			t.Errorf("Expected %s to be written as %s, got %q", key, expected, backend.contentTypes[key])
+		}
+	}
+}
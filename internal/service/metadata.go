@@ -0,0 +1,175 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/jpeg" // Decoders for dominant color samples
+	_ "image/png"
+
+	"gopkg.in/h2non/bimg.v1"
+
+	"mediaflow/internal/config"
+	"mediaflow/internal/objectkey"
+)
+
+// dominantColorSampleWidth is the width originals are scaled to before their dominant color is picked
+const dominantColorSampleWidth = 64
+
+// ImageMetadata describes an original. Width and height are as displayed, with the EXIF
+// orientation applied, so clients can reserve layout space before loading the image.
+type ImageMetadata struct {
+	Width         int    `json:"width"`
+	Height        int    `json:"height"`
+	Format        string `json:"format"`
+	Bytes         int64  `json:"bytes"`
+	HasAlpha      bool   `json:"has_alpha"`
+	Orientation   int    `json:"orientation,omitempty"` // EXIF orientation, 1-8
+	EXIF          *EXIF  `json:"exif,omitempty"`
+	DominantColor string `json:"dominant_color,omitempty"` // "#rrggbb"
+}
+
+// metadataSidecar is the stored form of ImageMetadata. SourceETag is the ETag of the original it
+// was computed from, so replaced originals are recomputed.
+type metadataSidecar struct {
+	ImageMetadata
+	SourceETag string `json:"source_etag"`
+}
+
+// EXIF is the subset of EXIF fields served with image metadata. GPS and serial numbers are never included.
+type EXIF struct {
+	Make             string `json:"make,omitempty"`
+	Model            string `json:"model,omitempty"`
+	Software         string `json:"software,omitempty"`
+	DateTimeOriginal string `json:"datetime_original,omitempty"`
+	ExposureTime     string `json:"exposure_time,omitempty"`
+	FNumber          string `json:"f_number,omitempty"`
+	ISO              int    `json:"iso,omitempty"`
+	FocalLength      string `json:"focal_length,omitempty"`
+}
+
+// Metadata returns the metadata of an original. It's computed with libvips on first request and
+// stored as a JSON sidecar next to the original; later requests only HEAD the original to check
+// the sidecar is still current.
+func (s *ImageService) Metadata(ctx context.Context, profile *config.Profile, baseImageName string) (*ImageMetadata, error) {
+	path, err := s.originalPath(ctx, profile, baseImageName)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.Storage.HeadObject(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image from storage: %w", err)
+	}
+
+	sidecarKey := objectkey.MetadataKey(path)
+	if data, err := s.Storage.GetObject(ctx, sidecarKey); err == nil {
+		var sidecar metadataSidecar
+		if json.Unmarshal(data, &sidecar) == nil && sidecar.SourceETag == info.ETag {
+			return &sidecar.ImageMetadata, nil
+		}
+	}
+
+	original, err := s.Storage.GetObject(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image from storage: %w", err)
+	}
+	meta, err := computeMetadata(original)
+	if err != nil {
+		return nil, err
+	}
+	meta.Bytes = int64(len(original))
+
+	data, err := json.Marshal(metadataSidecar{ImageMetadata: *meta, SourceETag: info.ETag})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Storage.PutObject(ctx, sidecarKey, bytes.NewReader(data), "application/json"); err != nil {
+		// Still serve what was computed; the next request tries again
+		fmt.Printf("⚠️ Failed to store metadata of %s: %v\n", path, err)
+	}
+	return meta, nil
+}
+
+// computeMetadata reads an image's metadata with libvips and picks its dominant color
+func computeMetadata(imageData []byte) (*ImageMetadata, error) {
+	m, err := bimg.Metadata(imageData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image metadata with bimg: %w", err)
+	}
+
+	meta := &ImageMetadata{
+		Width:       m.Size.Width,
+		Height:      m.Size.Height,
+		Format:      m.Type,
+		HasAlpha:    m.Alpha,
+		Orientation: m.Orientation,
+	}
+	// Orientations 5-8 are rotated by 90 degrees, so they display with width and height swapped
+	if m.Orientation >= 5 && m.Orientation <= 8 {
+		meta.Width, meta.Height = meta.Height, meta.Width
+	}
+	exif := EXIF{
+		Make:             m.EXIF.Make,
+		Model:            m.EXIF.Model,
+		Software:         m.EXIF.Software,
+		DateTimeOriginal: m.EXIF.DateTimeOriginal,
+		ExposureTime:     m.EXIF.ExposureTime,
+		FNumber:          m.EXIF.FNumber,
+		ISO:              m.EXIF.ISOSpeedRatings,
+		FocalLength:      m.EXIF.FocalLength,
+	}
+	if exif != (EXIF{}) {
+		meta.EXIF = &exif
+	}
+
+	if meta.DominantColor, err = dominantColor(imageData); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// dominantColor scales an image down with libvips and returns the average of its most common
+// color bucket as "#rrggbb". Transparent pixels don't count; fully transparent images have none.
+func dominantColor(imageData []byte) (string, error) {
+	sample, err := bimg.NewImage(imageData).Process(bimg.Options{Width: dominantColorSampleWidth, Type: bimg.PNG, StripMetadata: true})
+	if err != nil {
+		return "", fmt.Errorf("failed to sample image with bimg: %w", err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(sample))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode image sample: %w", err)
+	}
+
+	// Buckets of 4 bits per channel, summing the full values to average each bucket
+	type bucket struct{ count, r, g, b uint64 }
+	buckets := make(map[uint32]*bucket)
+	var best *bucket
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			if a < 0x8000 {
+				continue
+			}
+			// Un-premultiply and reduce to 8 bits
+			r, g, b = r*0xffff/a>>8, g*0xffff/a>>8, b*0xffff/a>>8
+			key := r>>4<<8 | g>>4<<4 | b>>4
+			bk := buckets[key]
+			if bk == nil {
+				bk = &bucket{}
+				buckets[key] = bk
+			}
+			bk.count++
+			bk.r, bk.g, bk.b = bk.r+uint64(r), bk.g+uint64(g), bk.b+uint64(b)
+			if best == nil || bk.count > best.count {
+				best = bk
+			}
+		}
+	}
+	if best == nil {
+		return "", nil
+	}
+	return fmt.Sprintf("#%02x%02x%02x", best.r/best.count, best.g/best.count, best.b/best.count), nil
+}
@@ -0,0 +1,520 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	utils "mediaflow/internal"
+	"mediaflow/internal/response"
+)
+
+// LocalPresignPath is the route prefix under which MediaFlow serves presigned URLs for the local driver
+const LocalPresignPath = "/v1/storage/"
+
+// uploadsDirName holds in-progress multipart uploads, outside the object namespace
+const uploadsDirName = ".uploads"
+
+// LocalBackend stores objects on the local filesystem.
+// Presigned URLs point back at MediaFlow itself and are verified with an HMAC signature.
+type LocalBackend struct {
+	root      string
+	publicURL string
+	secret    []byte
+}
+
+// NewLocalBackend creates a local filesystem backend rooted at root.
+// publicURL is the externally reachable base URL of this MediaFlow instance, used for presigned URLs.
+func NewLocalBackend(root, publicURL, secret string) (*LocalBackend, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve local storage path: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Join(absRoot, uploadsDirName), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create local storage directory: %w", err)
+	}
+
+	key := []byte(secret)
+	if len(key) == 0 {
+		// Presigned URLs will not survive a restart without a configured secret
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate signing secret: %w", err)
+		}
+		fmt.Println("⚠️ LOCAL_STORAGE_SECRET not set, using a random secret for presigned URLs")
+	}
+
+	return &LocalBackend{
+		root:      absRoot,
+		publicURL: strings.TrimSuffix(publicURL, "/"),
+		secret:    key,
+	}, nil
+}
+
+func (b *LocalBackend) GetObject(ctx context.Context, key string) ([]byte, error) {
+	p, err := b.objectPath(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return data, err
+}
+
+// GetObjectStream opens an object for reading along with its metadata. The caller must close the reader.
+func (b *LocalBackend) GetObjectStream(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	p, err := b.objectPath(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err == nil && fi.IsDir() {
+		err = fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, newObjectInfo(key, fi), nil
+}
+
+// GetObjectRange opens length bytes of an object starting at offset. The caller must close the reader.
+func (b *LocalBackend) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	body, _, err := b.GetObjectStream(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	f := body.(*os.File)
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+// HeadObject returns object metadata without reading the body
+func (b *LocalBackend) HeadObject(ctx context.Context, key string) (*ObjectInfo, error) {
+	p, err := b.objectPath(key)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && fi.IsDir()) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newObjectInfo(key, fi), nil
+}
+
+// PutObject writes an object. Local objects are served with the content type of their extension,
+// so contentType isn't stored.
+func (b *LocalBackend) PutObject(ctx context.Context, key string, body io.Reader, contentType string) error {
+	p, err := b.objectPath(key)
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(p, body)
+}
+
+// CopyObject copies an object within the storage root
+func (b *LocalBackend) CopyObject(ctx context.Context, from, to string) error {
+	src, err := b.objectPath(from)
+	if err != nil {
+		return err
+	}
+	dst, err := b.objectPath(to)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(src)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrNotFound, from)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return utils.WriteFileAtomic(dst, f)
+}
+
+// DeleteObject removes an object. Deleting a missing object is not an error (matches S3).
+func (b *LocalBackend) DeleteObject(ctx context.Context, key string) error {
+	p, err := b.objectPath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// ListByPrefix returns all object keys matching the given prefix.
+func (b *LocalBackend) ListByPrefix(ctx context.Context, prefix string) ([]string, error) {
+	// Only walk the deepest directory the prefix pins down
+	start := b.root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir, err := b.objectPath(prefix[:i])
+		if err != nil {
+			return nil, err
+		}
+		start = dir
+	}
+
+	keys := []string{}
+	err := filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == uploadsDirName && filepath.Dir(p) == b.root {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), utils.TempFilePrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(b.root, p)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// PresignPutObject generates a signed URL for PUT operations served by MediaFlow
+func (b *LocalBackend) PresignPutObject(ctx context.Context, key string, expires time.Duration, headers map[string]string) (string, error) {
+	if _, err := b.objectPath(key); err != nil {
+		return "", err
+	}
+	return b.signedURL(http.MethodPut, key, expires, url.Values{}), nil
+}
+
+// PresignGetObject generates a signed URL for GET operations served by MediaFlow
+func (b *LocalBackend) PresignGetObject(ctx context.Context, key string, expires time.Duration) (string, error) {
+	if _, err := b.objectPath(key); err != nil {
+		return "", err
+	}
+	return b.signedURL(http.MethodGet, key, expires, url.Values{}), nil
+}
+
+// CreateMultipartUpload creates a multipart upload and returns the upload ID
+func (b *LocalBackend) CreateMultipartUpload(ctx context.Context, key string, headers map[string]string) (string, error) {
+	if _, err := b.objectPath(key); err != nil {
+		return "", err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	uploadID := hex.EncodeToString(id)
+
+	dir := filepath.Join(b.root, uploadsDirName, uploadID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "key"), []byte(key), 0o644); err != nil {
+		return "", err
+	}
+	return uploadID, nil
+}
+
+// PresignUploadPart generates a signed URL for uploading a part
+func (b *LocalBackend) PresignUploadPart(ctx context.Context, key, uploadID string, partNumber int32, expires time.Duration) (string, error) {
+	params := url.Values{}
+	params.Set("uploadId", uploadID)
+	params.Set("partNumber", strconv.Itoa(int(partNumber)))
+	return b.signedURL(http.MethodPut, key, expires, params), nil
+}
+
+// CompleteMultipartUpload assembles the uploaded parts, in the given order, into the final object
+func (b *LocalBackend) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []PartInfo) error {
+	dir, err := b.uploadDir(key, uploadID)
+	if err != nil {
+		return err
+	}
+	dst, err := b.objectPath(key)
+	if err != nil {
+		return err
+	}
+	// S3 rejects completing an upload without parts rather than writing an empty object
+	if len(parts) == 0 {
+		return fmt.Errorf("at least one part is required")
+	}
+
+	readers := make([]io.Reader, 0, len(parts))
+	for _, part := range parts {
+		partPath := filepath.Join(dir, partFileName(part.PartNumber))
+		etag, err := os.ReadFile(partPath + ".etag")
+		if err != nil {
+			return fmt.Errorf("part %d not uploaded", part.PartNumber)
+		}
+		if strings.Trim(part.ETag, `"`) != string(etag) {
+			return fmt.Errorf("part %d etag mismatch", part.PartNumber)
+		}
+		f, err := os.Open(partPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+
+	if err := utils.WriteFileAtomic(dst, io.MultiReader(readers...)); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// AbortMultipartUpload aborts a multipart upload and removes any uploaded parts
+func (b *LocalBackend) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	dir, err := b.uploadDir(key, uploadID)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// ListParts returns the parts uploaded so far for a multipart upload
+func (b *LocalBackend) ListParts(ctx context.Context, key, uploadID string) ([]PartInfo, error) {
+	dir, err := b.uploadDir(key, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*.etag"))
+	if err != nil {
+		return nil, err
+	}
+
+	parts := make([]PartInfo, 0, len(matches))
+	for _, m := range matches {
+		partNumber, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(m), ".etag"))
+		if err != nil {
+			continue
+		}
+		etag, err := os.ReadFile(m)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, PartInfo{ETag: string(etag), PartNumber: partNumber})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	return parts, nil
+}
+
+// ServeHTTP handles requests to presigned URLs (mounted at LocalPresignPath)
+func (b *LocalBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, LocalPresignPath)
+	query := r.URL.Query()
+
+	if !b.verify(r.Method, key, query) {
+		response.JSON("Invalid or expired signature").WriteError(w, http.StatusForbidden)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		b.serveObject(w, r, key)
+		return
+	}
+	if r.Method != http.MethodPut {
+		response.JSON("Method not allowed").WriteError(w, http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Part upload
+	if uploadID := query.Get("uploadId"); uploadID != "" {
+		partNumber, err := strconv.Atoi(query.Get("partNumber"))
+		if err != nil || partNumber < 1 {
+			response.JSON("Invalid partNumber").WriteError(w, http.StatusBadRequest)
+			return
+		}
+		etag, err := b.writePart(key, uploadID, partNumber, r.Body)
+		if errors.Is(err, ErrNoSuchUpload) {
+			response.JSON(err.Error()).WriteError(w, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			response.JSON(err.Error()).WriteError(w, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", fmt.Sprintf(`"%s"`, etag))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Single PUT
+	p, err := b.objectPath(key)
+	if err != nil {
+		response.JSON(err.Error()).WriteError(w, http.StatusBadRequest)
+		return
+	}
+	hash := md5.New()
+	write := utils.WriteFileAtomic
+	if r.Header.Get("If-None-Match") == "*" {
+		write = writeFileExclusive
+	}
+	if err := write(p, io.TeeReader(r.Body, hash)); errors.Is(err, fs.ErrExist) {
+		response.JSON("Object already exists").WriteError(w, http.StatusPreconditionFailed)
+		return
+	} else if err != nil {
+		response.JSON(err.Error()).WriteError(w, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, hex.EncodeToString(hash.Sum(nil))))
+	w.WriteHeader(http.StatusOK)
+}
+
+// serveObject streams an object for a presigned GET
+func (b *LocalBackend) serveObject(w http.ResponseWriter, r *http.Request, key string) {
+	body, info, err := b.GetObjectStream(r.Context(), key)
+	if errors.Is(err, ErrNotFound) {
+		response.JSON("Object not found").WriteError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		response.JSON(err.Error()).WriteError(w, http.StatusInternalServerError)
+		return
+	}
+	defer body.Close()
+
+	if info.ContentType != "" {
+		w.Header().Set("Content-Type", info.ContentType)
+	}
+	w.Header().Set("ETag", info.ETag)
+	w.Header().Set("Cache-Control", "private")
+	// ServeContent handles Range and conditional requests, as S3 does for presigned GETs
+	http.ServeContent(w, r, "", info.LastModified, body.(*os.File))
+}
+
+// Helpers
+
+// objectPath maps an object key to a path inside the storage root
+func (b *LocalBackend) objectPath(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/" || clean == "/"+uploadsDirName || strings.HasPrefix(clean, "/"+uploadsDirName+"/") {
+		return "", fmt.Errorf("invalid object key: %q", key)
+	}
+	return filepath.Join(b.root, filepath.FromSlash(clean)), nil
+}
+
+// uploadDir returns the directory of an in-progress multipart upload, verifying it belongs to key
+func (b *LocalBackend) uploadDir(key, uploadID string) (string, error) {
+	if _, err := hex.DecodeString(uploadID); err != nil || uploadID == "" {
+		return "", ErrNoSuchUpload
+	}
+	dir := filepath.Join(b.root, uploadsDirName, uploadID)
+	storedKey, err := os.ReadFile(filepath.Join(dir, "key"))
+	if err != nil || string(storedKey) != key {
+		return "", ErrNoSuchUpload
+	}
+	return dir, nil
+}
+
+func (b *LocalBackend) writePart(key, uploadID string, partNumber int, body io.Reader) (string, error) {
+	dir, err := b.uploadDir(key, uploadID)
+	if err != nil {
+		return "", err
+	}
+	partPath := filepath.Join(dir, partFileName(partNumber))
+	hash := md5.New()
+	if err := utils.WriteFileAtomic(partPath, io.TeeReader(body, hash)); err != nil {
+		return "", err
+	}
+	etag := hex.EncodeToString(hash.Sum(nil))
+	if err := os.WriteFile(partPath+".etag", []byte(etag), 0o644); err != nil {
+		return "", err
+	}
+	return etag, nil
+}
+
+func (b *LocalBackend) signedURL(method, key string, expires time.Duration, params url.Values) string {
+	params.Set("X-Expires", strconv.FormatInt(time.Now().Add(expires).Unix(), 10))
+	params.Set("X-Signature", b.sign(method, key, params))
+	escapedKey := (&url.URL{Path: key}).EscapedPath()
+	return fmt.Sprintf("%s%s%s?%s", b.publicURL, LocalPresignPath, escapedKey, params.Encode())
+}
+
+func (b *LocalBackend) sign(method, key string, params url.Values) string {
+	mac := hmac.New(sha256.New, b.secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s", method, key, params.Get("X-Expires"), params.Get("uploadId"), params.Get("partNumber"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (b *LocalBackend) verify(method, key string, params url.Values) bool {
+	expires, err := strconv.ParseInt(params.Get("X-Expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	expected := b.sign(method, key, params)
+	return hmac.Equal([]byte(expected), []byte(params.Get("X-Signature")))
+}
+
+// newObjectInfo builds object metadata from a file.
+// The ETag is derived from modification time and size, so it changes whenever the file is rewritten.
+func newObjectInfo(key string, fi fs.FileInfo) *ObjectInfo {
+	return &ObjectInfo{
+		Size:         fi.Size(),
+		ContentType:  mime.TypeByExtension(path.Ext(key)),
+		ETag:         fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), fi.Size()),
+		LastModified: fi.ModTime().UTC(),
+	}
+}
+
+func partFileName(partNumber int) string {
+	return fmt.Sprintf("%05d", partNumber)
+}
+
+// writeFileExclusive is utils.WriteFileAtomic for If-None-Match: *. The temp file is linked into place,
+// which fails with fs.ErrExist if dst exists, so of two concurrent writers only one succeeds.
+func writeFileExclusive(dst string, body io.Reader) error {
+	tmp, err := utils.WriteTempFile(dst, body)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	return os.Link(tmp, dst)
+}
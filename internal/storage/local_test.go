@@ -0,0 +1,334 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestBackend(t *testing.T) *LocalBackend {
+	t.Helper()
+	backend, err := NewLocalBackend(t.TempDir(), "http://mediaflow.test", "test-secret")
+	if err != nil {
+		t.Fatalf("Failed to create local backend: %v", err)
+	}
+	return backend
+}
+
+func TestLocalBackend_ObjectLifecycle(t *testing.T) {
+	backend := newTestBackend(t)
+	ctx := context.Background()
+
+	if err := backend.PutObject(ctx, "thumbnails/avatar_256.webp", strings.NewReader("thumb"), ""); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if err := backend.PutObject(ctx, "thumbnails/avatar_512.webp", strings.NewReader("thumb2"), ""); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if err := backend.PutObject(ctx, "originals/avatar", strings.NewReader("orig"), ""); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	data, err := backend.GetObject(ctx, "thumbnails/avatar_256.webp")
+	if err != nil || string(data) != "thumb" {
+		t.Errorf("GetObject = %q, %v; expected %q", data, err, "thumb")
+	}
+
+	stream, streamInfo, err := backend.GetObjectStream(ctx, "originals/avatar")
+	if err != nil {
+		t.Fatalf("GetObjectStream failed: %v", err)
+	}
+	streamed, _ := io.ReadAll(stream)
+	stream.Close()
+	if string(streamed) != "orig" {
+		t.Errorf("GetObjectStream = %q, expected %q", streamed, "orig")
+	}
+	if streamInfo.Size != 4 {
+		t.Errorf("GetObjectStream size = %d, expected 4", streamInfo.Size)
+	}
+
+	ranged, err := backend.GetObjectRange(ctx, "originals/avatar", 1, 2)
+	if err != nil {
+		t.Fatalf("GetObjectRange failed: %v", err)
+	}
+	partial, _ := io.ReadAll(ranged)
+	ranged.Close()
+	if string(partial) != "ri" {
+		t.Errorf("GetObjectRange = %q, expected %q", partial, "ri")
+	}
+
+	info, err := backend.HeadObject(ctx, "originals/avatar")
+	if err != nil {
+		t.Fatalf("HeadObject failed: %v", err)
+	}
+	if info.Size != 4 || info.ETag != streamInfo.ETag || info.LastModified.IsZero() {
+		t.Errorf("Unexpected object info: %+v", info)
+	}
+	if _, err := backend.HeadObject(ctx, "originals/missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound from HeadObject, got %v", err)
+	}
+
+	keys, err := backend.ListByPrefix(ctx, "thumbnails/avatar")
+	if err != nil {
+		t.Fatalf("ListByPrefix failed: %v", err)
+	}
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "thumbnails/avatar_256.webp" || keys[1] != "thumbnails/avatar_512.webp" {
+		t.Errorf("Unexpected keys: %v", keys)
+	}
+
+	if err := backend.DeleteObject(ctx, "thumbnails/avatar_256.webp"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	if _, err := backend.GetObject(ctx, "thumbnails/avatar_256.webp"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after delete, got %v", err)
+	}
+	// Deleting a missing object is not an error
+	if err := backend.DeleteObject(ctx, "thumbnails/avatar_256.webp"); err != nil {
+		t.Errorf("Expected no error deleting missing object, got %v", err)
+	}
+}
+
+func TestLocalBackend_RejectsPathTraversal(t *testing.T) {
+	backend := newTestBackend(t)
+	ctx := context.Background()
+
+	// ".." segments are clamped to the storage root
+	if err := backend.PutObject(ctx, "../../escape", strings.NewReader("x"), ""); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if _, err := backend.GetObject(ctx, "escape"); err != nil {
+		t.Errorf("Expected object to be stored inside root, got %v", err)
+	}
+
+	if err := backend.PutObject(ctx, ".uploads/evil", strings.NewReader("x"), ""); err == nil {
+		t.Errorf("Expected error writing into the uploads directory")
+	}
+}
+
+func TestLocalBackend_MultipartUpload(t *testing.T) {
+	backend := newTestBackend(t)
+	ctx := context.Background()
+	server := httptest.NewServer(backend)
+	defer server.Close()
+
+	uploadID, err := backend.CreateMultipartUpload(ctx, "videos/clip.mp4", nil)
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload failed: %v", err)
+	}
+
+	var parts []PartInfo
+	for i, chunk := range []string{"hello ", "world"} {
+		partURL, err := backend.PresignUploadPart(ctx, "videos/clip.mp4", uploadID, int32(i+1), time.Minute)
+		if err != nil {
+			t.Fatalf("PresignUploadPart failed: %v", err)
+		}
+		resp := doPut(t, server.URL, partURL, chunk, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Part upload returned %d", resp.StatusCode)
+		}
+		parts = append(parts, PartInfo{PartNumber: i + 1, ETag: resp.Header.Get("ETag")})
+	}
+
+	listed, err := backend.ListParts(ctx, "videos/clip.mp4", uploadID)
+	if err != nil || len(listed) != 2 {
+		t.Fatalf("ListParts = %v, %v; expected 2 parts", listed, err)
+	}
+
+	if _, err := backend.ListParts(ctx, "videos/other.mp4", uploadID); !errors.Is(err, ErrNoSuchUpload) {
+		t.Errorf("Expected ErrNoSuchUpload for mismatched key, got %v", err)
+	}
+
+	if err := backend.CompleteMultipartUpload(ctx, "videos/clip.mp4", uploadID, nil); err == nil {
+		t.Error("Expected completing without parts to fail")
+	}
+	if _, err := backend.HeadObject(ctx, "videos/clip.mp4"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected no object from an upload completed without parts, got %v", err)
+	}
+
+	if err := backend.CompleteMultipartUpload(ctx, "videos/clip.mp4", uploadID, parts); err != nil {
+		t.Fatalf("CompleteMultipartUpload failed: %v", err)
+	}
+
+	data, err := backend.GetObject(ctx, "videos/clip.mp4")
+	if err != nil || string(data) != "hello world" {
+		t.Errorf("Assembled object = %q, %v", data, err)
+	}
+
+	if _, err := backend.ListParts(ctx, "videos/clip.mp4", uploadID); !errors.Is(err, ErrNoSuchUpload) {
+		t.Errorf("Expected upload to be gone after completion, got %v", err)
+	}
+}
+
+func TestLocalBackend_PresignedPut(t *testing.T) {
+	backend := newTestBackend(t)
+	ctx := context.Background()
+	server := httptest.NewServer(backend)
+	defer server.Close()
+
+	putURL, err := backend.PresignPutObject(ctx, "originals/ab/photo.jpg", time.Minute, nil)
+	if err != nil {
+		t.Fatalf("PresignPutObject failed: %v", err)
+	}
+	if !strings.HasPrefix(putURL, "http://mediaflow.test"+LocalPresignPath) {
+		t.Errorf("Unexpected presigned URL: %s", putURL)
+	}
+
+	ifNoneMatch := map[string]string{"If-None-Match": "*"}
+	if resp := doPut(t, server.URL, putURL, "jpeg-bytes", ifNoneMatch); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Presigned PUT returned %d", resp.StatusCode)
+	}
+	if data, _ := backend.GetObject(ctx, "originals/ab/photo.jpg"); !bytes.Equal(data, []byte("jpeg-bytes")) {
+		t.Errorf("Unexpected stored data: %q", data)
+	}
+
+	// Overwrite prevention
+	if resp := doPut(t, server.URL, putURL, "again", ifNoneMatch); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("Expected 412 on overwrite, got %d", resp.StatusCode)
+	}
+
+	// Of concurrent writers, exactly one wins
+	raceURL, _ := backend.PresignPutObject(ctx, "originals/ab/race.jpg", time.Minute, nil)
+	u, _ := url.Parse(raceURL)
+	statuses := make(chan int, 8)
+	for i := 0; i < cap(statuses); i++ {
+		go func() {
+			req, _ := http.NewRequest(http.MethodPut, server.URL+u.RequestURI(), strings.NewReader(fmt.Sprintf("writer-%d", i)))
+			req.Header.Set("If-None-Match", "*")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				statuses <- 0
+				return
+			}
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}()
+	}
+	created := 0
+	for i := 0; i < cap(statuses); i++ {
+		switch status := <-statuses; status {
+		case http.StatusOK:
+			created++
+		case http.StatusPreconditionFailed:
+		default:
+			t.Errorf("Unexpected status %d for a concurrent PUT", status)
+		}
+	}
+	if created != 1 {
+		t.Errorf("Expected exactly one concurrent PUT to succeed, got %d", created)
+	}
+
+	// Tampered key
+	tampered := strings.Replace(putURL, "photo.jpg", "other.jpg", 1)
+	if resp := doPut(t, server.URL, tampered, "x", nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 for tampered URL, got %d", resp.StatusCode)
+	}
+
+	// Expired URL
+	expiredURL, _ := backend.PresignPutObject(ctx, "originals/ab/late.jpg", -time.Minute, nil)
+	if resp := doPut(t, server.URL, expiredURL, "x", nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 for expired URL, got %d", resp.StatusCode)
+	}
+}
+
+func TestLocalBackend_PresignedGet(t *testing.T) {
+	backend := newTestBackend(t)
+	ctx := context.Background()
+	server := httptest.NewServer(backend)
+	defer server.Close()
+
+	if err := backend.PutObject(ctx, "originals/kyc/doc.pdf", strings.NewReader("pdf-bytes"), ""); err != nil {
+		t.Fatal(err)
+	}
+	getURL, err := backend.PresignGetObject(ctx, "originals/kyc/doc.pdf", time.Minute)
+	if err != nil {
+		t.Fatalf("PresignGetObject failed: %v", err)
+	}
+
+	resp, body := doGet(t, server.URL, getURL)
+	if resp.StatusCode != http.StatusOK || body != "pdf-bytes" {
+		t.Fatalf("Presigned GET returned %d %q", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Cache-Control") != "private" {
+		t.Errorf("Expected Cache-Control: private, got %q", resp.Header.Get("Cache-Control"))
+	}
+
+	// Range requests get just those bytes, as from S3
+	u, _ := url.Parse(getURL)
+	req, _ := http.NewRequest(http.MethodGet, server.URL+u.RequestURI(), nil)
+	req.Header.Set("Range", "bytes=4-8")
+	rangeResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Range GET failed: %v", err)
+	}
+	rangeBody, _ := io.ReadAll(rangeResp.Body)
+	rangeResp.Body.Close()
+	if rangeResp.StatusCode != http.StatusPartialContent || string(rangeBody) != "bytes" {
+		t.Errorf("Range GET returned %d %q", rangeResp.StatusCode, rangeBody)
+	}
+	if cr := rangeResp.Header.Get("Content-Range"); cr != "bytes 4-8/9" {
+		t.Errorf("Expected Content-Range bytes 4-8/9, got %q", cr)
+	}
+
+	// A PUT signature doesn't allow reads
+	putURL, _ := backend.PresignPutObject(ctx, "originals/kyc/doc.pdf", time.Minute, nil)
+	if resp, _ := doGet(t, server.URL, putURL); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 for a GET with a PUT signature, got %d", resp.StatusCode)
+	}
+
+	// Expired URL
+	expiredURL, _ := backend.PresignGetObject(ctx, "originals/kyc/doc.pdf", -time.Minute)
+	if resp, _ := doGet(t, server.URL, expiredURL); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 for expired URL, got %d", resp.StatusCode)
+	}
+
+	// Missing object
+	missingURL, _ := backend.PresignGetObject(ctx, "originals/kyc/missing.pdf", time.Minute)
+	if resp, _ := doGet(t, server.URL, missingURL); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing object, got %d", resp.StatusCode)
+	}
+}
+
+// doGet sends a GET for a presigned URL to the test server and returns the response and its body
+func doGet(t *testing.T, serverURL, presignedURL string) (*http.Response, string) {
+	t.Helper()
+	u, err := url.Parse(presignedURL)
+	if err != nil {
+		t.Fatalf("Invalid presigned URL: %v", err)
+	}
+	resp, err := http.Get(serverURL + u.RequestURI())
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+// doPut sends a PUT for a presigned URL to the test server, keeping the presigned path and query
+func doPut(t *testing.T, serverURL, presignedURL, body string, headers map[string]string) *http.Response {
+	t.Helper()
+	u, err := url.Parse(presignedURL)
+	if err != nil {
+		t.Fatalf("Invalid presigned URL: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodPut, serverURL+u.RequestURI(), strings.NewReader(body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT failed: %v", err)
+	}
+	resp.Body.Close()
+	return resp
+}
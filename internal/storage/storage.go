@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// Supported backend drivers
+const (
+	DriverS3    = "s3"
+	DriverLocal = "local"
+)
+
+var (
+	// ErrNotFound is returned when an object does not exist
+	ErrNotFound = errors.New("object not found")
+	// ErrNoSuchUpload is returned when a multipart upload does not exist or has already been completed/aborted
+	ErrNoSuchUpload = errors.New("multipart upload not found")
+)
+
+// Backend is the interface implemented by every storage driver (S3, local disk)
+type Backend interface {
+	// Object access
+	GetObject(ctx context.Context, key string) ([]byte, error)
+	GetObjectStream(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error)
+	GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	HeadObject(ctx context.Context, key string) (*ObjectInfo, error)
+	PutObject(ctx context.Context, key string, body io.Reader, contentType string) error // contentType may be empty
+	CopyObject(ctx context.Context, from, to string) error                               // Keeps the content type
+	DeleteObject(ctx context.Context, key string) error
+	ListByPrefix(ctx context.Context, prefix string) ([]string, error)
+
+	// Presigned URLs
+	PresignPutObject(ctx context.Context, key string, expires time.Duration, headers map[string]string) (string, error)
+	PresignGetObject(ctx context.Context, key string, expires time.Duration) (string, error)
+
+	// Multipart uploads
+	CreateMultipartUpload(ctx context.Context, key string, headers map[string]string) (string, error)
+	PresignUploadPart(ctx context.Context, key, uploadID string, partNumber int32, expires time.Duration) (string, error)
+	CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []PartInfo) error
+	AbortMultipartUpload(ctx context.Context, key, uploadID string) error
+	ListParts(ctx context.Context, key, uploadID string) ([]PartInfo, error)
+}
+
+// ObjectInfo is the metadata of a stored object, as returned by a HEAD request
+type ObjectInfo struct {
+	Size         int64
+	ContentType  string
+	ETag         string // Quoted, ready for use as an HTTP ETag header
+	LastModified time.Time
+}
+
+// PartInfo represents a completed part for multipart upload
+type PartInfo struct {
+	ETag       string
+	PartNumber int
+}
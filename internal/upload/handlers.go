@@ -3,20 +3,28 @@ package upload
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strings"
+	"time"
 
+	"mediaflow/internal/auth"
 	"mediaflow/internal/config"
 )
 
+// defaultTokenTTL applies when the storage config doesn't set upload.token_ttl_seconds
+const defaultTokenTTL = 15 * time.Minute
+
 type Handler struct {
 	uploadService *Service
-	storageConfig *config.StorageConfig
+	storageConfig *config.Store
 	ctx           context.Context
+	tokens        *auth.TokenIssuer // Optional; enables POST /v1/uploads/tokens
 }
 
-func NewHandler(ctx context.Context, uploadService *Service, storageConfig *config.StorageConfig) *Handler {
+func NewHandler(ctx context.Context, uploadService *Service, storageConfig *config.Store) *Handler {
 	return &Handler{
 		uploadService: uploadService,
 		storageConfig: storageConfig,
@@ -24,6 +32,79 @@ func NewHandler(ctx context.Context, uploadService *Service, storageConfig *conf
 	}
 }
 
+// SetTokenIssuer enables minting scoped upload tokens
+func (h *Handler) SetTokenIssuer(issuer *auth.TokenIssuer) {
+	h.tokens = issuer
+}
+
+// HandleCreateToken handles POST /v1/uploads/tokens
+// Mints a short-lived token that lets a client presign uploads for one profile without the API key.
+func (h *Handler) HandleCreateToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeError(w, http.StatusMethodNotAllowed, ErrBadRequest, "Method not allowed", "")
+		return
+	}
+	if h.tokens == nil {
+		h.writeError(w, http.StatusNotImplemented, ErrTokensDisabled, "Upload tokens are not enabled", "Set UPLOAD_SIGNING_KEYS to enable them")
+		return
+	}
+	// Tokens can't be used to mint further tokens
+	if _, ok := auth.UploadClaimsFromContext(r.Context()); ok {
+		h.writeError(w, http.StatusForbidden, ErrTokenScope, "Upload tokens cannot mint tokens", "Use the API key")
+		return
+	}
+
+	var req TokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, ErrBadRequest, "Invalid request body", "")
+		return
+	}
+	if req.Profile == "" {
+		h.writeError(w, http.StatusBadRequest, ErrBadRequest, "profile is required", "")
+		return
+	}
+	if !h.authorizeKey(w, r, auth.ScopeUploadPresign, req.Profile) {
+		return
+	}
+	profile := h.storageConfig.Current().GetProfile(req.Profile)
+	if profile == nil {
+		h.writeError(w, http.StatusBadRequest, ErrBadRequest, fmt.Sprintf("No configuration for profile: %s", req.Profile), "Configure profile in your storage config")
+		return
+	}
+
+	if req.MaxSizeBytes < 0 {
+		h.writeError(w, http.StatusBadRequest, ErrBadRequest, "max_size_bytes must be positive", "")
+		return
+	}
+	if profile.SizeMaxBytes > 0 && req.MaxSizeBytes > profile.SizeMaxBytes {
+		h.writeError(w, http.StatusBadRequest, ErrSizeTooLarge, fmt.Sprintf("max_size_bytes must be between 1 and %d", profile.SizeMaxBytes), "")
+		return
+	}
+	if req.MaxSizeBytes == 0 {
+		req.MaxSizeBytes = profile.SizeMaxBytes
+	}
+
+	ttl, ok := h.tokenTTL(w, req.TTLSeconds)
+	if !ok {
+		return
+	}
+
+	token, expiresAt, err := h.tokens.Mint(auth.UploadClaims{
+		Profile:       req.Profile,
+		KeyBasePrefix: req.KeyBasePrefix,
+		MaxSizeBytes:  req.MaxSizeBytes,
+	}, ttl)
+	if err != nil {
+		fmt.Printf("Token error: %v\n", err)
+		h.writeError(w, http.StatusInternalServerError, ErrBadRequest, "Failed to mint upload token", "")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(TokenResponse{Token: token, ExpiresAt: expiresAt})
+}
+
 // HandlePresign handles POST /v1/uploads/presign
 func (h *Handler) HandlePresign(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -63,9 +144,21 @@ func (h *Handler) HandlePresign(w http.ResponseWriter, r *http.Request) {
 		h.writeError(w, http.StatusBadRequest, ErrBadRequest, "profile is required", "")
 		return
 	}
+	if strings.Contains(req.Tenant, "/") || strings.Contains(req.Tenant, "..") {
+		h.writeError(w, http.StatusBadRequest, ErrBadRequest, "tenant can't contain '/' or '..'", "")
+		return
+	}
+	// {ext} is matched as a single extension when the upload is finalized
+	if strings.ContainsAny(req.Ext, "./") {
+		h.writeError(w, http.StatusBadRequest, ErrBadRequest, "ext can't contain '/' or '.'", "Send the extension without a leading dot, as in jpg")
+		return
+	}
+	if !h.authorizeUpload(w, r, req.Profile, req.KeyBase, req.SizeBytes) {
+		return
+	}
 
 	// Get profile configuration
-	profile := h.storageConfig.GetProfile(req.Profile)
+	profile := h.storageConfig.Current().GetProfile(req.Profile)
 	if profile == nil {
 		h.writeError(w, http.StatusBadRequest, ErrBadRequest, fmt.Sprintf("No configuration for profile: %s", req.Profile), "Configure profile in your storage config")
 		return
@@ -95,6 +188,11 @@ func (h *Handler) HandlePresign(w http.ResponseWriter, r *http.Request) {
 			h.writeError(w, http.StatusBadRequest, ErrSizeTooLarge, err.Error(), "Reduce file size or check size_max_bytes in configuration")
 			return
 		}
+		var reqErr *RequestError
+		if errors.As(err, &reqErr) {
+			h.writeError(w, http.StatusBadRequest, reqErr.Code, reqErr.Message, reqErr.Hint)
+			return
+		}
 		// Log the actual error for debugging
 		fmt.Printf("Upload error: %v\n", err)
 		h.writeError(w, http.StatusInternalServerError, ErrBadRequest, fmt.Sprintf("Failed to generate presigned upload: %v", err), "")
@@ -107,6 +205,64 @@ func (h *Handler) HandlePresign(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(presignResp)
 }
 
+// HandlePresignParts handles POST /v1/uploads/presign/parts
+// Returns the next batch of presigned part URLs for an in-progress multipart upload.
+func (h *Handler) HandlePresignParts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeError(w, http.StatusMethodNotAllowed, ErrBadRequest, "Method not allowed", "")
+		return
+	}
+
+	var req PresignPartsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, ErrBadRequest, "Invalid request body", "")
+		return
+	}
+
+	// Validate required fields
+	if req.UploadID == "" {
+		h.writeError(w, http.StatusBadRequest, ErrBadRequest, "upload_id is required", "")
+		return
+	}
+	if req.ObjectKey == "" {
+		h.writeError(w, http.StatusBadRequest, ErrBadRequest, "object_key is required", "")
+		return
+	}
+	if req.StartPart < 1 {
+		h.writeError(w, http.StatusBadRequest, ErrInvalidPartRange, "start_part must be greater than 0", "")
+		return
+	}
+	if req.Count < 1 {
+		h.writeError(w, http.StatusBadRequest, ErrBadRequest, "count must be greater than 0", "")
+		return
+	}
+	// Part URLs write to the object key, so it must be the upload key of the asset the credentials cover
+	profile, ok := h.uploadTarget(w, r, req.Profile, req.Tenant, req.KeyBase, req.ObjectKey)
+	if !ok {
+		return
+	}
+
+	partsResp, err := h.uploadService.PresignParts(h.ctx, &req, profile)
+	if err != nil {
+		var reqErr *RequestError
+		if errors.As(err, &reqErr) {
+			status := http.StatusBadRequest
+			if reqErr.Code == ErrInvalidUploadID {
+				status = http.StatusNotFound
+			}
+			h.writeError(w, status, reqErr.Code, reqErr.Message, reqErr.Hint)
+			return
+		}
+		fmt.Printf("Presign parts error: %v\n", err)
+		h.writeError(w, http.StatusInternalServerError, ErrBadRequest, fmt.Sprintf("Failed to presign parts: %v", err), "")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(partsResp)
+}
+
 // HandleCompleteMultipart handles POST /v1/uploads/{object_key}/complete/{upload_id}
 func (h *Handler) HandleCompleteMultipart(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -138,6 +294,13 @@ func (h *Handler) HandleCompleteMultipart(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// Upload target, from the body or the query string of the presigned complete URL
+	profileName, tenant, keyBase := processingTarget(r, req.Profile, req.Tenant, req.KeyBase)
+	profile, ok := h.uploadTarget(w, r, profileName, tenant, keyBase, objectKey)
+	if !ok {
+		return
+	}
+
 	// Complete the multipart upload
 	err := h.uploadService.CompleteMultipartUpload(h.ctx, objectKey, uploadID, &req)
 	if err != nil {
@@ -146,13 +309,75 @@ func (h *Handler) HandleCompleteMultipart(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	response := map[string]string{"status": "completed", "object_key": objectKey}
+	h.recordObjectKey(profile, tenant, keyBase, objectKey)
+	// Every completed upload is new, so it is always processed
+	if jobID := h.queueProcessing(profileName, profile, objectKey, keyBase, ""); jobID != "" {
+		response["job_id"] = jobID
+	}
+
 	// Return success response
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	response := map[string]string{"status": "completed", "object_key": objectKey}
 	_ = json.NewEncoder(w).Encode(response)
 }
 
+// HandleFinalize handles POST /v1/uploads/{object_key}/finalize
+// Verifies a single PUT upload against the profile and queues its post-upload processing.
+func (h *Handler) HandleFinalize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeError(w, http.StatusMethodNotAllowed, ErrBadRequest, "Method not allowed", "")
+		return
+	}
+
+	objectKey := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/uploads/"), "/finalize")
+	if objectKey == "" || objectKey == r.URL.Path {
+		h.writeError(w, http.StatusBadRequest, ErrBadRequest, "Invalid URL format", "Expected /v1/uploads/{object_key}/finalize")
+		return
+	}
+
+	// The body is optional; the presigned finalize URL carries profile, key_base and tenant in its query string
+	var req FinalizeRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.writeError(w, http.StatusBadRequest, ErrBadRequest, "Invalid request body", "")
+			return
+		}
+	}
+	profileName, tenant, keyBase := processingTarget(r, req.Profile, req.Tenant, req.KeyBase)
+	profile, ok := h.uploadTarget(w, r, profileName, tenant, keyBase, objectKey)
+	if !ok {
+		return
+	}
+
+	info, err := h.uploadService.FinalizeUpload(h.ctx, objectKey, profile)
+	if err != nil {
+		var reqErr *RequestError
+		if errors.As(err, &reqErr) {
+			status := http.StatusBadRequest
+			if reqErr.Code == ErrObjectNotFound {
+				status = http.StatusNotFound
+			}
+			h.writeError(w, status, reqErr.Code, reqErr.Message, reqErr.Hint)
+			return
+		}
+		fmt.Printf("Finalize upload error: %v\n", err)
+		h.writeError(w, http.StatusInternalServerError, ErrBadRequest, fmt.Sprintf("Failed to finalize upload: %v", err), "")
+		return
+	}
+	h.recordObjectKey(profile, tenant, keyBase, objectKey)
+	// Finalizing the same upload again returns the job already queued for it
+	jobID := h.queueProcessing(profileName, profile, objectKey, keyBase, info.ETag)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(FinalizeResponse{
+		Status:    "finalized",
+		ObjectKey: objectKey,
+		JobID:     jobID,
+	})
+}
+
 // HandleAbortMultipart handles DELETE /v1/uploads/{object_key}/abort/{upload_id}
 func (h *Handler) HandleAbortMultipart(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
@@ -171,6 +396,12 @@ func (h *Handler) HandleAbortMultipart(w http.ResponseWriter, r *http.Request) {
 	objectKey := parts[0]
 	uploadID := parts[1]
 
+	// The presigned abort URL carries profile, key_base and tenant in its query string
+	profileName, tenant, keyBase := processingTarget(r, "", "", "")
+	if _, ok := h.uploadTarget(w, r, profileName, tenant, keyBase, objectKey); !ok {
+		return
+	}
+
 	// Abort the multipart upload
 	err := h.uploadService.AbortMultipartUpload(h.ctx, objectKey, uploadID)
 	if err != nil {
@@ -187,7 +418,8 @@ func (h *Handler) HandleAbortMultipart(w http.ResponseWriter, r *http.Request) {
 }
 
 // HandleDeleteAsset handles DELETE /v1/assets/{profile}/{key_base}
-// Deletes the original file and all generated thumbnails for an asset.
+// Deletes the original file and all generated thumbnails for an asset; ?tenant= selects the
+// tenant its original was presigned for.
 func (h *Handler) HandleDeleteAsset(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
 		h.writeError(w, http.StatusMethodNotAllowed, ErrBadRequest, "Method not allowed", "")
@@ -205,15 +437,19 @@ func (h *Handler) HandleDeleteAsset(w http.ResponseWriter, r *http.Request) {
 	profileName := path[:slashIdx]
 	keyBase := path[slashIdx+1:]
 
+	if !h.authorizeKey(w, r, auth.ScopeAssetsDelete, profileName) {
+		return
+	}
+
 	// Look up profile config
-	profile := h.storageConfig.GetProfile(profileName)
+	profile := h.storageConfig.Current().GetProfile(profileName)
 	if profile == nil {
 		h.writeError(w, http.StatusBadRequest, ErrBadRequest, fmt.Sprintf("Unknown profile: %s", profileName), "")
 		return
 	}
 
 	// Delete the original + thumbnails
-	deleted, err := h.uploadService.DeleteAsset(h.ctx, profile, keyBase)
+	deleted, err := h.uploadService.DeleteAsset(h.ctx, profile, r.URL.Query().Get("tenant"), keyBase)
 	if err != nil {
 		fmt.Printf("Delete asset error: %v\n", err)
 		h.writeError(w, http.StatusInternalServerError, ErrStorageDenied, fmt.Sprintf("Failed to delete asset: %v", err), "")
@@ -231,6 +467,165 @@ func (h *Handler) HandleDeleteAsset(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(response)
 }
 
+// HandleCreateReadToken handles POST /v1/assets/{profile}/{key_base}/token, minting a token
+// that grants read access to one asset of a private profile
+func (h *Handler) HandleCreateReadToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeError(w, http.StatusMethodNotAllowed, ErrBadRequest, "Method not allowed", "")
+		return
+	}
+	if h.tokens == nil {
+		h.writeError(w, http.StatusNotImplemented, ErrTokensDisabled, "Read tokens are not enabled", "Set UPLOAD_SIGNING_KEYS to enable them")
+		return
+	}
+
+	path := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/assets/"), "/token")
+	slashIdx := strings.Index(path, "/")
+	if slashIdx < 1 || slashIdx == len(path)-1 {
+		h.writeError(w, http.StatusBadRequest, ErrBadRequest, "Invalid URL format", "Expected /v1/assets/{profile}/{key_base}/token")
+		return
+	}
+	profileName := path[:slashIdx]
+	keyBase := path[slashIdx+1:]
+
+	if !h.authorizeKey(w, r, auth.ScopeAssetsRead, profileName) {
+		return
+	}
+	if h.storageConfig.Current().GetProfile(profileName) == nil {
+		h.writeError(w, http.StatusBadRequest, ErrBadRequest, fmt.Sprintf("Unknown profile: %s", profileName), "")
+		return
+	}
+
+	// The body is optional
+	var req ReadTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		h.writeError(w, http.StatusBadRequest, ErrBadRequest, "Invalid request body", "")
+		return
+	}
+	ttl, ok := h.tokenTTL(w, req.TTLSeconds)
+	if !ok {
+		return
+	}
+
+	token, expiresAt, err := h.tokens.MintRead(auth.ReadClaims{Profile: profileName, KeyBase: keyBase}, ttl)
+	if err != nil {
+		fmt.Printf("Token error: %v\n", err)
+		h.writeError(w, http.StatusInternalServerError, ErrBadRequest, "Failed to mint read token", "")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(TokenResponse{Token: token, ExpiresAt: expiresAt})
+}
+
+// tokenTTL validates a requested token lifetime against upload.token_ttl_seconds, defaulting to the maximum.
+// It writes a 400 and returns false when the request asks for more.
+func (h *Handler) tokenTTL(w http.ResponseWriter, ttlSeconds int64) (time.Duration, bool) {
+	maxTTL := defaultTokenTTL
+	if seconds := h.storageConfig.Current().Upload.TokenTTLSeconds; seconds > 0 {
+		maxTTL = time.Duration(seconds) * time.Second
+	}
+	ttl := time.Duration(ttlSeconds) * time.Second
+	if ttlSeconds < 0 || ttl > maxTTL {
+		h.writeError(w, http.StatusBadRequest, ErrBadRequest, fmt.Sprintf("ttl_seconds must be between 1 and %d", int64(maxTTL.Seconds())), "")
+		return 0, false
+	}
+	if ttl == 0 {
+		ttl = maxTTL
+	}
+	return ttl, true
+}
+
+// queueProcessing queues post-upload processing and returns the job ID.
+// The upload itself has already succeeded, so a queueing failure is logged rather than returned.
+func (h *Handler) queueProcessing(profileName string, profile *config.Profile, objectKey, keyBase, etag string) string {
+	jobID, err := h.uploadService.QueueProcessing(profileName, profile, objectKey, keyBase, etag)
+	if err != nil {
+		fmt.Printf("Failed to queue processing for %s: %v\n", objectKey, err)
+		return ""
+	}
+	return jobID
+}
+
+// recordObjectKey records a finished upload's key, checked by uploadTarget, for lookups. The upload
+// itself has already succeeded, so a failure is logged rather than returned; finalizing again records it.
+func (h *Handler) recordObjectKey(profile *config.Profile, tenant, keyBase, objectKey string) {
+	if err := h.uploadService.RecordObjectKey(h.ctx, profile, tenant, keyBase, objectKey); err != nil {
+		fmt.Printf("Failed to record object key %s: %v\n", objectKey, err)
+	}
+}
+
+// uploadTarget returns the profile of an upload being finalized, completed or aborted. Its object
+// key comes from the URL, so the key must be one the profile's storage_path renders for key_base
+// and tenant, and the credentials must cover that key_base. It writes an error and returns false otherwise.
+func (h *Handler) uploadTarget(w http.ResponseWriter, r *http.Request, profileName, tenant, keyBase, objectKey string) (*config.Profile, bool) {
+	if profileName == "" || keyBase == "" {
+		h.writeError(w, http.StatusBadRequest, ErrBadRequest, "profile and key_base are required", "Send those of the presign request; the URLs returned by presign carry them in their query string")
+		return nil, false
+	}
+	if !h.authorizeUpload(w, r, profileName, keyBase, 0) {
+		return nil, false
+	}
+	profile := h.storageConfig.Current().GetProfile(profileName)
+	if profile == nil {
+		h.writeError(w, http.StatusBadRequest, ErrBadRequest, fmt.Sprintf("Unknown profile: %s", profileName), "")
+		return nil, false
+	}
+	if err := h.uploadService.CheckObjectKey(profile, tenant, keyBase, objectKey); err != nil {
+		var reqErr *RequestError
+		if errors.As(err, &reqErr) {
+			h.writeError(w, http.StatusForbidden, reqErr.Code, reqErr.Message, reqErr.Hint)
+		} else {
+			h.writeError(w, http.StatusForbidden, ErrObjectKeyMismatch, err.Error(), "")
+		}
+		return nil, false
+	}
+	return profile, true
+}
+
+// authorizeUpload enforces the scope of the request's credentials for an upload to profile:
+// an API key must grant upload:presign for the profile, and an upload token must cover the upload.
+// It writes a 403 and returns false when the request falls outside them.
+func (h *Handler) authorizeUpload(w http.ResponseWriter, r *http.Request, profile, keyBase string, sizeBytes int64) bool {
+	if !h.authorizeKey(w, r, auth.ScopeUploadPresign, profile) {
+		return false
+	}
+	claims, ok := auth.UploadClaimsFromContext(r.Context())
+	if !ok {
+		return true
+	}
+	if err := claims.Authorize(profile, keyBase, sizeBytes); err != nil {
+		h.writeError(w, http.StatusForbidden, ErrTokenScope, err.Error(), "Request a token for this upload")
+		return false
+	}
+	return true
+}
+
+// authorizeKey checks the request's API key for scope and profile (empty skips the profile check).
+// It writes a 403 and returns false when the key doesn't grant them.
+func (h *Handler) authorizeKey(w http.ResponseWriter, r *http.Request, scope, profile string) bool {
+	if err := auth.Authorize(r.Context(), scope, profile); err != nil {
+		h.writeError(w, http.StatusForbidden, ErrForbidden, err.Error(), "Use an API key with this scope and profile")
+		return false
+	}
+	return true
+}
+
+// processingTarget returns the profile, tenant and key_base to process, preferring the request body over the query string
+func processingTarget(r *http.Request, profile, tenant, keyBase string) (string, string, string) {
+	if profile == "" {
+		profile = r.URL.Query().Get("profile")
+	}
+	if tenant == "" {
+		tenant = r.URL.Query().Get("tenant")
+	}
+	if keyBase == "" {
+		keyBase = r.URL.Query().Get("key_base")
+	}
+	return profile, tenant, keyBase
+}
+
 // writeError writes a standardized error response
 func (h *Handler) writeError(w http.ResponseWriter, statusCode int, code, message, hint string) {
 	errorResp := ErrorResponse{
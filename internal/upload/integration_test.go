@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"path"
 	"strings"
 	"testing"
 	"time"
@@ -13,6 +16,7 @@ import (
 	"mediaflow/internal/auth"
 	"mediaflow/internal/config"
 	"mediaflow/internal/s3"
+	"mediaflow/internal/storage"
 )
 
 // Integration tests that test the complete upload flow with authentication
@@ -217,7 +221,7 @@ func TestUploadIntegration_ValidationFlow(t *testing.T) {
 
 	handler := &Handler{
 		uploadService: realService,
-		storageConfig: storageConfig,
+		storageConfig: config.NewStore(storageConfig, nil),
 		ctx:          context.Background(),
 	}
 
@@ -375,7 +379,7 @@ func TestUploadIntegration_MultipartStrategy(t *testing.T) {
 
 	handler := &Handler{
 		uploadService: realService,
-		storageConfig: storageConfig,
+		storageConfig: config.NewStore(storageConfig, nil),
 		ctx:          context.Background(),
 	}
 
@@ -515,7 +519,7 @@ func TestUploadIntegration_CompleteMultipartFlow(t *testing.T) {
 
 	handler := &Handler{
 		uploadService: realService,
-		storageConfig: storageConfig,
+		storageConfig: config.NewStore(storageConfig, nil),
 		ctx:          context.Background(),
 	}
 
@@ -532,7 +536,7 @@ func TestUploadIntegration_CompleteMultipartFlow(t *testing.T) {
 	}
 
 	body, _ := json.Marshal(requestBody)
-	req := httptest.NewRequest("POST", "/v1/uploads/originals/test-video.mp4/complete/test-upload-id", bytes.NewReader(body))
+	req := httptest.NewRequest("POST", "/v1/uploads/originals/test-video.mp4/complete/test-upload-id?profile=video&key_base=test-video", bytes.NewReader(body))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer test-api-key")
 
@@ -582,7 +586,9 @@ func TestUploadIntegration_AbortMultipartFlow(t *testing.T) {
 
 	handler := &Handler{
 		uploadService: realService,
-		storageConfig: &config.StorageConfig{},
+		storageConfig: config.NewStore(&config.StorageConfig{Profiles: map[string]config.Profile{
+			"video": {Kind: "video", StoragePath: "originals/{key_base}.{ext}"},
+		}}, nil),
 		ctx:          context.Background(),
 	}
 
@@ -590,7 +596,7 @@ func TestUploadIntegration_AbortMultipartFlow(t *testing.T) {
 	authConfig := &auth.Config{APIKey: cfg.APIKey}
 	middleware := auth.APIKeyMiddleware(authConfig)
 	
-	req := httptest.NewRequest("DELETE", "/v1/uploads/originals/test-video.mp4/abort/test-upload-id", nil)
+	req := httptest.NewRequest("DELETE", "/v1/uploads/originals/test-video.mp4/abort/test-upload-id?profile=video&key_base=test-video", nil)
 	req.Header.Set("Authorization", "Bearer test-api-key")
 
 	authenticatedHandler := middleware(http.HandlerFunc(handler.HandleAbortMultipart))
@@ -626,7 +632,9 @@ func TestUploadIntegration_CompleteMultipartAuth(t *testing.T) {
 
 	handler := &Handler{
 		uploadService: realService,
-		storageConfig: &config.StorageConfig{},
+		storageConfig: config.NewStore(&config.StorageConfig{Profiles: map[string]config.Profile{
+			"video": {Kind: "video", StoragePath: "originals/{key_base}.{ext}"},
+		}}, nil),
 		ctx:          context.Background(),
 	}
 
@@ -662,7 +670,7 @@ func TestUploadIntegration_CompleteMultipartAuth(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			body, _ := json.Marshal(requestBody)
-			req := httptest.NewRequest("POST", "/v1/uploads/originals/test-video.mp4/complete/test-upload-id", bytes.NewReader(body))
+			req := httptest.NewRequest("POST", "/v1/uploads/originals/test-video.mp4/complete/test-upload-id?profile=video&key_base=test-video", bytes.NewReader(body))
 			req.Header.Set("Content-Type", "application/json")
 			
 			if tt.authHeader != "" {
@@ -678,4 +686,627 @@ func TestUploadIntegration_CompleteMultipartAuth(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+func TestUploadIntegration_PresignPartsFlow(t *testing.T) {
+	storageConfig := &config.StorageConfig{
+		Profiles: map[string]config.Profile{
+			"video": {
+				Kind:              "video",
+				TokenTTLSeconds:   900,
+				StoragePath:       "originals/{key_base}.{ext}",
+				MaxBatchSize:      20,
+				PartURLTTLSeconds: 1800,
+			},
+		},
+	}
+
+	mockS3 := &MockS3Client{
+		listPartsFunc: func(ctx context.Context, key, uploadID string) ([]s3.PartInfo, error) {
+			if uploadID != "test-upload-id" || key != "originals/large-video.mp4" {
+				return nil, storage.ErrNoSuchUpload
+			}
+			return nil, nil
+		},
+	}
+
+	handler := &Handler{
+		uploadService: NewService(mockS3, &config.Config{S3Bucket: "test-bucket"}),
+		storageConfig: config.NewStore(storageConfig, nil),
+		ctx:           context.Background(),
+	}
+
+	authenticatedHandler := auth.APIKeyMiddleware(&auth.Config{APIKey: "test-api-key"})(http.HandlerFunc(handler.HandlePresignParts))
+
+	tests := []struct {
+		name           string
+		requestBody    PresignPartsRequest
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name:           "Valid batch",
+			requestBody:    PresignPartsRequest{UploadID: "test-upload-id", ObjectKey: "originals/large-video.mp4", Profile: "video", KeyBase: "large-video", StartPart: 11, Count: 10, TotalParts: 125},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Batch too large",
+			requestBody:    PresignPartsRequest{UploadID: "test-upload-id", ObjectKey: "originals/large-video.mp4", Profile: "video", KeyBase: "large-video", StartPart: 11, Count: 50},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   ErrBatchSizeExceeded,
+		},
+		{
+			name:           "Object key does not match upload",
+			requestBody:    PresignPartsRequest{UploadID: "test-upload-id", ObjectKey: "originals/other.mp4", Profile: "video", KeyBase: "other", StartPart: 11, Count: 10},
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   ErrInvalidUploadID,
+		},
+		{
+			name:           "Object key of another asset",
+			requestBody:    PresignPartsRequest{UploadID: "test-upload-id", ObjectKey: "originals/other.mp4", Profile: "video", KeyBase: "large-video", StartPart: 11, Count: 10},
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   ErrObjectKeyMismatch,
+		},
+		{
+			name:           "Missing key_base",
+			requestBody:    PresignPartsRequest{UploadID: "test-upload-id", ObjectKey: "originals/large-video.mp4", Profile: "video", StartPart: 11, Count: 10},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   ErrBadRequest,
+		},
+		{
+			name:           "Unknown profile",
+			requestBody:    PresignPartsRequest{UploadID: "test-upload-id", ObjectKey: "originals/large-video.mp4", Profile: "nope", KeyBase: "large-video", StartPart: 11, Count: 10},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   ErrBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(tt.requestBody)
+			req := httptest.NewRequest("POST", "/v1/uploads/presign/parts", bytes.NewReader(body))
+			req.Header.Set("Authorization", "Bearer test-api-key")
+
+			rr := httptest.NewRecorder()
+			authenticatedHandler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d. Body: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+
+			if tt.expectedCode != "" {
+				var errResp ErrorResponse
+				if err := json.Unmarshal(rr.Body.Bytes(), &errResp); err != nil {
+					t.Fatalf("Failed to parse error response: %v", err)
+				}
+				if errResp.Code != tt.expectedCode {
+					t.Errorf("Expected code %s, got %s", tt.expectedCode, errResp.Code)
+				}
+				return
+			}
+
+			var resp PresignPartsResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Failed to parse response: %v", err)
+			}
+			if len(resp.Parts) != 10 || resp.Parts[0].PartNumber != 11 {
+				t.Errorf("Expected parts 11-20, got %d parts", len(resp.Parts))
+			}
+			if !resp.BatchInfo.HasMoreParts || resp.BatchInfo.NextPartNumber != 21 {
+				t.Errorf("Unexpected batch info: %+v", resp.BatchInfo)
+			}
+		})
+	}
+}
+
+// File signatures recognized by http.DetectContentType
+var (
+	pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	mp4Header = []byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom")
+	elfHeader = []byte("\x7fELF\x02\x01\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+)
+
+// recordingProcessor captures queued processing jobs
+type recordingProcessor struct {
+	jobs [][4]string
+}
+
+func (p *recordingProcessor) Enqueue(profile, objectKey, keyBase, etag string) (string, error) {
+	p.jobs = append(p.jobs, [4]string{profile, objectKey, keyBase, etag})
+	return fmt.Sprintf("job-%d", len(p.jobs)), nil
+}
+
+func TestUploadIntegration_FinalizeQueuesProcessing(t *testing.T) {
+	storageConfig := &config.StorageConfig{
+		Profiles: map[string]config.Profile{
+			"avatar": {
+				Kind:         "image",
+				AllowedMimes: []string{"image/png"},
+				StoragePath:  "originals/avatars/{key_base}",
+				ThumbFolder:  "thumbnails/avatars",
+				Sizes:        []string{"128", "256"},
+			},
+			"video": {
+				Kind:         "video",
+				AllowedMimes: []string{"video/mp4"},
+				StoragePath:  "originals/videos/{key_base}.{ext}",
+			},
+		},
+	}
+
+	mockS3 := &MockS3Client{
+		headObjectFunc: func(ctx context.Context, key string) (*storage.ObjectInfo, error) {
+			if strings.Contains(key, "missing") {
+				return nil, storage.ErrNotFound
+			}
+			return &storage.ObjectInfo{Size: 1024, ETag: `"` + path.Base(key) + `"`}, nil
+		},
+		getObjectRangeFunc: func(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+			if strings.Contains(key, "videos/") {
+				return io.NopCloser(bytes.NewReader(mp4Header)), nil
+			}
+			return io.NopCloser(bytes.NewReader(pngHeader)), nil
+		},
+	}
+	processor := &recordingProcessor{}
+	service := NewService(mockS3, &config.Config{S3Bucket: "test-bucket"})
+	service.SetProcessor(processor)
+	handler := &Handler{uploadService: service, storageConfig: config.NewStore(storageConfig, nil), ctx: context.Background()}
+
+	tests := []struct {
+		name           string
+		url            string
+		body           string
+		expectedStatus int
+		expectedJobID  string
+	}{
+		{"Profile in query", "/v1/uploads/originals/avatars/me/finalize?profile=avatar&key_base=me", "", http.StatusOK, "job-1"},
+		{"Profile in body", "/v1/uploads/originals/avatars/you/finalize", `{"profile":"avatar","key_base":"you"}`, http.StatusOK, "job-2"},
+		{"Profile without processing", "/v1/uploads/originals/videos/clip.mp4/finalize?profile=video&key_base=clip", "", http.StatusOK, ""},
+		{"Missing profile", "/v1/uploads/originals/avatars/me/finalize?key_base=me", "", http.StatusBadRequest, ""},
+		{"Missing key_base", "/v1/uploads/originals/avatars/me/finalize?profile=avatar", "", http.StatusBadRequest, ""},
+		{"Unknown profile", "/v1/uploads/originals/avatars/me/finalize?profile=nope&key_base=me", "", http.StatusBadRequest, ""},
+		{"Object key of another key_base", "/v1/uploads/originals/avatars/someone-else/finalize?profile=avatar&key_base=me", "", http.StatusForbidden, ""},
+		{"Object key outside the profile", "/v1/uploads/originals/videos/clip.mp4/finalize?profile=avatar&key_base=clip", "", http.StatusForbidden, ""},
+		{"Object not uploaded", "/v1/uploads/originals/avatars/missing/finalize?profile=avatar&key_base=missing", "", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.url, strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			auth.APIKeyMiddleware(&auth.Config{})(http.HandlerFunc(handler.HandleFinalize)).ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+			var resp FinalizeResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if resp.JobID != tt.expectedJobID {
+				t.Errorf("Expected job_id %q, got %q", tt.expectedJobID, resp.JobID)
+			}
+		})
+	}
+
+	expected := [][4]string{
+		{"avatar", "originals/avatars/me", "me", `"me"`},
+		{"avatar", "originals/avatars/you", "you", `"you"`},
+	}
+	if fmt.Sprint(processor.jobs) != fmt.Sprint(expected) {
+		t.Errorf("Queued jobs = %v, expected %v", processor.jobs, expected)
+	}
+}
+
+func TestUploadIntegration_CompleteMultipartQueuesProcessing(t *testing.T) {
+	storageConfig := &config.StorageConfig{
+		Profiles: map[string]config.Profile{
+			"photo": {
+				Kind:        "image",
+				StoragePath: "originals/photos/{key_base}",
+				ThumbFolder: "thumbnails/photos",
+				Sizes:       []string{"512"},
+			},
+		},
+	}
+	processor := &recordingProcessor{}
+	service := NewService(&MockS3Client{}, &config.Config{S3Bucket: "test-bucket"})
+	service.SetProcessor(processor)
+	handler := &Handler{uploadService: service, storageConfig: config.NewStore(storageConfig, nil), ctx: context.Background()}
+
+	body := `{"parts":[{"part_number":1,"etag":"\"abc\""}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/uploads/originals/photos/big/complete/upload-1?profile=photo&key_base=big", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	auth.APIKeyMiddleware(&auth.Config{})(http.HandlerFunc(handler.HandleCompleteMultipart)).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp map[string]string
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+	if resp["job_id"] != "job-1" {
+		t.Errorf("Expected job_id job-1, got %q", resp["job_id"])
+	}
+	if len(processor.jobs) != 1 || processor.jobs[0] != [4]string{"photo", "originals/photos/big", "big", ""} {
+		t.Errorf("Unexpected queued jobs: %v", processor.jobs)
+	}
+}
+
+func TestUploadIntegration_FinalizeVerifiesUpload(t *testing.T) {
+	storageConfig := &config.StorageConfig{
+		Profiles: map[string]config.Profile{
+			"avatar": {
+				Kind:         "image",
+				AllowedMimes: []string{"image/png", "image/jpeg"},
+				SizeMaxBytes: 2048,
+				StoragePath:  "originals/avatars/{key_base}",
+			},
+		},
+	}
+
+	tests := []struct {
+		name            string
+		size            int64
+		content         []byte
+		expectedStatus  int
+		expectedCode    string
+		expectedDeleted bool
+	}{
+		{"Valid PNG", 1024, pngHeader, http.StatusOK, "", false},
+		{"Too large", 4096, pngHeader, http.StatusBadRequest, ErrSizeTooLarge, true},
+		{"Executable presigned as PNG", 1024, elfHeader, http.StatusBadRequest, ErrMimeNotAllowed, true},
+		{"Empty object", 0, nil, http.StatusBadRequest, ErrMimeNotAllowed, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deleted []string
+			mockS3 := &MockS3Client{
+				headObjectFunc: func(ctx context.Context, key string) (*storage.ObjectInfo, error) {
+					return &storage.ObjectInfo{Size: tt.size, ContentType: "image/png"}, nil
+				},
+				getObjectRangeFunc: func(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+					if offset != 0 || length > 512 {
+						t.Errorf("Expected a ranged read of at most the first 512 bytes, got %d+%d", offset, length)
+					}
+					return io.NopCloser(bytes.NewReader(tt.content)), nil
+				},
+				deleteObjectFunc: func(ctx context.Context, key string) error {
+					deleted = append(deleted, key)
+					return nil
+				},
+			}
+			handler := &Handler{
+				uploadService: NewService(mockS3, &config.Config{S3Bucket: "test-bucket"}),
+				storageConfig: config.NewStore(storageConfig, nil),
+				ctx:           context.Background(),
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/uploads/originals/avatars/me/finalize?profile=avatar&key_base=me", nil)
+			rr := httptest.NewRecorder()
+			auth.APIKeyMiddleware(&auth.Config{})(http.HandlerFunc(handler.HandleFinalize)).ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if tt.expectedCode != "" {
+				var errResp ErrorResponse
+				_ = json.NewDecoder(rr.Body).Decode(&errResp)
+				if errResp.Code != tt.expectedCode {
+					t.Errorf("Expected code %s, got %s", tt.expectedCode, errResp.Code)
+				}
+			}
+			if tt.expectedDeleted != (len(deleted) == 1 && deleted[0] == "originals/avatars/me") {
+				t.Errorf("Expected deleted=%v, got deletes %v", tt.expectedDeleted, deleted)
+			}
+		})
+	}
+}
+
+func TestUploadIntegration_UploadTokens(t *testing.T) {
+	storageConfig := &config.StorageConfig{
+		Upload: config.UploadConfig{TokenTTLSeconds: 300},
+		Profiles: map[string]config.Profile{
+			"avatar": {
+				Kind:                 "image",
+				AllowedMimes:         []string{"image/jpeg"},
+				SizeMaxBytes:         5 * 1024 * 1024,
+				MultipartThresholdMB: 15,
+				PartSizeMB:           8,
+				TokenTTLSeconds:      900,
+				StoragePath:          "originals/avatars/{key_base}.{ext}",
+			},
+			"banner": {
+				Kind:         "image",
+				AllowedMimes: []string{"image/jpeg"},
+				SizeMaxBytes: 5 * 1024 * 1024,
+				StoragePath:  "originals/banners/{key_base}.{ext}",
+			},
+			"logo": {
+				Kind:        "image",
+				StoragePath: "originals/logos/{key_base}",
+			},
+		},
+	}
+	issuer, err := auth.NewTokenIssuer(auth.AlgHS256, "", "k1:test-secret")
+	if err != nil {
+		t.Fatalf("NewTokenIssuer failed: %v", err)
+	}
+	handler := &Handler{
+		uploadService: NewService(&MockS3Client{}, &config.Config{S3Bucket: "test-bucket"}),
+		storageConfig: config.NewStore(storageConfig, nil),
+		ctx:           context.Background(),
+	}
+	handler.SetTokenIssuer(issuer)
+	apiKey := auth.APIKeyMiddleware(&auth.Config{APIKey: "test-api-key"})
+	mint := apiKey(http.HandlerFunc(handler.HandleCreateToken))
+	presign := auth.UploadTokenMiddleware(issuer, apiKey)(http.HandlerFunc(handler.HandlePresign))
+
+	// Mint a token with the API key
+	body := `{"profile":"avatar","key_base_prefix":"user-42/","max_size_bytes":1024}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/uploads/tokens", strings.NewReader(body))
+	req.Header.Set("X-API-Key", "test-api-key")
+	rr := httptest.NewRecorder()
+	mint.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 minting a token, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var tokenResp TokenResponse
+	if err := json.NewDecoder(rr.Body).Decode(&tokenResp); err != nil {
+		t.Fatalf("Failed to decode token response: %v", err)
+	}
+	if ttl := time.Until(tokenResp.ExpiresAt); ttl <= 0 || ttl > 300*time.Second {
+		t.Errorf("Expected the token TTL to default to upload.token_ttl_seconds, got %v", ttl)
+	}
+
+	tests := []struct {
+		name           string
+		request        PresignRequest
+		expectedStatus int
+	}{
+		{"In scope", PresignRequest{KeyBase: "user-42/me", Ext: "jpg", Mime: "image/jpeg", SizeBytes: 1024, Kind: "image", Profile: "avatar"}, http.StatusOK},
+		{"Other profile", PresignRequest{KeyBase: "user-42/me", Ext: "jpg", Mime: "image/jpeg", SizeBytes: 1024, Kind: "image", Profile: "banner"}, http.StatusForbidden},
+		{"Other key_base", PresignRequest{KeyBase: "user-43/me", Ext: "jpg", Mime: "image/jpeg", SizeBytes: 1024, Kind: "image", Profile: "avatar"}, http.StatusForbidden},
+		{"Larger than the token allows", PresignRequest{KeyBase: "user-42/me", Ext: "jpg", Mime: "image/jpeg", SizeBytes: 1025, Kind: "image", Profile: "avatar"}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqBody, _ := json.Marshal(tt.request)
+			req := httptest.NewRequest(http.MethodPost, "/v1/uploads/presign", bytes.NewReader(reqBody))
+			req.Header.Set("Authorization", "Bearer "+tokenResp.Token)
+			rr := httptest.NewRecorder()
+			presign.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if rr.Code == http.StatusForbidden {
+				var errResp ErrorResponse
+				_ = json.NewDecoder(rr.Body).Decode(&errResp)
+				if errResp.Code != ErrTokenScope {
+					t.Errorf("Expected code %s, got %s", ErrTokenScope, errResp.Code)
+				}
+			}
+		})
+	}
+
+	// Complete and abort are bound to the token's key_base prefix and to keys the profile renders for key_base
+	complete := auth.UploadTokenMiddleware(issuer, apiKey)(http.HandlerFunc(handler.HandleCompleteMultipart))
+	abort := auth.UploadTokenMiddleware(issuer, apiKey)(http.HandlerFunc(handler.HandleAbortMultipart))
+	bindingTests := []struct {
+		name           string
+		handler        http.Handler
+		method         string
+		url            string
+		expectedStatus int
+		expectedCode   string
+	}{
+		{"Complete in scope", complete, http.MethodPost, "/v1/uploads/originals/avatars/user-42/me.jpg/complete/upload-1?profile=avatar&key_base=user-42/me", http.StatusOK, ""},
+		{"Complete without profile", complete, http.MethodPost, "/v1/uploads/originals/avatars/user-42/me.jpg/complete/upload-1", http.StatusBadRequest, ErrBadRequest},
+		{"Complete outside the key_base prefix", complete, http.MethodPost, "/v1/uploads/originals/avatars/user-43/me.jpg/complete/upload-1?profile=avatar&key_base=user-43/me", http.StatusForbidden, ErrTokenScope},
+		{"Complete another asset's key", complete, http.MethodPost, "/v1/uploads/originals/avatars/user-43/me.jpg/complete/upload-1?profile=avatar&key_base=user-42/me", http.StatusForbidden, ErrObjectKeyMismatch},
+		{"Abort in scope", abort, http.MethodDelete, "/v1/uploads/originals/avatars/user-42/me.jpg/abort/upload-1?profile=avatar&key_base=user-42/me", http.StatusOK, ""},
+		{"Abort without key_base", abort, http.MethodDelete, "/v1/uploads/originals/avatars/user-42/me.jpg/abort/upload-1?profile=avatar", http.StatusBadRequest, ErrBadRequest},
+		{"Abort another asset's key", abort, http.MethodDelete, "/v1/uploads/originals/banners/user-42/me.jpg/abort/upload-1?profile=avatar&key_base=user-42/me", http.StatusForbidden, ErrObjectKeyMismatch},
+	}
+	for _, tt := range bindingTests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, strings.NewReader(`{"parts":[{"part_number":1,"etag":"\"abc\""}]}`))
+			req.Header.Set("Authorization", "Bearer "+tokenResp.Token)
+			rr := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if tt.expectedCode != "" {
+				var errResp ErrorResponse
+				_ = json.NewDecoder(rr.Body).Decode(&errResp)
+				if errResp.Code != tt.expectedCode {
+					t.Errorf("Expected code %s, got %s", tt.expectedCode, errResp.Code)
+				}
+			}
+		})
+	}
+
+	// Tokens can't outlive the configured maximum or exceed the profile's size limit
+	for _, body := range []string{
+		`{"profile":"avatar","ttl_seconds":301}`,
+		`{"profile":"avatar","max_size_bytes":6000000}`,
+		`{"profile":"nope"}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/uploads/tokens", strings.NewReader(body))
+		req.Header.Set("X-API-Key", "test-api-key")
+		rr := httptest.NewRecorder()
+		mint.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", body, rr.Code)
+		}
+	}
+
+	// The error names the bound that was crossed
+	for body, expected := range map[string]string{
+		`{"profile":"avatar","max_size_bytes":6000000}`: "max_size_bytes must be between 1 and 5242880",
+		`{"profile":"avatar","max_size_bytes":-1}`:      "max_size_bytes must be positive",
+		`{"profile":"logo","max_size_bytes":-1}`:        "max_size_bytes must be positive",
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/uploads/tokens", strings.NewReader(body))
+		req.Header.Set("X-API-Key", "test-api-key")
+		rr := httptest.NewRecorder()
+		mint.ServeHTTP(rr, req)
+		var errResp ErrorResponse
+		_ = json.NewDecoder(rr.Body).Decode(&errResp)
+		if rr.Code != http.StatusBadRequest || errResp.Message != expected {
+			t.Errorf("%s: expected 400 %q, got %d %q", body, expected, rr.Code, errResp.Message)
+		}
+	}
+}
+
+func TestUploadIntegration_ScopedAPIKeys(t *testing.T) {
+	storageConfig := &config.StorageConfig{
+		Profiles: map[string]config.Profile{
+			"avatar": {
+				Kind:                 "image",
+				AllowedMimes:         []string{"image/jpeg"},
+				SizeMaxBytes:         5 * 1024 * 1024,
+				MultipartThresholdMB: 15,
+				PartSizeMB:           8,
+				StoragePath:          "originals/avatars/{key_base}.{ext}",
+				ThumbFolder:          "thumbnails/avatars",
+			},
+			"banner": {
+				Kind:         "image",
+				AllowedMimes: []string{"image/jpeg"},
+				SizeMaxBytes: 5 * 1024 * 1024,
+				StoragePath:  "originals/banners/{key_base}.{ext}",
+			},
+		},
+	}
+	keyring, err := auth.NewKeyring([]auth.Key{
+		{ID: "avatar-uploader", SecretHash: auth.HashSecret("uploader"), Scopes: []string{auth.ScopeUploadPresign}, Profiles: []string{"avatar"}},
+		{ID: "avatar-janitor", SecretHash: auth.HashSecret("janitor"), Scopes: []string{auth.ScopeAssetsDelete}, Profiles: []string{"avatar"}},
+		auth.LegacyKey("admin"),
+	})
+	if err != nil {
+		t.Fatalf("NewKeyring failed: %v", err)
+	}
+	handler := &Handler{
+		uploadService: NewService(&MockS3Client{}, &config.Config{S3Bucket: "test-bucket"}),
+		storageConfig: config.NewStore(storageConfig, nil),
+		ctx:           context.Background(),
+	}
+	middleware := auth.APIKeyMiddleware(&auth.Config{Keyring: keyring})
+	presign := middleware(http.HandlerFunc(handler.HandlePresign))
+	deleteAsset := middleware(http.HandlerFunc(handler.HandleDeleteAsset))
+
+	tests := []struct {
+		name           string
+		handler        http.Handler
+		method         string
+		url            string
+		body           string
+		apiKey         string
+		expectedStatus int
+	}{
+		{"Uploader presigns in its profile", presign, http.MethodPost, "/v1/uploads/presign", `{"key_base":"me","ext":"jpg","mime":"image/jpeg","size_bytes":1024,"kind":"image","profile":"avatar"}`, "uploader", http.StatusOK},
+		{"Uploader presigns in another profile", presign, http.MethodPost, "/v1/uploads/presign", `{"key_base":"me","ext":"jpg","mime":"image/jpeg","size_bytes":1024,"kind":"image","profile":"banner"}`, "uploader", http.StatusForbidden},
+		{"Uploader can't delete", deleteAsset, http.MethodDelete, "/v1/assets/avatar/me", "", "uploader", http.StatusForbidden},
+		{"Janitor can't presign", presign, http.MethodPost, "/v1/uploads/presign", `{"key_base":"me","ext":"jpg","mime":"image/jpeg","size_bytes":1024,"kind":"image","profile":"avatar"}`, "janitor", http.StatusForbidden},
+		{"Janitor deletes in its profile", deleteAsset, http.MethodDelete, "/v1/assets/avatar/me", "", "janitor", http.StatusOK},
+		{"Janitor deletes in another profile", deleteAsset, http.MethodDelete, "/v1/assets/banner/me", "", "janitor", http.StatusForbidden},
+		{"Admin deletes anywhere", deleteAsset, http.MethodDelete, "/v1/assets/banner/me", "", "admin", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			req.Header.Set("X-API-Key", tt.apiKey)
+			rr := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if rr.Code == http.StatusForbidden {
+				var errResp ErrorResponse
+				_ = json.NewDecoder(rr.Body).Decode(&errResp)
+				if errResp.Code != ErrForbidden {
+					t.Errorf("Expected code %s, got %s", ErrForbidden, errResp.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestUploadIntegration_ReadTokens(t *testing.T) {
+	storageConfig := &config.StorageConfig{
+		Upload: config.UploadConfig{TokenTTLSeconds: 300},
+		Profiles: map[string]config.Profile{
+			"kyc": {Kind: "image", StoragePath: "originals/kyc/{key_base}", Visibility: config.VisibilityPrivate},
+		},
+	}
+	issuer, err := auth.NewTokenIssuer(auth.AlgHS256, "", "k1:test-secret")
+	if err != nil {
+		t.Fatalf("NewTokenIssuer failed: %v", err)
+	}
+	keyring, err := auth.NewKeyring([]auth.Key{
+		{ID: "reader", SecretHash: auth.HashSecret("read-secret"), Scopes: []string{auth.ScopeAssetsRead}, Profiles: []string{"kyc"}},
+		{ID: "uploader", SecretHash: auth.HashSecret("upload-secret"), Scopes: []string{auth.ScopeUploadPresign}},
+	})
+	if err != nil {
+		t.Fatalf("NewKeyring failed: %v", err)
+	}
+	handler := &Handler{
+		uploadService: NewService(&MockS3Client{}, &config.Config{S3Bucket: "test-bucket"}),
+		storageConfig: config.NewStore(storageConfig, nil),
+		ctx:           context.Background(),
+	}
+	handler.SetTokenIssuer(issuer)
+	mint := auth.APIKeyMiddleware(&auth.Config{Keyring: keyring})(http.HandlerFunc(handler.HandleCreateReadToken))
+
+	tests := []struct {
+		name           string
+		path           string
+		apiKey         string
+		body           string
+		expectedStatus int
+	}{
+		{"Reader key", "/v1/assets/kyc/doc-1/token", "read-secret", "", http.StatusOK},
+		{"Shorter TTL", "/v1/assets/kyc/doc-1/token", "read-secret", `{"ttl_seconds":60}`, http.StatusOK},
+		{"TTL too long", "/v1/assets/kyc/doc-1/token", "read-secret", `{"ttl_seconds":301}`, http.StatusBadRequest},
+		{"Key without assets:read", "/v1/assets/kyc/doc-1/token", "upload-secret", "", http.StatusForbidden},
+		{"Profile not allowed for the key", "/v1/assets/other/doc-1/token", "read-secret", "", http.StatusForbidden},
+		{"Missing key_base", "/v1/assets/kyc/token", "read-secret", "", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("X-API-Key", tt.apiKey)
+			rr := httptest.NewRecorder()
+			mint.ServeHTTP(rr, req)
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var tokenResp TokenResponse
+			if err := json.NewDecoder(rr.Body).Decode(&tokenResp); err != nil {
+				t.Fatalf("Failed to decode token response: %v", err)
+			}
+			claims, err := issuer.VerifyRead(tokenResp.Token)
+			if err != nil {
+				t.Fatalf("VerifyRead failed: %v", err)
+			}
+			if claims.Profile != "kyc" || claims.KeyBase != "doc-1" {
+				t.Errorf("Unexpected claims: %+v", claims)
+			}
+		})
+	}
+}
@@ -2,18 +2,35 @@ package upload
 
 import (
 	"context"
+	"io"
 	"time"
-	
-	"mediaflow/internal/s3"
+
+	"mediaflow/internal/storage"
 )
 
-// S3Client interface for dependency injection and testing
-type S3Client interface {
+// Storage is the subset of storage.Backend used by uploads (allows dependency injection and testing)
+type Storage interface {
 	CreateMultipartUpload(ctx context.Context, key string, headers map[string]string) (string, error)
 	PresignPutObject(ctx context.Context, key string, expires time.Duration, headers map[string]string) (string, error)
 	PresignUploadPart(ctx context.Context, key, uploadID string, partNumber int32, expires time.Duration) (string, error)
-	CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []s3.PartInfo) error
+	CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []storage.PartInfo) error
 	AbortMultipartUpload(ctx context.Context, key, uploadID string) error
+	ListParts(ctx context.Context, key, uploadID string) ([]storage.PartInfo, error)
+	HeadObject(ctx context.Context, key string) (*storage.ObjectInfo, error)
+	GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	GetObjectStream(ctx context.Context, key string) (io.ReadCloser, *storage.ObjectInfo, error)
+	PutObject(ctx context.Context, key string, body io.Reader, contentType string) error
 	DeleteObject(ctx context.Context, key string) error
 	ListByPrefix(ctx context.Context, prefix string) ([]string, error)
-}
\ No newline at end of file
+}
+
+// Cache holds derived objects (e.g. thumbnails) that must be invalidated when an asset is deleted
+type Cache interface {
+	Delete(key string)
+	DeleteFunc(match func(key string) bool)
+}
+
+// Processor queues post-upload processing (thumbnail generation) for an uploaded original
+type Processor interface {
+	Enqueue(profile, objectKey, keyBase, etag string) (string, error)
+}
@@ -2,28 +2,60 @@ package upload
 
 import (
 	"context"
-	"crypto/sha1"
+	"errors"
 	"fmt"
+	"io"
 	"math"
+	"net/http"
+	"net/url"
+	"path"
 	"strings"
 	"time"
 
+	utils "mediaflow/internal"
 	"mediaflow/internal/config"
-	"mediaflow/internal/s3"
+	"mediaflow/internal/objectkey"
+	"mediaflow/internal/storage"
+)
+
+const (
+	// maxUploadParts is the S3 limit on parts per multipart upload
+	maxUploadParts = 10000
+
+	defaultInitialBatchSize = 10
+	defaultMaxBatchSize     = 20
+
+	// sniffLen is how much of an upload http.DetectContentType looks at
+	sniffLen = 512
+
+	// batchEndpoint is the path clients call for additional part URLs
+	batchEndpoint = "/v1/uploads/presign/parts"
 )
 
 type Service struct {
-	s3Client S3Client
-	config   *config.Config
+	storage   Storage
+	config    *config.Config
+	cache     Cache
+	processor Processor
 }
 
-func NewService(s3Client S3Client, config *config.Config) *Service {
+func NewService(storage Storage, config *config.Config) *Service {
 	return &Service{
-		s3Client: s3Client,
-		config:   config,
+		storage: storage,
+		config:  config,
 	}
 }
 
+// SetCache registers a cache to invalidate when assets are deleted
+func (s *Service) SetCache(cache Cache) {
+	s.cache = cache
+}
+
+// SetProcessor registers the queue that post-upload processing jobs are sent to
+func (s *Service) SetProcessor(processor Processor) {
+	s.processor = processor
+}
+
 // PresignUpload generates presigned URLs for upload based on the request
 func (s *Service) PresignUpload(ctx context.Context, req *PresignRequest, profile *config.Profile, baseURL string) (*PresignResponse, error) {
 	// Validate MIME type
@@ -36,18 +68,22 @@ func (s *Service) PresignUpload(ctx context.Context, req *PresignRequest, profil
 		return nil, fmt.Errorf("file size exceeds maximum: %d > %d", req.SizeBytes, profile.SizeMaxBytes)
 	}
 
-	// Generate shard only if auto-sharding is enabled
-	shard := ""
-	if profile.EnableSharding {
-		shard = req.Shard
-		if shard == "" {
-			shard = GenerateShard(req.KeyBase)
+	// Shards are derived from key_base so reads and deletes resolve the same key.
+	// A shard in the request must match; if EnableSharding is false, it is ignored
+	shard := profile.Shard(req.KeyBase)
+	if shard != "" && req.Shard != "" && req.Shard != shard {
+		return nil, &RequestError{
+			Code:    ErrBadRequest,
+			Message: fmt.Sprintf("shard '%s' doesn't match key_base '%s', expected '%s'", req.Shard, req.KeyBase, shard),
+			Hint:    "Omit shard to have it derived from key_base",
 		}
 	}
-	// Note: If EnableSharding is false, any shard in request is ignored
 
 	// Build object key from template
-	objectKey := s.buildObjectKey(profile.StoragePath, req.KeyBase, req.Ext, shard)
+	vars := objectkey.Vars{KeyBase: req.KeyBase, Ext: req.Ext, Shard: shard, Profile: req.Profile, Tenant: req.Tenant}
+	// Keys with dates or UUIDs can't be rendered again for lookups; they are recorded once the
+	// upload is finalized or completed, against the key checked by CheckObjectKey
+	objectKey := s.buildObjectKey(profile.StoragePath, vars)
 
 	// Determine upload strategy
 	strategy := s.determineStrategy(req.Multipart, req.SizeBytes, profile.MultipartThresholdMB)
@@ -57,7 +93,13 @@ func (s *Service) PresignUpload(ctx context.Context, req *PresignRequest, profil
 
 	// Create presigned URLs based on strategy
 	expiresAt := time.Now().Add(time.Duration(profile.TokenTTLSeconds) * time.Second)
-	uploadDetails, err := s.createUploadDetails(ctx, strategy, objectKey, headers, expiresAt, profile.PartSizeMB, req.SizeBytes, baseURL)
+	// Lets the complete, finalize and abort calls identify the upload without the client repeating it
+	query := url.Values{"profile": {req.Profile}, "key_base": {req.KeyBase}}
+	if req.Tenant != "" {
+		query.Set("tenant", req.Tenant)
+	}
+	uploadQuery := query.Encode()
+	uploadDetails, err := s.createUploadDetails(ctx, strategy, objectKey, headers, expiresAt, profile, req.SizeBytes, baseURL, uploadQuery)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create upload details: %w", err)
 	}
@@ -79,25 +121,8 @@ func (s *Service) isMimeAllowed(mime string, allowedMimes []string) bool {
 	return false
 }
 
-func (s *Service) buildObjectKey(template, keyBase, ext, shard string) string {
-	objectKey := template
-	
-	// Replace placeholders in template
-	objectKey = strings.ReplaceAll(objectKey, "{key_base}", keyBase)
-	objectKey = strings.ReplaceAll(objectKey, "{ext}", ext)
-	
-	// Handle optional shard
-	if shard != "" {
-		objectKey = strings.ReplaceAll(objectKey, "{shard?}", shard)
-		objectKey = strings.ReplaceAll(objectKey, "{shard}", shard)
-	} else {
-		// Remove shard placeholders if no shard
-		objectKey = strings.ReplaceAll(objectKey, "/{shard?}", "")
-		objectKey = strings.ReplaceAll(objectKey, "{shard?}/", "")
-		objectKey = strings.ReplaceAll(objectKey, "{shard?}", "")
-	}
-	
-	return objectKey
+func (s *Service) buildObjectKey(template string, vars objectkey.Vars) string {
+	return objectkey.Render(template, vars)
 }
 
 func (s *Service) determineStrategy(multipart string, sizeBytes int64, thresholdMB int64) string {
@@ -129,8 +154,13 @@ func (s *Service) buildRequiredHeaders(mime string) map[string]string {
 	return headers
 }
 
-func (s *Service) createUploadDetails(ctx context.Context, strategy, objectKey string, headers map[string]string, expiresAt time.Time, partSizeMB int64, totalSizeBytes int64, baseURL string) (*UploadDetails, error) {
+func (s *Service) createUploadDetails(ctx context.Context, strategy, objectKey string, headers map[string]string, expiresAt time.Time, profile *config.Profile, totalSizeBytes int64, baseURL, uploadQuery string) (*UploadDetails, error) {
 	expires := time.Until(expiresAt)
+
+	// Generate server-side URLs for complete, abort and finalize operations
+	if baseURL == "" {
+		baseURL = "http://localhost:8080" // Default fallback
+	}
 	
 	if strategy == "single" {
 		// Add If-None-Match header for overwrite prevention
@@ -140,7 +170,7 @@ func (s *Service) createUploadDetails(ctx context.Context, strategy, objectKey s
 		}
 		singleHeaders["If-None-Match"] = "*"
 		
-		url, err := s.s3Client.PresignPutObject(ctx, objectKey, expires, singleHeaders)
+		url, err := s.storage.PresignPutObject(ctx, objectKey, expires, singleHeaders)
 		if err != nil {
 			return nil, err
 		}
@@ -151,56 +181,54 @@ func (s *Service) createUploadDetails(ctx context.Context, strategy, objectKey s
 				URL:       url,
 				Headers:   singleHeaders,
 				ExpiresAt: expiresAt,
+				Finalize: &UploadAction{
+					Method:    "POST",
+					URL:       fmt.Sprintf("%s/v1/uploads/%s/finalize?%s", baseURL, objectKey, uploadQuery),
+					Headers:   map[string]string{},
+					ExpiresAt: expiresAt,
+				},
 			},
 		}, nil
 	}
 	
-	// For multipart uploads, create the multipart upload and generate part URLs
-	uploadID, err := s.s3Client.CreateMultipartUpload(ctx, objectKey, headers)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create multipart upload: %w", err)
-	}
-	
 	// Calculate number of parts needed
-	partSizeBytes := partSizeMB * 1024 * 1024
+	partSizeBytes := profile.PartSizeMB * 1024 * 1024
 	numParts := int(math.Ceil(float64(totalSizeBytes) / float64(partSizeBytes)))
-	
-	// Generate presigned URLs for each part (limit to reasonable number)
-	maxParts := 100 // Reasonable limit for batch presigning
-	if numParts > maxParts {
-		numParts = maxParts
-	}
-	
-	parts := make([]PartUpload, numParts)
-	for i := 0; i < numParts; i++ {
-		partNumber := i + 1
-		partURL, err := s.s3Client.PresignUploadPart(ctx, objectKey, uploadID, int32(partNumber), expires)
-		if err != nil {
-			return nil, fmt.Errorf("failed to presign part %d: %w", partNumber, err)
-		}
-		
-		parts[i] = PartUpload{
-			PartNumber: partNumber,
-			Method:     "PUT",
-			URL:        partURL,
-			Headers:    headers,
-			ExpiresAt:  expiresAt,
+	if numParts > maxUploadParts {
+		return nil, &RequestError{
+			Code:    ErrSizeTooLarge,
+			Message: fmt.Sprintf("Upload requires %d parts, maximum %d allowed", numParts, maxUploadParts),
+			Hint:    "Increase part_size_mb in configuration",
 		}
 	}
-	
-	// Generate server-side URLs for complete and abort operations
-	if baseURL == "" {
-		baseURL = "http://localhost:8080" // Default fallback
+
+	// For multipart uploads, create the multipart upload and generate the first batch of part URLs
+	uploadID, err := s.storage.CreateMultipartUpload(ctx, objectKey, headers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create multipart upload: %w", err)
 	}
-	
-	completeURL := fmt.Sprintf("%s/v1/uploads/%s/complete/%s", baseURL, objectKey, uploadID)
-	abortURL := fmt.Sprintf("%s/v1/uploads/%s/abort/%s", baseURL, objectKey, uploadID)
+
+	batchSize := initialBatchSize(profile)
+	if batchSize > numParts {
+		batchSize = numParts
+	}
+
+	partExpiresAt := partURLExpiry(profile, expiresAt)
+	parts, err := s.presignParts(ctx, objectKey, uploadID, 1, batchSize, headers, partExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	completeURL := fmt.Sprintf("%s/v1/uploads/%s/complete/%s?%s", baseURL, objectKey, uploadID, uploadQuery)
+	abortURL := fmt.Sprintf("%s/v1/uploads/%s/abort/%s?%s", baseURL, objectKey, uploadID, uploadQuery)
 	
 	return &UploadDetails{
 		Multipart: &MultipartUpload{
-			UploadID: uploadID,
-			PartSize: partSizeBytes,
-			Parts:    parts,
+			UploadID:      uploadID,
+			PartSize:      partSizeBytes,
+			TotalPartsEst: numParts,
+			Parts:         parts,
+			BatchInfo:     newBatchInfo(1, len(parts), numParts, batchEndpoint),
 			Complete: &UploadAction{
 				Method:    "POST",
 				URL:       completeURL,
@@ -217,53 +245,341 @@ func (s *Service) createUploadDetails(ctx context.Context, strategy, objectKey s
 	}, nil
 }
 
+// PresignParts generates presigned URLs for an additional batch of parts of an existing multipart upload
+func (s *Service) PresignParts(ctx context.Context, req *PresignPartsRequest, profile *config.Profile) (*PresignPartsResponse, error) {
+	maxBatch := maxBatchSize(profile)
+	if req.Count > maxBatch {
+		return nil, &RequestError{
+			Code:    ErrBatchSizeExceeded,
+			Message: fmt.Sprintf("Requested %d parts, maximum %d allowed", req.Count, maxBatch),
+			Hint:    "Reduce count parameter",
+		}
+	}
+
+	// total_parts can only lower the number of parts the profile's size limit allows
+	totalParts := maxParts(profile)
+	if req.TotalParts > 0 && req.TotalParts < totalParts {
+		totalParts = req.TotalParts
+	}
+	if req.StartPart < 1 || req.StartPart > totalParts {
+		return nil, &RequestError{
+			Code:    ErrInvalidPartRange,
+			Message: fmt.Sprintf("Part %d requested but upload has %d parts", req.StartPart, totalParts),
+			Hint:    "Request sequential parts only",
+		}
+	}
+
+	// Verify the upload exists for this object key (stateless: S3 is the source of truth)
+	uploaded, err := s.storage.ListParts(ctx, req.ObjectKey, req.UploadID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNoSuchUpload) {
+			return nil, &RequestError{
+				Code:    ErrInvalidUploadID,
+				Message: "Upload ID not found or expired",
+				Hint:    "Start a new upload",
+			}
+		}
+		return nil, fmt.Errorf("failed to verify multipart upload: %w", err)
+	}
+	// Batches may run at most one batch ahead of the parts uploaded so far
+	if window := max(initialBatchSize(profile), maxBatch); req.StartPart > len(uploaded)+window+1 {
+		return nil, &RequestError{
+			Code:    ErrInvalidPartRange,
+			Message: fmt.Sprintf("Part %d requested but only %d parts are uploaded", req.StartPart, len(uploaded)),
+			Hint:    "Request sequential parts only",
+		}
+	}
+
+	count := req.Count
+	if remaining := totalParts - req.StartPart + 1; count > remaining {
+		count = remaining
+	}
+
+	ttl := time.Duration(profile.PartURLTTLSeconds) * time.Second
+	if ttl <= 0 {
+		ttl = time.Duration(profile.TokenTTLSeconds) * time.Second
+	}
+	if req.ExpiresSeconds > 0 {
+		if requested := time.Duration(req.ExpiresSeconds) * time.Second; requested < ttl {
+			ttl = requested
+		}
+	}
+
+	headers := map[string]string{}
+	parts, err := s.presignParts(ctx, req.ObjectKey, req.UploadID, req.StartPart, count, headers, time.Now().Add(ttl))
+	if err != nil {
+		return nil, err
+	}
+
+	return &PresignPartsResponse{
+		Parts:     parts,
+		BatchInfo: newBatchInfo(req.StartPart, len(parts), totalParts, ""),
+	}, nil
+}
+
+// presignParts presigns count sequential parts starting at startPart
+func (s *Service) presignParts(ctx context.Context, objectKey, uploadID string, startPart, count int, headers map[string]string, expiresAt time.Time) ([]PartUpload, error) {
+	expires := time.Until(expiresAt)
+
+	parts := make([]PartUpload, count)
+	for i := 0; i < count; i++ {
+		partNumber := startPart + i
+		partURL, err := s.storage.PresignUploadPart(ctx, objectKey, uploadID, int32(partNumber), expires)
+		if err != nil {
+			return nil, fmt.Errorf("failed to presign part %d: %w", partNumber, err)
+		}
+
+		parts[i] = PartUpload{
+			PartNumber: partNumber,
+			Method:     "PUT",
+			URL:        partURL,
+			Headers:    headers,
+			ExpiresAt:  expiresAt,
+		}
+	}
+	return parts, nil
+}
+
+func newBatchInfo(startPart, partsInBatch, totalParts int, endpoint string) *BatchInfo {
+	next := startPart + partsInBatch
+	info := &BatchInfo{
+		PartsInBatch: partsInBatch,
+		HasMoreParts: next <= totalParts,
+	}
+	if info.HasMoreParts {
+		info.NextPartNumber = next
+		info.BatchEndpoint = endpoint
+	}
+	return info
+}
+
+// maxParts returns the most parts an upload within the profile's size_max_bytes can need
+func maxParts(profile *config.Profile) int {
+	partSizeBytes := profile.PartSizeMB * 1024 * 1024
+	if profile.SizeMaxBytes <= 0 || partSizeBytes <= 0 {
+		return maxUploadParts
+	}
+	return int(min((profile.SizeMaxBytes+partSizeBytes-1)/partSizeBytes, maxUploadParts))
+}
+
+func initialBatchSize(profile *config.Profile) int {
+	if profile.InitialBatchSize > 0 {
+		return profile.InitialBatchSize
+	}
+	return defaultInitialBatchSize
+}
+
+func maxBatchSize(profile *config.Profile) int {
+	if profile.MaxBatchSize > 0 {
+		return profile.MaxBatchSize
+	}
+	return defaultMaxBatchSize
+}
+
+// partURLExpiry returns the expiry for part URLs, capped by the overall upload expiry
+func partURLExpiry(profile *config.Profile, uploadExpiresAt time.Time) time.Time {
+	if profile.PartURLTTLSeconds <= 0 {
+		return uploadExpiresAt
+	}
+	partExpiresAt := time.Now().Add(time.Duration(profile.PartURLTTLSeconds) * time.Second)
+	if partExpiresAt.After(uploadExpiresAt) {
+		return uploadExpiresAt
+	}
+	return partExpiresAt
+}
+
 // CompleteMultipartUpload completes a multipart upload
 func (s *Service) CompleteMultipartUpload(ctx context.Context, objectKey, uploadID string, req *CompleteMultipartRequest) error {
-	// Convert request parts to s3.PartInfo
-	parts := make([]s3.PartInfo, len(req.Parts))
+	// Convert request parts to storage.PartInfo
+	parts := make([]storage.PartInfo, len(req.Parts))
 	for i, part := range req.Parts {
-		parts[i] = s3.PartInfo{
+		parts[i] = storage.PartInfo{
 			PartNumber: part.PartNumber,
 			ETag:       part.ETag,
 		}
 	}
 	
-	return s.s3Client.CompleteMultipartUpload(ctx, objectKey, uploadID, parts)
+	return s.storage.CompleteMultipartUpload(ctx, objectKey, uploadID, parts)
+}
+
+// CheckObjectKey verifies that objectKey is a key the profile's storage_path renders for keyBase
+// and tenant. Complete, finalize and abort take the key from their URL, so this keeps credentials
+// for one key_base from completing, deleting or recording another asset's key.
+func (s *Service) CheckObjectKey(profile *config.Profile, tenant, keyBase, objectKey string) error {
+	vars := objectkey.Vars{KeyBase: keyBase, Shard: profile.Shard(keyBase), Profile: profile.Name, Tenant: tenant}
+	if !objectkey.Match(profile.StoragePath, vars, objectKey) {
+		target := fmt.Sprintf("key_base '%s' in profile '%s'", keyBase, profile.Name)
+		if tenant != "" {
+			target += fmt.Sprintf(" for tenant '%s'", tenant)
+		}
+		return &RequestError{
+			Code:    ErrObjectKeyMismatch,
+			Message: fmt.Sprintf("Object key '%s' is not an upload key of %s", objectKey, target),
+			Hint:    "Use the URLs returned by presign",
+		}
+	}
+	return nil
+}
+
+// FinalizeUpload verifies a single PUT upload against the profile.
+// Presigned uploads bypass the server, so the object's real size and content are only checked here:
+// the size must be within size_max_bytes and the sniffed content type must be in allowed_mimes.
+// Objects that fail verification are deleted, so objectKey must have been checked with CheckObjectKey.
+// Returns the verified object's info.
+func (s *Service) FinalizeUpload(ctx context.Context, objectKey string, profile *config.Profile) (*storage.ObjectInfo, error) {
+	info, err := s.storage.HeadObject(ctx, objectKey)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, &RequestError{
+				Code:    ErrObjectNotFound,
+				Message: fmt.Sprintf("Object not found: %s", objectKey),
+				Hint:    "Upload the file with the presigned URL before finalizing",
+			}
+		}
+		return nil, fmt.Errorf("failed to stat uploaded object: %w", err)
+	}
+
+	if profile.SizeMaxBytes > 0 && info.Size > profile.SizeMaxBytes {
+		s.rejectUpload(ctx, objectKey)
+		return nil, &RequestError{
+			Code:    ErrSizeTooLarge,
+			Message: fmt.Sprintf("Uploaded file size exceeds maximum: %d > %d", info.Size, profile.SizeMaxBytes),
+			Hint:    "The object has been deleted",
+		}
+	}
+
+	mime, err := s.sniffContentType(ctx, objectKey, info.Size)
+	if err != nil {
+		return nil, err
+	}
+	if !s.isMimeAllowed(mime, profile.AllowedMimes) {
+		s.rejectUpload(ctx, objectKey)
+		return nil, &RequestError{
+			Code:    ErrMimeNotAllowed,
+			Message: fmt.Sprintf("Uploaded content type not allowed: %s", mime),
+			Hint:    "The object has been deleted; check allowed_mimes in upload configuration",
+		}
+	}
+
+	return info, nil
+}
+
+// sniffContentType detects an object's content type from its first bytes
+func (s *Service) sniffContentType(ctx context.Context, objectKey string, size int64) (string, error) {
+	mime := http.DetectContentType(nil)
+	if size > 0 {
+		body, err := s.storage.GetObjectRange(ctx, objectKey, 0, min(size, sniffLen))
+		if err != nil {
+			return "", fmt.Errorf("failed to read uploaded object: %w", err)
+		}
+		defer body.Close()
+		head, err := io.ReadAll(body)
+		if err != nil {
+			return "", fmt.Errorf("failed to read uploaded object: %w", err)
+		}
+		mime = http.DetectContentType(head)
+	}
+	// Drop parameters such as "; charset=utf-8"
+	mime, _, _ = strings.Cut(mime, ";")
+	return mime, nil
+}
+
+// rejectUpload deletes an upload that failed verification
+func (s *Service) rejectUpload(ctx context.Context, objectKey string) {
+	if err := s.storage.DeleteObject(ctx, objectKey); err != nil {
+		fmt.Printf("Failed to delete rejected upload %s: %v\n", objectKey, err)
+	}
+}
+
+// QueueProcessing queues thumbnail generation for an uploaded original. etag identifies the upload,
+// so queuing the same upload again returns its existing job; pass "" to always queue one.
+// Returns an empty job ID when there is nothing to process (no processor, or a profile without thumbnails).
+func (s *Service) QueueProcessing(profileName string, profile *config.Profile, objectKey, keyBase, etag string) (string, error) {
+	if s.processor == nil || !hasProcessing(profile) {
+		return "", nil
+	}
+	if keyBase == "" {
+		keyBase = utils.BaseName(path.Base(objectKey))
+	}
+	return s.processor.Enqueue(profileName, objectKey, keyBase, etag)
+}
+
+// hasProcessing reports whether uploads to the profile get post-upload processing
+func hasProcessing(profile *config.Profile) bool {
+	if profile.Kind != "image" {
+		return false
+	}
+	return profile.SanitizeOriginal || (profile.ThumbFolder != "" && (len(profile.Sizes) > 0 || len(profile.Variants) > 0))
 }
 
 // AbortMultipartUpload aborts a multipart upload
 func (s *Service) AbortMultipartUpload(ctx context.Context, objectKey, uploadID string) error {
-	return s.s3Client.AbortMultipartUpload(ctx, objectKey, uploadID)
+	return s.storage.AbortMultipartUpload(ctx, objectKey, uploadID)
 }
 
-// DeleteAsset deletes an asset's original file and all generated thumbnails from R2.
-// It resolves the storage paths from the profile config, handling sharding if enabled.
-func (s *Service) DeleteAsset(ctx context.Context, profile *config.Profile, keyBase string) (int, error) {
-	// Build the original object key (same logic as upload)
-	shard := ""
-	if profile.EnableSharding {
-		shard = GenerateShard(keyBase)
+// RecordObjectKey records the key an upload was stored under, for profiles whose storage_path
+// can't be rendered again. Called once an upload is complete, so the last finished upload wins.
+// Keys that don't match the storage_path for keyBase and tenant are never recorded.
+func (s *Service) RecordObjectKey(ctx context.Context, profile *config.Profile, tenant, keyBase, objectKey string) error {
+	if keyBase == "" {
+		return nil
+	}
+	if err := s.CheckObjectKey(profile, tenant, keyBase, objectKey); err != nil {
+		return err
 	}
-	originalKey := s.buildObjectKey(profile.StoragePath, keyBase, "", shard)
+	return objectkey.Record(ctx, s.storage, profile.StoragePath, objectkey.Vars{KeyBase: keyBase, Profile: profile.Name, Tenant: tenant}, objectKey)
+}
+
+// DeleteAsset deletes an asset's original file and all generated thumbnails from storage.
+// It resolves the original's key from the profile config, handling sharding if enabled,
+// or reads the key recorded at upload time for the tenant.
+func (s *Service) DeleteAsset(ctx context.Context, profile *config.Profile, tenant, keyBase string) (int, error) {
+	vars := objectkey.Vars{KeyBase: keyBase, Shard: profile.Shard(keyBase), Profile: profile.Name, Tenant: tenant}
 
 	deleted := 0
 
-	// Delete the original file
-	if err := s.s3Client.DeleteObject(ctx, originalKey); err != nil {
-		return 0, fmt.Errorf("failed to delete original %s: %w", originalKey, err)
+	// Delete the original file; nothing is recorded for assets that were never uploaded
+	originalKey, err := objectkey.Resolve(ctx, s.storage, profile.StoragePath, vars)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return 0, fmt.Errorf("failed to resolve original of %s: %w", keyBase, err)
+	}
+	if err == nil {
+		if err := s.storage.DeleteObject(ctx, originalKey); err != nil {
+			return 0, fmt.Errorf("failed to delete original %s: %w", originalKey, err)
+		}
+		deleted++
+		if err := s.storage.DeleteObject(ctx, objectkey.MetadataKey(originalKey)); err != nil {
+			return deleted, fmt.Errorf("failed to delete metadata of %s: %w", originalKey, err)
+		}
+	}
+	if !objectkey.IsStable(profile.StoragePath) {
+		if err := s.storage.DeleteObject(ctx, objectkey.IndexKey(profile.Name, tenant, keyBase)); err != nil {
+			return deleted, fmt.Errorf("failed to delete recorded key of %s: %w", keyBase, err)
+		}
+	}
+
+	if s.cache != nil && originalKey != "" {
+		s.cache.Delete(originalKey)
 	}
-	deleted++
 
 	// Delete thumbnails if the profile has a thumb_folder
 	if profile.ThumbFolder != "" {
-		thumbPrefix := fmt.Sprintf("%s/%s", profile.ThumbFolder, keyBase)
-		thumbKeys, err := s.s3Client.ListByPrefix(ctx, thumbPrefix)
+		// Thumbnail keys are {thumb_folder}/{key_base}_{size}..., so the prefix also lists the
+		// thumbnails of key_bases such as abc_1 for abc; only keys matching the full name are deleted
+		isThumbnail := profile.ThumbnailMatcher(keyBase)
+		if s.cache != nil {
+			s.cache.DeleteFunc(isThumbnail)
+		}
+		thumbKeys, err := s.storage.ListByPrefix(ctx, fmt.Sprintf("%s/%s_", profile.ThumbFolder, keyBase))
 		if err != nil {
 			// Non-fatal: original is deleted, thumbs may not exist
 			return deleted, nil
 		}
 		for _, key := range thumbKeys {
-			if err := s.s3Client.DeleteObject(ctx, key); err == nil {
+			if !isThumbnail(key) {
+				continue
+			}
+			if err := s.storage.DeleteObject(ctx, key); err == nil {
 				deleted++
 			}
 		}
@@ -272,8 +588,8 @@ func (s *Service) DeleteAsset(ctx context.Context, profile *config.Profile, keyB
 	return deleted, nil
 }
 
-// GenerateShard creates a shard from key_base using SHA1 hash
+// GenerateShard creates a shard from key_base with the default layout (first byte of its SHA1).
+// Profiles with shard_hash, shard_depth or shard_width derive theirs with Profile.Shard.
 func GenerateShard(keyBase string) string {
-	hash := sha1.Sum([]byte(keyBase))
-	return fmt.Sprintf("%02x", hash[:1]) // First 2 hex characters
-}
\ No newline at end of file
+	return objectkey.Shard(keyBase)
+}
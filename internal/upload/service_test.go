@@ -2,21 +2,32 @@ package upload
 
 import (
 	"context"
+	"errors"
+	"io"
+	"sort"
 	"strings"
 	"testing"
 	"time"
 
+	"mediaflow/internal/cache"
 	"mediaflow/internal/config"
+	"mediaflow/internal/objectkey"
 	"mediaflow/internal/s3"
+	"mediaflow/internal/storage"
 )
 
-// MockS3Client implements S3Client interface for testing
+// MockS3Client implements Storage interface for testing
 type MockS3Client struct {
 	createMultipartUploadFunc  func(ctx context.Context, key string, headers map[string]string) (string, error)
 	presignPutObjectFunc       func(ctx context.Context, key string, expires time.Duration, headers map[string]string) (string, error)
 	presignUploadPartFunc      func(ctx context.Context, key, uploadID string, partNumber int32, expires time.Duration) (string, error)
 	completeMultipartUploadFunc func(ctx context.Context, key, uploadID string, parts []s3.PartInfo) error
 	abortMultipartUploadFunc   func(ctx context.Context, key, uploadID string) error
+	listPartsFunc              func(ctx context.Context, key, uploadID string) ([]s3.PartInfo, error)
+	headObjectFunc             func(ctx context.Context, key string) (*storage.ObjectInfo, error)
+	getObjectRangeFunc         func(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	deleteObjectFunc           func(ctx context.Context, key string) error
+	objects                    map[string]string // PutObject/GetObjectStream, for recorded object keys
 }
 
 func (m *MockS3Client) CreateMultipartUpload(ctx context.Context, key string, headers map[string]string) (string, error) {
@@ -54,12 +65,63 @@ func (m *MockS3Client) AbortMultipartUpload(ctx context.Context, key, uploadID s
 	return nil
 }
 
+func (m *MockS3Client) ListParts(ctx context.Context, key, uploadID string) ([]s3.PartInfo, error) {
+	if m.listPartsFunc != nil {
+		return m.listPartsFunc(ctx, key, uploadID)
+	}
+	return nil, nil
+}
+
+func (m *MockS3Client) HeadObject(ctx context.Context, key string) (*storage.ObjectInfo, error) {
+	if m.headObjectFunc != nil {
+		return m.headObjectFunc(ctx, key)
+	}
+	return &storage.ObjectInfo{}, nil
+}
+
+func (m *MockS3Client) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if m.getObjectRangeFunc != nil {
+		return m.getObjectRangeFunc(ctx, key, offset, length)
+	}
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
+func (m *MockS3Client) GetObjectStream(ctx context.Context, key string) (io.ReadCloser, *storage.ObjectInfo, error) {
+	body, ok := m.objects[key]
+	if !ok {
+		return nil, nil, storage.ErrNotFound
+	}
+	return io.NopCloser(strings.NewReader(body)), &storage.ObjectInfo{Size: int64(len(body))}, nil
+}
+
+func (m *MockS3Client) PutObject(ctx context.Context, key string, body io.Reader, contentType string) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if m.objects == nil {
+		m.objects = make(map[string]string)
+	}
+	m.objects[key] = string(data)
+	return nil
+}
+
 func (m *MockS3Client) DeleteObject(ctx context.Context, key string) error {
+	if m.deleteObjectFunc != nil {
+		return m.deleteObjectFunc(ctx, key)
+	}
 	return nil
 }
 
 func (m *MockS3Client) ListByPrefix(ctx context.Context, prefix string) ([]string, error) {
-	return nil, nil
+	var keys []string
+	for key := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
 }
 
 func TestGenerateShard(t *testing.T) {
@@ -228,46 +290,51 @@ func TestService_buildRequiredHeaders(t *testing.T) {
 
 func TestService_buildObjectKey(t *testing.T) {
 	service := &Service{}
+	uploadedAt := time.Date(2025, time.March, 7, 12, 0, 0, 0, time.UTC)
 
 	tests := []struct {
 		name     string
 		template string
-		keyBase  string
-		ext      string
-		shard    string
+		vars     objectkey.Vars
 		expected string
 	}{
 		{
 			name:     "With shard",
 			template: "originals/{shard?}/{key_base}.{ext}",
-			keyBase:  "test-key",
-			ext:      "jpg",
-			shard:    "ab",
+			vars:     objectkey.Vars{KeyBase: "test-key", Ext: "jpg", Shard: "ab"},
 			expected: "originals/ab/test-key.jpg",
 		},
 		{
 			name:     "Without shard",
 			template: "originals/{shard?}/{key_base}.{ext}",
-			keyBase:  "test-key",
-			ext:      "jpg",
-			shard:    "",
+			vars:     objectkey.Vars{KeyBase: "test-key", Ext: "jpg"},
 			expected: "originals/test-key.jpg",
 		},
 		{
 			name:     "Simple template",
 			template: "{key_base}.{ext}",
-			keyBase:  "test-key",
-			ext:      "mp4",
-			shard:    "",
+			vars:     objectkey.Vars{KeyBase: "test-key", Ext: "mp4"},
 			expected: "test-key.mp4",
 		},
+		{
+			name:     "Date and tenant",
+			template: "uploads/{tenant|shared}/{year}/{month}/{key_base}.{ext}",
+			vars:     objectkey.Vars{KeyBase: "test-key", Ext: "jpg", Time: uploadedAt},
+			expected: "uploads/shared/2025/03/test-key.jpg",
+		},
+		{
+			name:     "Profile and UUID",
+			template: "{profile}/{uuid}.{ext}",
+			vars:     objectkey.Vars{Ext: "jpg", Profile: "avatar", UUID: "0f8e9c1a-0000-4000-8000-000000000000"},
+			expected: "avatar/0f8e9c1a-0000-4000-8000-000000000000.jpg",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := service.buildObjectKey(tt.template, tt.keyBase, tt.ext, tt.shard)
+			result := service.buildObjectKey(tt.template, tt.vars)
 			if result != tt.expected {
-				t.Errorf("buildObjectKey(%s, %s, %s, %s) = %s, expected %s", tt.template, tt.keyBase, tt.ext, tt.shard, result, tt.expected)
+				t.Errorf("buildObjectKey(%s, %+v) = %s, expected %s", tt.template, tt.vars, result, tt.expected)
 			}
 		})
 	}
@@ -497,7 +564,7 @@ func TestService_PresignUpload_MultipartStrategy(t *testing.T) {
 		if result.Upload.Multipart.Complete.Method != "POST" {
 			t.Errorf("Expected complete method to be POST, got %s", result.Upload.Multipart.Complete.Method)
 		}
-		expectedCompleteURL := "https://test-api.com/v1/uploads/originals/test-video.mp4/complete/test-upload-id"
+		expectedCompleteURL := "https://test-api.com/v1/uploads/originals/test-video.mp4/complete/test-upload-id?key_base=test-video&profile=video"
 		if result.Upload.Multipart.Complete.URL != expectedCompleteURL {
 			t.Errorf("Expected complete URL '%s', got '%s'", expectedCompleteURL, result.Upload.Multipart.Complete.URL)
 		}
@@ -509,7 +576,7 @@ func TestService_PresignUpload_MultipartStrategy(t *testing.T) {
 		if result.Upload.Multipart.Abort.Method != "DELETE" {
 			t.Errorf("Expected abort method to be DELETE, got %s", result.Upload.Multipart.Abort.Method)
 		}
-		expectedAbortURL := "https://test-api.com/v1/uploads/originals/test-video.mp4/abort/test-upload-id"
+		expectedAbortURL := "https://test-api.com/v1/uploads/originals/test-video.mp4/abort/test-upload-id?key_base=test-video&profile=video"
 		if result.Upload.Multipart.Abort.URL != expectedAbortURL {
 			t.Errorf("Expected abort URL '%s', got '%s'", expectedAbortURL, result.Upload.Multipart.Abort.URL)
 		}
@@ -657,4 +724,422 @@ func TestService_PresignUpload_WithURLEncoding(t *testing.T) {
 			t.Errorf("Abort URL should contain '/v1/uploads/', got: %s", result.Upload.Multipart.Abort.URL)
 		}
 	}
-}
\ No newline at end of file
+}
+func TestService_PresignUpload_RollingBatch(t *testing.T) {
+	presigned := 0
+	mockS3 := &MockS3Client{
+		presignUploadPartFunc: func(ctx context.Context, key, uploadID string, partNumber int32, expires time.Duration) (string, error) {
+			presigned++
+			return "https://test.s3.amazonaws.com/bucket/" + key, nil
+		},
+	}
+	service := NewService(mockS3, &config.Config{S3Bucket: "test-bucket"})
+
+	profile := &config.Profile{
+		Kind:                 "video",
+		AllowedMimes:         []string{"video/mp4"},
+		SizeMaxBytes:         10 * 1024 * 1024 * 1024,
+		MultipartThresholdMB: 15,
+		PartSizeMB:           8,
+		TokenTTLSeconds:      900,
+		StoragePath:          "originals/{key_base}.{ext}",
+		InitialBatchSize:     5,
+		MaxBatchSize:         20,
+	}
+
+	request := &PresignRequest{
+		KeyBase:   "big-video",
+		Ext:       "mp4",
+		Mime:      "video/mp4",
+		SizeBytes: 2 * 1024 * 1024 * 1024, // 2GB -> 256 parts
+		Kind:      "video",
+		Profile:   "video",
+		Multipart: "auto",
+	}
+
+	result, err := service.PresignUpload(context.Background(), request, profile, "https://test-api.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	mp := result.Upload.Multipart
+	if mp.TotalPartsEst != 256 {
+		t.Errorf("Expected 256 estimated parts, got %d", mp.TotalPartsEst)
+	}
+	if len(mp.Parts) != 5 || presigned != 5 {
+		t.Errorf("Expected 5 presigned parts in initial batch, got %d (presigned %d)", len(mp.Parts), presigned)
+	}
+	if mp.BatchInfo == nil {
+		t.Fatalf("Expected batch_info to be populated")
+	}
+	if !mp.BatchInfo.HasMoreParts || mp.BatchInfo.NextPartNumber != 6 {
+		t.Errorf("Expected more parts starting at 6, got %+v", mp.BatchInfo)
+	}
+	if mp.BatchInfo.BatchEndpoint != "/v1/uploads/presign/parts" {
+		t.Errorf("Unexpected batch endpoint: %s", mp.BatchInfo.BatchEndpoint)
+	}
+}
+
+func TestService_PresignUpload_SmallMultipartFitsInitialBatch(t *testing.T) {
+	service := NewService(&MockS3Client{}, &config.Config{S3Bucket: "test-bucket"})
+
+	profile := &config.Profile{
+		Kind:                 "video",
+		AllowedMimes:         []string{"video/mp4"},
+		SizeMaxBytes:         100 * 1024 * 1024,
+		MultipartThresholdMB: 15,
+		PartSizeMB:           8,
+		TokenTTLSeconds:      900,
+		StoragePath:          "originals/{key_base}.{ext}",
+	}
+
+	request := &PresignRequest{
+		KeyBase:   "small-video",
+		Ext:       "mp4",
+		Mime:      "video/mp4",
+		SizeBytes: 50 * 1024 * 1024, // 7 parts
+		Kind:      "video",
+		Profile:   "video",
+		Multipart: "auto",
+	}
+
+	result, err := service.PresignUpload(context.Background(), request, profile, "https://test-api.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	mp := result.Upload.Multipart
+	if len(mp.Parts) != 7 {
+		t.Errorf("Expected all 7 parts in initial batch, got %d", len(mp.Parts))
+	}
+	if mp.BatchInfo.HasMoreParts || mp.BatchInfo.NextPartNumber != 0 || mp.BatchInfo.BatchEndpoint != "" {
+		t.Errorf("Expected no more parts, got %+v", mp.BatchInfo)
+	}
+}
+
+func TestService_PresignParts(t *testing.T) {
+	profile := &config.Profile{
+		Kind:              "video",
+		TokenTTLSeconds:   900,
+		MaxBatchSize:      10,
+		PartURLTTLSeconds: 1800,
+		PartSizeMB:        8,
+		SizeMaxBytes:      200 * 8 * 1024 * 1024,
+	}
+
+	tests := []struct {
+		name          string
+		request       *PresignPartsRequest
+		uploaded      int
+		listPartsErr  error
+		expectedCode  string
+		expectedParts int
+		expectedNext  int
+		expectMore    bool
+	}{
+		{
+			name:          "Next batch",
+			request:       &PresignPartsRequest{UploadID: "up", ObjectKey: "k", StartPart: 11, Count: 10, TotalParts: 125},
+			expectedParts: 10,
+			expectedNext:  21,
+			expectMore:    true,
+		},
+		{
+			name:          "Final batch is clamped to total parts",
+			request:       &PresignPartsRequest{UploadID: "up", ObjectKey: "k", StartPart: 121, Count: 10, TotalParts: 125},
+			uploaded:      115,
+			expectedParts: 5,
+			expectMore:    false,
+		},
+		{
+			name:          "Total parts is capped by the profile's size limit",
+			request:       &PresignPartsRequest{UploadID: "up", ObjectKey: "k", StartPart: 191, Count: 10, TotalParts: 5000},
+			uploaded:      190,
+			expectedParts: 10,
+			expectMore:    false,
+		},
+		{
+			name:         "Start part beyond the profile's size limit",
+			request:      &PresignPartsRequest{UploadID: "up", ObjectKey: "k", StartPart: 201, Count: 10},
+			uploaded:     200,
+			expectedCode: ErrInvalidPartRange,
+		},
+		{
+			name:         "Start part too far ahead of uploaded parts",
+			request:      &PresignPartsRequest{UploadID: "up", ObjectKey: "k", StartPart: 31, Count: 10, TotalParts: 125},
+			uploaded:     5,
+			expectedCode: ErrInvalidPartRange,
+		},
+		{
+			name:         "Batch size exceeded",
+			request:      &PresignPartsRequest{UploadID: "up", ObjectKey: "k", StartPart: 1, Count: 50},
+			expectedCode: ErrBatchSizeExceeded,
+		},
+		{
+			name:         "Start part beyond total",
+			request:      &PresignPartsRequest{UploadID: "up", ObjectKey: "k", StartPart: 130, Count: 5, TotalParts: 125},
+			expectedCode: ErrInvalidPartRange,
+		},
+		{
+			name:         "Unknown upload",
+			request:      &PresignPartsRequest{UploadID: "missing", ObjectKey: "k", StartPart: 1, Count: 5},
+			listPartsErr: storage.ErrNoSuchUpload,
+			expectedCode: ErrInvalidUploadID,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockS3 := &MockS3Client{
+				listPartsFunc: func(ctx context.Context, key, uploadID string) ([]s3.PartInfo, error) {
+					if tt.listPartsErr != nil {
+						return nil, tt.listPartsErr
+					}
+					parts := make([]s3.PartInfo, tt.uploaded)
+					for i := range parts {
+						parts[i] = s3.PartInfo{PartNumber: i + 1}
+					}
+					return parts, nil
+				},
+			}
+			service := NewService(mockS3, &config.Config{S3Bucket: "test-bucket"})
+
+			result, err := service.PresignParts(context.Background(), tt.request, profile)
+			if tt.expectedCode != "" {
+				reqErr, ok := err.(*RequestError)
+				if !ok {
+					t.Fatalf("Expected RequestError, got %v", err)
+				}
+				if reqErr.Code != tt.expectedCode {
+					t.Errorf("Expected code %s, got %s", tt.expectedCode, reqErr.Code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if len(result.Parts) != tt.expectedParts {
+				t.Errorf("Expected %d parts, got %d", tt.expectedParts, len(result.Parts))
+			}
+			if result.Parts[0].PartNumber != tt.request.StartPart {
+				t.Errorf("Expected first part %d, got %d", tt.request.StartPart, result.Parts[0].PartNumber)
+			}
+			if result.BatchInfo.HasMoreParts != tt.expectMore {
+				t.Errorf("Expected has_more_parts %t, got %t", tt.expectMore, result.BatchInfo.HasMoreParts)
+			}
+			if result.BatchInfo.NextPartNumber != tt.expectedNext {
+				t.Errorf("Expected next part %d, got %d", tt.expectedNext, result.BatchInfo.NextPartNumber)
+			}
+		})
+	}
+}
+
+func TestService_DeleteAsset_InvalidatesCache(t *testing.T) {
+	service := NewService(&MockS3Client{}, &config.Config{})
+	memory := cache.NewMemory(1<<20, nil)
+	service.SetCache(memory)
+
+	memory.Set("thumbnails/avatars/abc_256.webp", &cache.Entry{Data: []byte("a")})
+	memory.Set("thumbnails/avatars/abc_512_q60.webp", &cache.Entry{Data: []byte("b")})
+	memory.Set("thumbnails/avatars/abcd_256.webp", &cache.Entry{Data: []byte("c")})
+	memory.Set("thumbnails/avatars/abc_1_256.webp", &cache.Entry{Data: []byte("d")})
+
+	profile := &config.Profile{
+		StoragePath: "originals/avatars/{key_base}",
+		ThumbFolder: "thumbnails/avatars",
+	}
+	if _, err := service.DeleteAsset(context.Background(), profile, "", "abc"); err != nil {
+		t.Fatalf("DeleteAsset failed: %v", err)
+	}
+
+	if _, ok := memory.Peek("thumbnails/avatars/abc_256.webp"); ok {
+		t.Errorf("Expected deleted asset's thumbnail to be evicted")
+	}
+	if _, ok := memory.Peek("thumbnails/avatars/abc_512_q60.webp"); ok {
+		t.Errorf("Expected deleted asset's quality variant to be evicted")
+	}
+	for _, key := range []string{"thumbnails/avatars/abcd_256.webp", "thumbnails/avatars/abc_1_256.webp"} {
+		if _, ok := memory.Peek(key); !ok {
+			t.Errorf("Expected other asset's %s to stay cached", key)
+		}
+	}
+}
+
+func TestService_DeleteAsset_OnlyOwnThumbnails(t *testing.T) {
+	var deletedKeys []string
+	mockS3 := &MockS3Client{
+		deleteObjectFunc: func(ctx context.Context, key string) error {
+			deletedKeys = append(deletedKeys, key)
+			return nil
+		},
+		objects: map[string]string{
+			"thumbnails/avatars/abc_256.webp":         "a",
+			"thumbnails/avatars/abc_512.webp":         "b",
+			"thumbnails/avatars/abcd_256.webp":        "c",
+			"thumbnails/avatars/abc_1_256.webp":       "d",
+			"thumbnails/avatars/abc_card_256_q60.png": "e",
+			"thumbnails/avatars/abc_def_256.webp":     "f",
+		},
+	}
+	service := NewService(mockS3, &config.Config{})
+	service.SetCache(cache.NewMemory(1<<20, nil))
+
+	profile := &config.Profile{
+		StoragePath: "originals/avatars/{key_base}",
+		ThumbFolder: "thumbnails/avatars",
+		Variants:    map[string]config.Variant{"card": {Width: 256}},
+	}
+	if _, err := service.DeleteAsset(context.Background(), profile, "", "abc"); err != nil {
+		t.Fatalf("DeleteAsset failed: %v", err)
+	}
+	expected := []string{"originals/avatars/abc", "originals/avatars/abc.metadata.json", "thumbnails/avatars/abc_256.webp", "thumbnails/avatars/abc_512.webp", "thumbnails/avatars/abc_card_256_q60.png"}
+	if strings.Join(deletedKeys, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected deletes %v, got %v", expected, deletedKeys)
+	}
+}
+
+func TestService_DeleteAsset_RecordedKey(t *testing.T) {
+	var deletedKeys []string
+	mockS3 := &MockS3Client{
+		deleteObjectFunc: func(ctx context.Context, key string) error {
+			deletedKeys = append(deletedKeys, key)
+			return nil
+		},
+	}
+	service := NewService(mockS3, &config.Config{})
+
+	profile := &config.Profile{
+		Name:                 "avatar",
+		Kind:                 "image",
+		AllowedMimes:         []string{"image/jpeg"},
+		SizeMaxBytes:         1024,
+		MultipartThresholdMB: 15,
+		PartSizeMB:           8,
+		TokenTTLSeconds:      900,
+		StoragePath:          "uploads/{year}/{uuid}.{ext}",
+	}
+	presigned, err := service.PresignUpload(context.Background(), &PresignRequest{
+		KeyBase: "abc", Ext: "jpg", Mime: "image/jpeg", SizeBytes: 512, Kind: "image", Profile: "avatar", Multipart: "off",
+	}, profile, "https://test-api.com")
+	if err != nil {
+		t.Fatalf("PresignUpload failed: %v", err)
+	}
+	// Keys are only recorded once the upload is finalized or completed
+	if _, recorded := mockS3.objects[objectkey.IndexKey("avatar", "", "abc")]; recorded {
+		t.Fatal("Expected nothing to be recorded at presign time")
+	}
+	if err := service.RecordObjectKey(context.Background(), profile, "", "abc", "uploads/2025/not-a-uuid.jpg"); err == nil {
+		t.Error("Expected a key that doesn't match storage_path to be refused")
+	}
+	if err := service.RecordObjectKey(context.Background(), profile, "", "abc", presigned.ObjectKey); err != nil {
+		t.Fatalf("RecordObjectKey failed: %v", err)
+	}
+	if recorded := mockS3.objects[objectkey.IndexKey("avatar", "", "abc")]; recorded != presigned.ObjectKey {
+		t.Fatalf("Expected %s to be recorded, got %q", presigned.ObjectKey, recorded)
+	}
+
+	if _, err := service.DeleteAsset(context.Background(), profile, "", "abc"); err != nil {
+		t.Fatalf("DeleteAsset failed: %v", err)
+	}
+	expected := []string{presigned.ObjectKey, objectkey.MetadataKey(presigned.ObjectKey), objectkey.IndexKey("avatar", "", "abc")}
+	if strings.Join(deletedKeys, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected deletes %v, got %v", expected, deletedKeys)
+	}
+
+	// Nothing is recorded for an asset that was never uploaded
+	deletedKeys = nil
+	if _, err := service.DeleteAsset(context.Background(), profile, "", "missing"); err != nil {
+		t.Fatalf("DeleteAsset of a missing asset failed: %v", err)
+	}
+	if len(deletedKeys) != 1 || deletedKeys[0] != objectkey.IndexKey("avatar", "", "missing") {
+		t.Errorf("Expected only the index key to be deleted, got %v", deletedKeys)
+	}
+}
+
+func TestService_RecordObjectKey_Tenants(t *testing.T) {
+	mockS3 := &MockS3Client{}
+	service := NewService(mockS3, &config.Config{})
+	profile := &config.Profile{
+		Name:                 "avatar",
+		Kind:                 "image",
+		AllowedMimes:         []string{"image/jpeg"},
+		SizeMaxBytes:         1024,
+		MultipartThresholdMB: 15,
+		PartSizeMB:           8,
+		TokenTTLSeconds:      900,
+		StoragePath:          "uploads/{tenant}/{uuid}.{ext}",
+	}
+
+	// Two tenants upload the same key_base
+	keys := map[string]string{}
+	for _, tenant := range []string{"acme", "globex"} {
+		presigned, err := service.PresignUpload(context.Background(), &PresignRequest{
+			KeyBase: "abc", Ext: "jpg", Mime: "image/jpeg", SizeBytes: 512, Kind: "image", Profile: "avatar", Multipart: "off", Tenant: tenant,
+		}, profile, "https://test-api.com")
+		if err != nil {
+			t.Fatalf("PresignUpload failed: %v", err)
+		}
+		if finalizeURL := presigned.Upload.Single.Finalize.URL; !strings.Contains(finalizeURL, "tenant="+tenant) {
+			t.Errorf("Expected the finalize URL to carry the tenant, got %s", finalizeURL)
+		}
+		keys[tenant] = presigned.ObjectKey
+	}
+
+	// A key is only accepted for the tenant it was presigned for
+	if err := service.CheckObjectKey(profile, "globex", "abc", keys["acme"]); err == nil {
+		t.Error("Expected another tenant's key to be refused")
+	}
+	if err := service.CheckObjectKey(profile, "", "abc", keys["acme"]); err == nil {
+		t.Error("Expected a tenant's key to be refused without the tenant")
+	}
+
+	for tenant, key := range keys {
+		if err := service.RecordObjectKey(context.Background(), profile, tenant, "abc", key); err != nil {
+			t.Fatalf("RecordObjectKey failed: %v", err)
+		}
+	}
+	for tenant, key := range keys {
+		if recorded := mockS3.objects[objectkey.IndexKey("avatar", tenant, "abc")]; recorded != key {
+			t.Errorf("Expected %s to be recorded for %s, got %q", key, tenant, recorded)
+		}
+	}
+}
+
+func TestService_PresignUpload_Shard(t *testing.T) {
+	service := NewService(&MockS3Client{}, &config.Config{})
+	profile := &config.Profile{
+		Kind:                 "image",
+		AllowedMimes:         []string{"image/jpeg"},
+		SizeMaxBytes:         1024,
+		MultipartThresholdMB: 15,
+		PartSizeMB:           8,
+		TokenTTLSeconds:      900,
+		StoragePath:          "originals/{shard?}/{key_base}",
+		EnableSharding:       true,
+		ShardHash:            "sha256",
+		ShardDepth:           2,
+	}
+	presign := func(shard string) (*PresignResponse, error) {
+		return service.PresignUpload(context.Background(), &PresignRequest{
+			KeyBase: "abc", Ext: "jpg", Mime: "image/jpeg", SizeBytes: 512, Kind: "image", Profile: "avatar", Multipart: "off", Shard: shard,
+		}, profile, "https://test-api.com")
+	}
+
+	for _, shard := range []string{"", "ba/78"} {
+		result, err := presign(shard)
+		if err != nil {
+			t.Fatalf("PresignUpload with shard %q failed: %v", shard, err)
+		}
+		if result.ObjectKey != "originals/ba/78/abc" {
+			t.Errorf("Expected originals/ba/78/abc, got %s", result.ObjectKey)
+		}
+	}
+
+	// Reads derive the shard from key_base, so a different one would make the asset unreachable
+	_, err := presign("a9")
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) || reqErr.Code != ErrBadRequest {
+		t.Errorf("Expected a bad_request error for a mismatched shard, got %v", err)
+	}
+}
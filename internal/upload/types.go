@@ -1,6 +1,10 @@
 package upload
 
-import "time"
+import (
+	"time"
+
+	"mediaflow/internal/config"
+)
 
 // PresignRequest represents the request to generate presigned URLs
 type PresignRequest struct {
@@ -12,6 +16,7 @@ type PresignRequest struct {
 	Profile   string `json:"profile" validate:"required"`
 	Multipart string `json:"multipart" validate:"oneof=auto force off"`
 	Shard     string `json:"shard,omitempty"`
+	Tenant    string `json:"tenant,omitempty"` // Fills {tenant} in storage_path
 }
 
 // PresignResponse represents the response containing presigned URLs
@@ -32,16 +37,27 @@ type SingleUpload struct {
 	URL       string            `json:"url"`
 	Headers   map[string]string `json:"headers"`
 	ExpiresAt time.Time         `json:"expires_at"`
+	Finalize  *UploadAction     `json:"finalize,omitempty"` // Call after the PUT succeeds
 }
 
 // MultipartUpload contains details for multipart upload
 type MultipartUpload struct {
-	UploadID string        `json:"upload_id"`
-	PartSize int64         `json:"part_size"`
-	Create   *UploadAction `json:"create"`
-	Parts    []PartUpload  `json:"parts"` // Pre-generated part URLs
-	Complete *UploadAction `json:"complete"`
-	Abort    *UploadAction `json:"abort"`
+	UploadID      string        `json:"upload_id"`
+	PartSize      int64         `json:"part_size"`
+	TotalPartsEst int           `json:"total_parts_estimated"`
+	Create        *UploadAction `json:"create"`
+	Parts         []PartUpload  `json:"parts"` // Pre-generated part URLs (first batch)
+	BatchInfo     *BatchInfo    `json:"batch_info,omitempty"`
+	Complete      *UploadAction `json:"complete"`
+	Abort         *UploadAction `json:"abort"`
+}
+
+// BatchInfo describes a batch of presigned part URLs and where to get the next one
+type BatchInfo struct {
+	PartsInBatch   int    `json:"parts_in_batch"`
+	NextPartNumber int    `json:"next_part_number,omitempty"`
+	HasMoreParts   bool   `json:"has_more_parts"`
+	BatchEndpoint  string `json:"batch_endpoint,omitempty"`
 }
 
 // UploadAction represents an upload action (create, complete, abort)
@@ -61,28 +77,31 @@ type PartUpload struct {
 	ExpiresAt  time.Time         `json:"expires_at"`
 }
 
-
-// UploadPolicy defines upload constraints for different kinds and profiles
-type UploadPolicy struct {
-	Kind            string   `yaml:"kind"`
-	Profile         string   `yaml:"profile"`
-	AllowedMimes    []string `yaml:"allowed_mimes"`
-	SizeMaxBytes    int64    `yaml:"size_max_bytes"`
-	MultipartThresh int64    `yaml:"multipart_threshold_bytes"`
+// PresignPartsRequest represents the request for an additional batch of part URLs
+type PresignPartsRequest struct {
+	UploadID       string `json:"upload_id" validate:"required"`
+	ObjectKey      string `json:"object_key" validate:"required"`
+	Profile        string `json:"profile" validate:"required"`
+	KeyBase        string `json:"key_base" validate:"required"`
+	Tenant         string `json:"tenant,omitempty"` // The tenant the upload was presigned for
+	StartPart      int    `json:"start_part" validate:"required,min=1"`
+	Count          int    `json:"count" validate:"required,min=1"`
+	TotalParts     int    `json:"total_parts,omitempty"`
+	ExpiresSeconds int64  `json:"expires_seconds,omitempty"`
 }
 
-// UploadConfig contains upload-related configuration
-type UploadConfig struct {
-	MultipartThresholdMB int64           `yaml:"multipart_threshold_mb"`
-	PartSizeMB          int64           `yaml:"part_size_mb"`
-	TokenTTLSeconds     int64           `yaml:"token_ttl_seconds"`
-	SigningAlgorithm    string          `yaml:"signing_alg"`
-	ActiveKeyID         string          `yaml:"active_kid"`
-	StoragePathRaw      string          `yaml:"storage_path_raw"`
-	EnableSharding      bool            `yaml:"enable_sharding"`
-	Policies            []UploadPolicy  `yaml:"policies"`
+// PresignPartsResponse represents a batch of presigned part URLs
+type PresignPartsResponse struct {
+	Parts     []PartUpload `json:"parts"`
+	BatchInfo *BatchInfo   `json:"batch_info"`
 }
 
+// UploadPolicy and UploadConfig live in config so the storage config can carry them
+type (
+	UploadPolicy = config.UploadPolicy
+	UploadConfig = config.UploadConfig
+)
+
 // ErrorResponse represents error responses from the upload API
 type ErrorResponse struct {
 	Code              string `json:"code"`
@@ -94,6 +113,43 @@ type ErrorResponse struct {
 // CompleteMultipartRequest represents the request to complete a multipart upload
 type CompleteMultipartRequest struct {
 	Parts []CompletedPart `json:"parts" validate:"required,min=1"`
+	// Optional: queue thumbnail processing for this profile once the upload completes
+	Profile string `json:"profile,omitempty"`
+	KeyBase string `json:"key_base,omitempty"` // Defaults to the object key's file name without extension
+	Tenant  string `json:"tenant,omitempty"`   // The tenant the upload was presigned for
+}
+
+// FinalizeRequest represents the request to finalize a single PUT upload
+type FinalizeRequest struct {
+	Profile string `json:"profile" validate:"required"`
+	KeyBase string `json:"key_base,omitempty"` // Defaults to the object key's file name without extension
+	Tenant  string `json:"tenant,omitempty"`   // The tenant the upload was presigned for
+}
+
+// FinalizeResponse is returned once an upload has been finalized
+type FinalizeResponse struct {
+	Status    string `json:"status"`
+	ObjectKey string `json:"object_key"`
+	JobID     string `json:"job_id,omitempty"`
+}
+
+// TokenRequest represents the request to mint a scoped upload token
+type TokenRequest struct {
+	Profile       string `json:"profile" validate:"required"`
+	KeyBasePrefix string `json:"key_base_prefix,omitempty"`
+	MaxSizeBytes  int64  `json:"max_size_bytes,omitempty"` // Defaults to the profile's size_max_bytes
+	TTLSeconds    int64  `json:"ttl_seconds,omitempty"`    // Defaults to and is capped by upload.token_ttl_seconds
+}
+
+// ReadTokenRequest represents the optional body of a request to mint a read token
+type ReadTokenRequest struct {
+	TTLSeconds int64 `json:"ttl_seconds,omitempty"` // Defaults to and is capped by upload.token_ttl_seconds
+}
+
+// TokenResponse contains a signed upload or read token
+type TokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
 // CompletedPart represents a completed part with its ETag
@@ -102,6 +158,17 @@ type CompletedPart struct {
 	ETag       string `json:"etag" validate:"required"`
 }
 
+// RequestError is returned by the service for client errors that map to a specific error code
+type RequestError struct {
+	Code    string
+	Message string
+	Hint    string
+}
+
+func (e *RequestError) Error() string {
+	return e.Message
+}
+
 // Standard error codes
 const (
 	ErrUnauthorized      = "unauthorized"
@@ -111,4 +178,12 @@ const (
 	ErrStorageDenied     = "storage_denied"
 	ErrBadRequest        = "bad_request"
 	ErrRateLimited       = "rate_limited"
+	ErrInvalidUploadID   = "invalid_upload_id"
+	ErrInvalidPartRange  = "invalid_part_range"
+	ErrBatchSizeExceeded = "batch_size_exceeded"
+	ErrObjectNotFound    = "object_not_found"
+	ErrTokenScope        = "token_scope"
+	ErrTokensDisabled    = "tokens_disabled"
+	ErrForbidden         = "forbidden"
+	ErrObjectKeyMismatch = "object_key_mismatch"
 )
\ No newline at end of file
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -45,3 +46,42 @@ func ExtFromMime(mime string) string {
 	}
 	return ""
 }
+
+// TempFilePrefix starts the names of the temp files WriteFileAtomic writes, so directory scans can skip them
+const TempFilePrefix = ".tmp-"
+
+// WriteFileAtomic writes body to a temp file next to dst and renames it into place,
+// so readers never observe a partially written file
+func WriteFileAtomic(dst string, body io.Reader) error {
+	tmp, err := WriteTempFile(dst, body)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// WriteTempFile writes body to a temp file next to dst, creating its directory, and returns its path
+func WriteTempFile(dst string, body io.Reader) (string, error) {
+	dir := filepath.Dir(dst)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(dir, TempFilePrefix+"*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
@@ -15,62 +15,161 @@ import (
 	"mediaflow/internal/api"
 	"mediaflow/internal/auth"
 	"mediaflow/internal/config"
+	"mediaflow/internal/jobs"
 	"mediaflow/internal/response"
 	"mediaflow/internal/service"
+	"mediaflow/internal/storage"
 	"mediaflow/internal/upload"
 )
 
-// methodBasedAuth applies authentication middleware only to specific HTTP methods
-func methodBasedAuth(authMiddleware func(http.Handler) http.Handler, handler http.HandlerFunc) http.Handler {
+// jobQueueSize is the number of processing jobs that can wait for a worker
+const jobQueueSize = 256
+
+// methodBasedAuth applies authentication middleware to write methods and read middleware to the rest
+func methodBasedAuth(authMiddleware, readMiddleware func(http.Handler) http.Handler, handler http.HandlerFunc) http.Handler {
+	write := authMiddleware(handler)
+	// Read methods (GET, HEAD, OPTIONS) only need credentials for private profiles
+	read := readMiddleware(handler)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodPost || r.Method == http.MethodPut || r.Method == http.MethodPatch || r.Method == http.MethodDelete {
-			authMiddleware(http.HandlerFunc(handler)).ServeHTTP(w, r)
+			write.ServeHTTP(w, r)
 		} else {
-			// No authentication for read methods (GET, HEAD, OPTIONS)
-			handler(w, r)
+			read.ServeHTTP(w, r)
 		}
 	})
 }
 
+// loadKeyring collects API keys from the storage config, API_KEYS_FILE and the legacy API_KEY
+func loadKeyring(cfg *config.Config, storageConfig *config.StorageConfig) (*auth.Keyring, error) {
+	keys := append([]auth.Key(nil), storageConfig.APIKeys...)
+	if cfg.APIKeysFile != "" {
+		fileKeys, err := auth.LoadKeyringFile(cfg.APIKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, fileKeys...)
+	}
+	if cfg.APIKey != "" {
+		keys = append(keys, auth.LegacyKey(cfg.APIKey))
+	}
+	keyring, err := auth.NewKeyring(keys)
+	if err != nil {
+		return nil, err
+	}
+	if keyring.Len() == 0 {
+		fmt.Println("⚠️ No API keys configured, authentication is disabled")
+	}
+	return keyring, nil
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "reshard" {
+		os.Exit(runReshard(os.Args[2:]))
+	}
+
 	cfg := config.Load()
 	ctx := context.Background()
 	utils.ProcessId <- os.Getpid()
 	imageService := service.NewImageService(cfg)
-	storageConfig, err := config.LoadStorageConfig(imageService.S3Client, cfg)
+	// Profiles may only ask for formats the linked libvips can encode, at startup and on reload
+	loadStorageConfig := func() (*config.StorageConfig, error) {
+		storageConfig, err := config.LoadStorageConfig(imageService.Storage, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return storageConfig, service.CheckOutputFormats(storageConfig)
+	}
+	storageConfig, err := loadStorageConfig()
 	if err != nil {
 		log.Fatalf("🚨 Failed to load storage config: %v", err)
 	}
-	imageAPI := api.NewImageAPI(ctx, imageService, storageConfig)
+	// Profiles are read from the store per request, so reloads apply without a restart
+	configStore := config.NewStore(storageConfig, loadStorageConfig)
+	imageAPI := api.NewImageAPI(ctx, imageService, configStore)
+	imageAPI.SetTransformSecret(cfg.TransformSecret)
 
 	// Setup upload service and handlers
-	uploadService := upload.NewService(imageService.S3Client, cfg)
-	uploadHandler := upload.NewHandler(ctx, uploadService, storageConfig)
+	uploadService := upload.NewService(imageService.Storage, cfg)
+	uploadService.SetCache(imageService.Cache)
+
+	// Post-upload processing for presigned uploads
+	jobQueue := jobs.NewQueue(cfg.JobWorkers, jobQueueSize, func(ctx context.Context, job *jobs.Job) ([]string, error) {
+		profile := configStore.Current().GetProfile(job.Profile)
+		if profile == nil {
+			return nil, fmt.Errorf("unknown profile: %s", job.Profile)
+		}
+		return imageService.ProcessOriginal(ctx, profile, job.ObjectKey, job.KeyBase)
+	})
+	jobQueue.Start(ctx)
+	uploadService.SetProcessor(jobQueue)
+	uploadHandler := upload.NewHandler(ctx, uploadService, configStore)
 
 	// Setup authentication middleware
-	authConfig := &auth.Config{APIKey: cfg.APIKey}
+	keyring, err := loadKeyring(cfg, storageConfig)
+	if err != nil {
+		log.Fatalf("🚨 Failed to load API keys: %v", err)
+	}
+	authConfig := &auth.Config{Keyring: keyring}
 	authMiddleware := auth.APIKeyMiddleware(authConfig)
 
+	// Scoped upload tokens, accepted in place of the API key on upload routes, and read tokens for private profiles
+	var tokenIssuer *auth.TokenIssuer
+	if cfg.UploadSigningKeys != "" {
+		tokenIssuer, err = auth.NewTokenIssuer(storageConfig.Upload.SigningAlgorithm, storageConfig.Upload.ActiveKeyID, cfg.UploadSigningKeys)
+		if err != nil {
+			log.Fatalf("🚨 Failed to configure upload tokens: %v", err)
+		}
+		uploadHandler.SetTokenIssuer(tokenIssuer)
+	}
+	uploadAuth := auth.UploadTokenMiddleware(tokenIssuer, authMiddleware)
+	readAuth := auth.ReadTokenMiddleware(tokenIssuer, auth.OptionalAPIKeyMiddleware(authConfig), api.ReadAsset)
+
 	mux := http.NewServeMux()
 
 	// Image APIs
-	mux.Handle("/thumb/{type}/{image_id}", methodBasedAuth(authMiddleware, imageAPI.HandleThumbnailTypes))
-	mux.Handle("/originals/{type}/{image_id}", authMiddleware(http.HandlerFunc(imageAPI.HandleOriginals)))
-
-	// Upload APIs (auth required)
-	mux.Handle("/v1/uploads/presign", authMiddleware(http.HandlerFunc(uploadHandler.HandlePresign)))
+	mux.Handle("/thumb/{type}/{image_id}", methodBasedAuth(authMiddleware, readAuth, imageAPI.HandleThumbnailTypes))
+	mux.Handle("/originals/{type}/{image_id}", auth.ReadTokenMiddleware(tokenIssuer, authMiddleware, api.ReadAsset)(http.HandlerFunc(imageAPI.HandleOriginals)))
+	// Signed transformation URLs (authorized by URL signature, plus a read token for private profiles)
+	mux.Handle("/t/", readAuth(http.HandlerFunc(imageAPI.HandleTransform)))
+
+	// Upload APIs (API key or upload token required)
+	mux.Handle("/v1/uploads/tokens", authMiddleware(http.HandlerFunc(uploadHandler.HandleCreateToken)))
+	mux.Handle("/v1/uploads/presign", uploadAuth(http.HandlerFunc(uploadHandler.HandlePresign)))
+	mux.Handle("/v1/uploads/presign/parts", uploadAuth(http.HandlerFunc(uploadHandler.HandlePresignParts)))
 	mux.HandleFunc("/v1/uploads/", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodPost && strings.Contains(r.URL.Path, "/complete/") {
-			authMiddleware(http.HandlerFunc(uploadHandler.HandleCompleteMultipart)).ServeHTTP(w, r)
+			uploadAuth(http.HandlerFunc(uploadHandler.HandleCompleteMultipart)).ServeHTTP(w, r)
+		} else if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/finalize") {
+			uploadAuth(http.HandlerFunc(uploadHandler.HandleFinalize)).ServeHTTP(w, r)
 		} else if r.Method == http.MethodDelete && strings.Contains(r.URL.Path, "/abort/") {
-			authMiddleware(http.HandlerFunc(uploadHandler.HandleAbortMultipart)).ServeHTTP(w, r)
+			uploadAuth(http.HandlerFunc(uploadHandler.HandleAbortMultipart)).ServeHTTP(w, r)
 		} else {
 			http.NotFound(w, r)
 		}
 	})
 
-	// Asset deletion (auth required)
-	mux.Handle("/v1/assets/", authMiddleware(http.HandlerFunc(uploadHandler.HandleDeleteAsset)))
+	// Presigned URLs for the local storage backend (authorized by URL signature)
+	if localBackend, ok := imageService.Storage.(*storage.LocalBackend); ok {
+		mux.Handle(storage.LocalPresignPath, localBackend)
+	}
+
+	// Processing job status (API key, or an upload token for the job's upload)
+	mux.Handle("/v1/jobs/", uploadAuth(http.HandlerFunc(jobQueue.HandleGetJob)))
+
+	// Cache counters (auth required)
+	mux.Handle("/v1/cache/stats", authMiddleware(http.HandlerFunc(imageAPI.HandleCacheStats)))
+
+	// Image metadata (public, or a read token or assets:read key for private profiles)
+	mux.Handle("GET /v1/assets/{profile}/{key_base}/metadata", readAuth(http.HandlerFunc(imageAPI.HandleMetadata)))
+
+	// Asset deletion and read tokens (auth required)
+	mux.Handle("/v1/assets/", authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/token") {
+			uploadHandler.HandleCreateReadToken(w, r)
+		} else {
+			uploadHandler.HandleDeleteAsset(w, r)
+		}
+	})))
 
 	// Health check
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -92,6 +191,12 @@ func main() {
 		}
 	}()
 
+	// Reload the storage config on SIGHUP and, if configured, on an interval
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	go configStore.Watch(watchCtx, reloadChan, time.Duration(cfg.StorageConfigPollSeconds)*time.Second)
+
 	signal.Notify(utils.QuitChan, syscall.SIGINT, syscall.SIGTERM)
 	<-utils.QuitChan
 
@@ -102,6 +207,8 @@ func main() {
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown 🚨: %v", err)
 	}
+	stopWatch()
+	jobQueue.Stop()
 
 	log.Println("Server exited")
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"mediaflow/internal/config"
+	"mediaflow/internal/reshard"
+	"mediaflow/internal/service"
+)
+
+// runReshard implements `mediaflow reshard`: it moves a profile's originals from the layout in an
+// old storage config to the layout in the current one (STORAGE_CONFIG_PATH). Returns the exit code.
+func runReshard(args []string) int {
+	flags := flag.NewFlagSet("reshard", flag.ContinueOnError)
+	fromPath := flags.String("from", "", "storage config the originals were uploaded with")
+	profileName := flags.String("profile", "", "profile to reshard")
+	dryRun := flags.Bool("dry-run", false, "list the moves without making them")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: mediaflow reshard -from old-storage-config.yaml -profile NAME [-dry-run]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *fromPath == "" || *profileName == "" {
+		flags.Usage()
+		return 2
+	}
+
+	cfg := config.Load()
+	ctx := context.Background()
+	backend, err := service.NewStorageBackend(cfg)
+	if err != nil {
+		fmt.Printf("🚨 Failed to create storage backend: %v\n", err)
+		return 1
+	}
+	current, err := config.LoadStorageConfig(backend, cfg)
+	if err != nil {
+		fmt.Printf("🚨 Failed to load storage config: %v\n", err)
+		return 1
+	}
+	data, err := os.ReadFile(*fromPath)
+	if err != nil {
+		fmt.Printf("🚨 Failed to read %s: %v\n", *fromPath, err)
+		return 1
+	}
+	previous, err := config.ParseStorageConfig(data)
+	if err != nil {
+		fmt.Printf("🚨 Failed to load %s: %v\n", *fromPath, err)
+		return 1
+	}
+
+	from, to := previous.GetProfile(*profileName), current.GetProfile(*profileName)
+	if from == nil || to == nil {
+		fmt.Printf("🚨 Profile %s must exist in both storage configs\n", *profileName)
+		return 1
+	}
+
+	moves, err := reshard.Plan(ctx, backend, from, to)
+	if err != nil {
+		fmt.Printf("🚨 Failed to plan resharding: %v\n", err)
+		return 1
+	}
+	if *dryRun {
+		for _, move := range moves {
+			fmt.Printf("%s -> %s\n", move.From, move.To)
+		}
+		fmt.Printf("🔍 %d originals would move\n", len(moves))
+		return 0
+	}
+
+	moved, err := reshard.Apply(ctx, backend, moves, os.Stdout)
+	if err != nil {
+		fmt.Printf("🚨 Resharding stopped after %d of %d originals: %v\n", moved, len(moves), err)
+		return 1
+	}
+	fmt.Printf("✅ Moved %d of %d originals\n", moved, len(moves))
+	return 0
+}